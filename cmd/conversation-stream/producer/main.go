@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -39,8 +40,8 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
-	stream, err := client.StreamConversation(ctx)
-	if err != nil {
+	p := &producer{client: client, sessionID: *sessionID}
+	if err := p.reconnect(ctx); err != nil {
 		log.Fatalf("failed to open stream: %v", err)
 	}
 
@@ -48,7 +49,6 @@ func main() {
 	log.Println("Type lines and press ENTER to send. Ctrl+D (EOF) to finish.")
 
 	scanner := bufio.NewScanner(os.Stdin)
-	messageCounter := 0
 
 	for scanner.Scan() {
 		text := strings.TrimSpace(scanner.Text())
@@ -56,8 +56,9 @@ func main() {
 			continue
 		}
 
-		messageCounter++
-		msgID := fmt.Sprintf("msg-%d", messageCounter)
+		// Message ids are unique across runs and senders, so that a restarted
+		// producer or another sender on the session never reuses one
+		msgID := uuid.NewString()
 
 		chunk := &conversationv1.ConversationChunk{
 			SessionId:   *sessionID,
@@ -65,14 +66,15 @@ func main() {
 			Sender:      *sender,
 			Text:        text,
 			TimestampMs: time.Now().UnixMilli(),
+			Sequence:    p.nextSequence(),
 			Metadata: map[string]string{
 				"source": "cli-producer",
 			},
 		}
 
-		log.Printf("grpcProducer:main:Sending chunk: session=%s msg_id=%s text=%q", chunk.SessionId, chunk.MessageId, chunk.Text)
+		log.Printf("grpcProducer:main:Sending chunk: session=%s msg_id=%s seq=%d text=%q", chunk.SessionId, chunk.MessageId, chunk.Sequence, chunk.Text)
 
-		if err := stream.Send(chunk); err != nil {
+		if err := p.send(ctx, chunk); err != nil {
 			log.Fatalf("failed to send chunk: %v", err)
 		}
 	}
@@ -83,11 +85,104 @@ func main() {
 
 	log.Println("Closing stream and waiting for ACK...")
 
-	ack, err := stream.CloseAndRecv()
+	ack, err := p.close(ctx)
 	if err != nil {
 		log.Fatalf("failed to receive ACK: %v", err)
 	}
 
-	log.Printf("grpcProducer:Received ACK: session_id=%s last_message_id=%s success=%v message=%q",
-		ack.SessionId, ack.LastMessageId, ack.Success, ack.Message)
+	log.Printf("grpcProducer:Received ACK: session_id=%s last_message_id=%s last_processed_seq=%d success=%v message=%q",
+		ack.SessionId, ack.LastMessageId, ack.LastProcessedSequence, ack.Success, ack.Message)
+}
+
+// maxReconnects bounds the reconnection attempts of a single send or close
+const maxReconnects = 5
+
+// producer streams the chunks of one session and keeps the ones the server
+// hasn't confirmed, so that it can resend them after reconnecting
+type producer struct {
+	client    conversationv1.ConversationStreamClient
+	sessionID string
+	stream    conversationv1.ConversationStream_StreamConversationClient
+	unacked   []*conversationv1.ConversationChunk
+	sequence  uint64 // last sequence assigned
+}
+
+// nextSequence numbers the next chunk of the session
+func (p *producer) nextSequence() uint64 {
+	p.sequence++
+	return p.sequence
+}
+
+// send sends a chunk, reconnecting and resending the unacked tail on failure
+func (p *producer) send(ctx context.Context, chunk *conversationv1.ConversationChunk) error {
+	p.unacked = append(p.unacked, chunk)
+	err := p.stream.Send(chunk)
+	for attempt := 1; err != nil; attempt++ {
+		if attempt > maxReconnects {
+			return err
+		}
+		log.Printf("stream broken (%v), reconnecting (attempt %d)", err, attempt)
+		time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+		err = p.reconnect(ctx)
+	}
+	return nil
+}
+
+// close closes the stream and returns its ACK, reconnecting if it broke
+func (p *producer) close(ctx context.Context) (*conversationv1.AnalyticsAck, error) {
+	ack, err := p.stream.CloseAndRecv()
+	for attempt := 1; err != nil; attempt++ {
+		if attempt > maxReconnects {
+			return nil, err
+		}
+		log.Printf("stream broken (%v), reconnecting (attempt %d)", err, attempt)
+		time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+		if err = p.reconnect(ctx); err != nil {
+			continue
+		}
+		ack, err = p.stream.CloseAndRecv()
+	}
+	p.trim(ack.LastProcessedSequence)
+	if len(p.unacked) > 0 {
+		log.Printf("warning: %d chunks were not confirmed as processed", len(p.unacked))
+	}
+	return ack, nil
+}
+
+// reconnect opens a new stream, asks the server where to resume and resends
+// every chunk it hasn't processed
+func (p *producer) reconnect(ctx context.Context) error {
+	resume, err := p.client.ResumeFrom(ctx, &conversationv1.ResumeRequest{SessionId: p.sessionID})
+	if err != nil {
+		return fmt.Errorf("resume handshake failed: %w", err)
+	}
+	p.trim(resume.LastProcessedSequence)
+	// A restarted producer continues a live session after its last sequence
+	if resume.LastProcessedSequence > p.sequence {
+		p.sequence = resume.LastProcessedSequence
+	}
+
+	stream, err := p.client.StreamConversation(ctx)
+	if err != nil {
+		return err
+	}
+	p.stream = stream
+	if len(p.unacked) > 0 {
+		log.Printf("Resuming session=%s after seq=%d, resending %d chunks", p.sessionID, resume.LastProcessedSequence, len(p.unacked))
+	}
+	for _, chunk := range p.unacked {
+		if err := stream.Send(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trim forgets the chunks up to the last processed sequence
+func (p *producer) trim(lastProcessed uint64) {
+	i := 0
+	for i < len(p.unacked) && p.unacked[i].Sequence <= lastProcessed {
+		i++
+	}
+	p.unacked = p.unacked[i:]
 }
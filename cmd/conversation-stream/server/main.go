@@ -2,25 +2,451 @@ package main
 
 //nice
 import (
+	"context"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/cluster"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/engine"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/escalation"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/events"
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/grpcserver"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/snapshot"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/workers"
 	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 )
 
 func main() {
-	lis, err := net.Listen("tcp", ":50051")
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = ":50051"
+	}
+	lis, err := net.Listen("tcp", grpcAddr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
-	conversationv1.RegisterConversationStreamServer(grpcServer, grpcserver.NewConversationServer())
+	// The server still streams without a database, but then evaluates no rules
+	repo, err := db.NewRepository()
+	if err != nil {
+		log.Printf("Database unavailable, rules will not be evaluated: %v", err)
+	}
+
+	// Escalation events are persisted for resuming subscribers when the database is up
+	hub := events.NewHub(repo)
+
+	// Background loops run until the shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var scheduler *escalation.Scheduler
+	schedulerDone := make(chan struct{})
+	if repo != nil {
+		scheduler = escalation.NewScheduler(repo, escalation.LogDispatcher)
+		scheduler.SetEventHub(hub)
+		go func() {
+			defer close(schedulerDone)
+			scheduler.Start(ctx)
+		}()
+	} else {
+		close(schedulerDone)
+	}
+
+	eng := engine.NewEngine(repo, scheduler)
+	if modelPath := os.Getenv("INTENT_MODEL_PATH"); modelPath != "" {
+		classifier, err := core.LoadClassifier(modelPath)
+		if err != nil {
+			log.Fatalf("failed to load intent model: %v", err)
+		}
+		eng.SetClassifier(classifier)
+	}
+
+	// Out-of-order chunks wait up to REORDER_MAX_WAIT for the ones before them;
+	// LATE_CHUNK_POLICY (process, drop or reevaluate) handles those arriving later
+	reorder := engine.DefaultReorderConfig()
+	if v := os.Getenv("REORDER_MAX_WAIT"); v != "" {
+		reorder.MaxWait, err = time.ParseDuration(v)
+		if err != nil || reorder.MaxWait < 0 {
+			log.Fatalf("invalid REORDER_MAX_WAIT %q", v)
+		}
+	}
+	if v := os.Getenv("LATE_CHUNK_POLICY"); v != "" {
+		reorder.Late, err = core.ParseLatePolicy(v)
+		if err != nil {
+			log.Fatalf("invalid LATE_CHUNK_POLICY: %v", err)
+		}
+	}
+	eng.SetReorder(reorder)
+
+	// Sessions without a chunk for SESSION_IDLE_TTL are finalized and released
+	idleTTL := 30 * time.Minute
+	if v := os.Getenv("SESSION_IDLE_TTL"); v != "" {
+		idleTTL, err = time.ParseDuration(v)
+		if err != nil || idleTTL <= 0 {
+			log.Fatalf("invalid SESSION_IDLE_TTL %q", v)
+		}
+	}
+
+	// WORKERS workers, resizable at runtime through /workers, each queue up to
+	// WORKER_QUEUE_CAPACITY chunks; when full, WORKER_OVERFLOW_POLICY blocks
+	// for WORKER_BLOCK_TIMEOUT (block), rejects the chunk (reject) or sheds
+	// lower priority chunks (shed). Higher priority lanes go first, but a
+	// session waiting WORKER_MAX_STARVATION in a lower one is served next.
+	pool := workers.DefaultConfig()
+	if v := os.Getenv("WORKERS"); v != "" {
+		pool.NumWorkers, err = strconv.Atoi(v)
+		if err != nil || pool.NumWorkers <= 0 {
+			log.Fatalf("invalid WORKERS %q", v)
+		}
+	}
+	if v := os.Getenv("WORKER_QUEUE_CAPACITY"); v != "" {
+		pool.QueueCapacity, err = strconv.Atoi(v)
+		if err != nil || pool.QueueCapacity <= 0 {
+			log.Fatalf("invalid WORKER_QUEUE_CAPACITY %q", v)
+		}
+	}
+	if v := os.Getenv("WORKER_OVERFLOW_POLICY"); v != "" {
+		pool.Overflow, err = workers.ParseOverflowPolicy(v)
+		if err != nil {
+			log.Fatalf("invalid WORKER_OVERFLOW_POLICY: %v", err)
+		}
+	}
+	if v := os.Getenv("WORKER_BLOCK_TIMEOUT"); v != "" {
+		pool.BlockTimeout, err = time.ParseDuration(v)
+		if err != nil || pool.BlockTimeout < 0 {
+			log.Fatalf("invalid WORKER_BLOCK_TIMEOUT %q", v)
+		}
+	}
+	if v := os.Getenv("WORKER_MAX_STARVATION"); v != "" {
+		pool.MaxStarvation, err = time.ParseDuration(v)
+		if err != nil || pool.MaxStarvation < 0 {
+			log.Fatalf("invalid WORKER_MAX_STARVATION %q", v)
+		}
+	}
+
+	// Chunks of PRIORITY_CLIENTS (e.g. "acme=high,bulkco=low") get their
+	// client's priority, and chunks of sessions at risk or escalated at least
+	// PRIORITY_ELEVATED (high by default)
+	priorities := grpcserver.DefaultPriorityRules()
+	if v := os.Getenv("PRIORITY_CLIENTS"); v != "" {
+		priorities.Clients, err = grpcserver.ParseClientPriorities(v)
+		if err != nil {
+			log.Fatalf("invalid PRIORITY_CLIENTS: %v", err)
+		}
+	}
+	if v := os.Getenv("PRIORITY_ELEVATED"); v != "" {
+		priorities.Elevated, err = workers.ParsePriority(v)
+		if err != nil {
+			log.Fatalf("invalid PRIORITY_ELEVATED: %v", err)
+		}
+	}
+
+	// On SIGINT/SIGTERM open streams get STREAM_DRAIN_TIMEOUT to finish and
+	// the whole shutdown SHUTDOWN_TIMEOUT
+	streamGrace := 10 * time.Second
+	if v := os.Getenv("STREAM_DRAIN_TIMEOUT"); v != "" {
+		streamGrace, err = time.ParseDuration(v)
+		if err != nil || streamGrace < 0 {
+			log.Fatalf("invalid STREAM_DRAIN_TIMEOUT %q", v)
+		}
+	}
+	shutdownTimeout := 30 * time.Second
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		shutdownTimeout, err = time.ParseDuration(v)
+		if err != nil || shutdownTimeout <= 0 {
+			log.Fatalf("invalid SHUTDOWN_TIMEOUT %q", v)
+		}
+	}
+
+	server := grpcserver.NewConversationServer(eng, hub, pool)
+	server.SetPriorityRules(priorities)
+	go server.SweepIdleSessions(ctx, idleTTL)
+
+	// Chunks taking longer than LATENCY_BUDGET from their timestamp to their
+	// actions are flagged; 0 disables the budget
+	if v := os.Getenv("LATENCY_BUDGET"); v != "" {
+		budget, err := time.ParseDuration(v)
+		if err != nil || budget < 0 {
+			log.Fatalf("invalid LATENCY_BUDGET %q", v)
+		}
+		server.SetLatencyBudget(budget)
+	}
+	// Latency percentiles cover the chunks of the last one to two
+	// LATENCY_WINDOW
+	if v := os.Getenv("LATENCY_WINDOW"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			log.Fatalf("invalid LATENCY_WINDOW %q", v)
+		}
+		server.SetLatencyWindow(window)
+	}
+
+	// This replica is CLUSTER_NODE_ID, the hostname by default
+	nodeID := os.Getenv("CLUSTER_NODE_ID")
+	if nodeID == "" {
+		nodeID, err = os.Hostname()
+		if err != nil {
+			log.Fatalf("failed to get hostname for CLUSTER_NODE_ID: %v", err)
+		}
+	}
+
+	// With SNAPSHOT_STORE=file (to SNAPSHOT_PATH) or db, the live sessions are
+	// saved every SNAPSHOT_INTERVAL and on shutdown, and restored on startup
+	// unless idle for SESSION_IDLE_TTL
+	var store snapshot.Store
+	switch v := os.Getenv("SNAPSHOT_STORE"); v {
+	case "":
+	case "file":
+		path := os.Getenv("SNAPSHOT_PATH")
+		if path == "" {
+			path = "sessions.snapshot"
+		}
+		store = snapshot.File(path)
+	case "db":
+		if repo == nil {
+			log.Fatalf("SNAPSHOT_STORE=db requires the database")
+		}
+		store = snapshot.NewDatabase(repo, nodeID)
+	default:
+		log.Fatalf("invalid SNAPSHOT_STORE %q", v)
+	}
+	if store != nil {
+		interval := 30 * time.Second
+		if v := os.Getenv("SNAPSHOT_INTERVAL"); v != "" {
+			interval, err = time.ParseDuration(v)
+			if err != nil || interval <= 0 {
+				log.Fatalf("invalid SNAPSHOT_INTERVAL %q", v)
+			}
+		}
+		server.SetSnapshotStore(store)
+		restored, skipped, err := server.Restore(ctx, idleTTL)
+		if err != nil {
+			log.Printf("Failed to restore sessions, starting empty: %v", err)
+		} else {
+			log.Printf("Restored %d sessions, skipped %d idle ones", restored, skipped)
+		}
+		go server.SnapshotSessions(ctx, interval)
+	}
+
+	// Replicas share the sessions when CLUSTER_PEERS lists them as id=addr
+	// pairs, health checked every CLUSTER_HEARTBEAT_INTERVAL, or when
+	// CLUSTER_DISCOVERY=db and they heartbeat to the database every
+	// CLUSTER_HEARTBEAT_INTERVAL. This replica is reached on
+	// CLUSTER_ADVERTISE_ADDR; restored sessions it doesn't own are handed
+	// over once it sees the other replicas.
+	var discovery cluster.Discovery
+	heartbeat := 2 * time.Second
+	if v := os.Getenv("CLUSTER_HEARTBEAT_INTERVAL"); v != "" {
+		heartbeat, err = time.ParseDuration(v)
+		if err != nil || heartbeat <= 0 {
+			log.Fatalf("invalid CLUSTER_HEARTBEAT_INTERVAL %q", v)
+		}
+	}
+	switch v := os.Getenv("CLUSTER_DISCOVERY"); v {
+	case "":
+		if peers := os.Getenv("CLUSTER_PEERS"); peers != "" {
+			static, err := cluster.ParsePeers(peers)
+			if err != nil {
+				log.Fatalf("invalid CLUSTER_PEERS: %v", err)
+			}
+			checked := cluster.NewCheckedPeers(static, grpcserver.HealthService, heartbeat)
+			defer checked.Close()
+			discovery = checked
+		}
+	case "db":
+		if repo == nil {
+			log.Fatalf("CLUSTER_DISCOVERY=db requires the database")
+		}
+		discovery = cluster.NewHeartbeats(repo, 3*heartbeat)
+	default:
+		log.Fatalf("invalid CLUSTER_DISCOVERY %q", v)
+	}
+	if discovery != nil {
+		advertise := os.Getenv("CLUSTER_ADVERTISE_ADDR")
+		if advertise == "" {
+			log.Fatalf("CLUSTER_ADVERTISE_ADDR is required in a cluster")
+		}
+		c := cluster.New(cluster.Member{ID: nodeID, Addr: advertise}, discovery)
+		server.SetCluster(c)
+		go c.Run(ctx, heartbeat)
+	}
+
+	// Worker queue, reorder and latency metrics, and worker pool resizing
+	metricsAddr := os.Getenv("METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = ":9090"
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", server.StatsHandler())
+	mux.Handle("/workers", server.WorkersHandler())
+	mux.Handle("/latency", server.LatencyHandler())
+	metricsServer := &http.Server{Addr: metricsAddr, Handler: mux}
+	go func() {
+		log.Printf("Metrics on %s/metrics", metricsAddr)
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("metrics server error: %v", err)
+		}
+	}()
+
+	grpcServer := grpc.NewServer(serverOptions()...)
+	conversationv1.RegisterConversationStreamServer(grpcServer, server)
+
+	// The standard health service reports NOT_SERVING while the database
+	// doesn't answer, the worker queues are full or the server drains,
+	// checked every HEALTH_CHECK_INTERVAL
+	healthInterval := 5 * time.Second
+	if v := os.Getenv("HEALTH_CHECK_INTERVAL"); v != "" {
+		healthInterval, err = time.ParseDuration(v)
+		if err != nil || healthInterval <= 0 {
+			log.Fatalf("invalid HEALTH_CHECK_INTERVAL %q", v)
+		}
+	}
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	checks := make(map[string]grpcserver.HealthCheck)
+	if repo != nil {
+		checks["database"] = repo.Ping
+	}
+	go server.ReportHealth(ctx, healthServer, checks, healthInterval)
+
+	// Server reflection lets grpcurl list and call the services; disable it
+	// with GRPC_REFLECTION=false
+	reflectionOn := true
+	if v := os.Getenv("GRPC_REFLECTION"); v != "" {
+		reflectionOn, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("invalid GRPC_REFLECTION %q", v)
+		}
+	}
+	if reflectionOn {
+		reflection.Register(grpcServer)
+	}
+
+	go func() {
+		log.Printf("gRPC Conversation Stream Server running on %s", grpcAddr)
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Fatalf("failed to serve: %v", err)
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Println("Shutting down...")
+	// Load balancers stop routing to the server while it drains
+	healthServer.Shutdown()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+
+	// Refuse new connections and RPCs, then drain the open streams and the
+	// worker queues
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	summary := server.Shutdown(shutdownCtx, streamGrace)
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		grpcServer.Stop()
+	}
+
+	// The scheduler finishes the ladder step it is dispatching
+	cancel()
+	select {
+	case <-schedulerDone:
+	case <-shutdownCtx.Done():
+		log.Println("Escalation scheduler did not stop in time")
+	}
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("metrics server shutdown error: %v", err)
+	}
+	if repo != nil {
+		if err := repo.Close(); err != nil {
+			log.Printf("failed to close database: %v", err)
+		}
+	}
+
+	log.Printf("Shutdown complete: %d streams cut, %d queued tasks dropped, %d sessions left open, %d saved",
+		summary.StreamsCut, summary.TasksDropped, summary.LiveSessions, summary.SessionsSaved)
+}
+
+// serverOptions configures the gRPC server from the environment:
+// GRPC_MAX_RECV_MSG_SIZE and GRPC_MAX_SEND_MSG_SIZE in bytes,
+// GRPC_MAX_CONCURRENT_STREAMS per connection, GRPC_KEEPALIVE_TIME and
+// GRPC_KEEPALIVE_TIMEOUT for pinging idle clients, GRPC_KEEPALIVE_MIN_TIME
+// and GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM for the pings clients may send,
+// and GRPC_COMPRESSION (gzip) for the responses. Unset ones keep the gRPC
+// defaults.
+func serverOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if v := os.Getenv("GRPC_MAX_RECV_MSG_SIZE"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			log.Fatalf("invalid GRPC_MAX_RECV_MSG_SIZE %q", v)
+		}
+		opts = append(opts, grpc.MaxRecvMsgSize(size))
+	}
+	if v := os.Getenv("GRPC_MAX_SEND_MSG_SIZE"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size <= 0 {
+			log.Fatalf("invalid GRPC_MAX_SEND_MSG_SIZE %q", v)
+		}
+		opts = append(opts, grpc.MaxSendMsgSize(size))
+	}
+	if v := os.Getenv("GRPC_MAX_CONCURRENT_STREAMS"); v != "" {
+		streams, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || streams == 0 {
+			log.Fatalf("invalid GRPC_MAX_CONCURRENT_STREAMS %q", v)
+		}
+		opts = append(opts, grpc.MaxConcurrentStreams(uint32(streams)))
+	}
+
+	var params keepalive.ServerParameters
+	var policy keepalive.EnforcementPolicy
+	for name, d := range map[string]*time.Duration{
+		"GRPC_KEEPALIVE_TIME":     &params.Time,
+		"GRPC_KEEPALIVE_TIMEOUT":  &params.Timeout,
+		"GRPC_KEEPALIVE_MIN_TIME": &policy.MinTime,
+	} {
+		if v := os.Getenv(name); v != "" {
+			var err error
+			*d, err = time.ParseDuration(v)
+			if err != nil || *d <= 0 {
+				log.Fatalf("invalid %s %q", name, v)
+			}
+		}
+	}
+	if v := os.Getenv("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM"); v != "" {
+		var err error
+		policy.PermitWithoutStream, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("invalid GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM %q", v)
+		}
+	}
+	opts = append(opts, grpc.KeepaliveParams(params), grpc.KeepaliveEnforcementPolicy(policy))
 
-	log.Println("gRPC Conversation Stream Server running on :50051")
-	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	switch v := os.Getenv("GRPC_COMPRESSION"); v {
+	case "", "none":
+	case "gzip":
+		opts = append(opts, grpcserver.SendCompression(v)...)
+	default:
+		log.Fatalf("invalid GRPC_COMPRESSION %q", v)
 	}
+	return opts
 }
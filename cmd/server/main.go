@@ -12,6 +12,8 @@ import (
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/api"
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/escalation"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/events"
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/kafka"
 )
 
@@ -73,14 +75,31 @@ func main() {
 		Handler: mux,
 	}
 
+	// Escalation ladders: dispatches actions and advances unacknowledged levels
+	scheduler := escalation.NewScheduler(repo, escalation.LogDispatcher)
+	// Persist escalation events so gRPC subscribers can replay them
+	scheduler.SetEventHub(events.NewHub(repo))
+
 	// Initialize Consumer
 	consumer := kafka.NewConsumer(
 		[]string{kafkaBrokers},
 		kafkaTopic,
 		"escalation-group",
 		repo,
+		scheduler,
 	)
 
+	// Optional intent model trained with cmd/train-intent
+	if modelPath := os.Getenv("INTENT_MODEL_PATH"); modelPath != "" {
+		classifier, err := core.LoadClassifier(modelPath)
+		if err != nil {
+			log.Fatalf("Failed to load intent model: %v", err)
+		}
+		consumer.SetClassifier(classifier)
+		apiHandler.SetClassifier(classifier)
+		log.Printf("Loaded intent model from %s (labels=%v)", modelPath, classifier.Labels())
+	}
+
 	// Run Consumer and HTTP Server
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -93,8 +112,17 @@ func main() {
 		}
 	}()
 
+	// Start escalation scheduler in goroutine
+	schedulerDone := make(chan struct{})
+	go func() {
+		defer close(schedulerDone)
+		scheduler.Start(ctx)
+	}()
+
 	// Start Kafka Consumer in gohroutine
+	consumerDone := make(chan struct{})
 	go func() {
+		defer close(consumerDone)
 		if err := consumer.Start(ctx); err != nil {
 			log.Printf("Consumer error: %v", err)
 		}
@@ -107,15 +135,40 @@ func main() {
 	<-sigChan
 	log.Println("Shutting down...")
 
+	// The whole shutdown is bounded by SHUTDOWN_TIMEOUT
+	shutdownTimeout := 30 * time.Second
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		shutdownTimeout, err = time.ParseDuration(v)
+		if err != nil || shutdownTimeout <= 0 {
+			log.Fatalf("Invalid SHUTDOWN_TIMEOUT %q", v)
+		}
+	}
+
 	// Shutdown HTTP server
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer shutdownCancel()
 	if err := httpServer.Shutdown(shutdownCtx); err != nil {
 		log.Printf("HTTP server shutdown error: %v", err)
 	}
 
-	// Cancel Kafka consumer context
+	// Cancel Kafka consumer context; the consumer finishes and commits the
+	// message it is processing and the scheduler the ladder step it dispatches
 	cancel()
+	var unfinished []string
+	for name, done := range map[string]chan struct{}{"consumer": consumerDone, "scheduler": schedulerDone} {
+		select {
+		case <-done:
+		case <-shutdownCtx.Done():
+			unfinished = append(unfinished, name)
+		}
+	}
+	if err := repo.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+	if len(unfinished) > 0 {
+		log.Printf("Shutdown timed out, %v did not stop", unfinished)
+		return
+	}
 	log.Println("Shutdown complete")
 }
 
@@ -130,7 +183,10 @@ func seedRules(repo *db.Repository) {
 	conds := []core.Condition{
 		{Word: "help", Operator: ">=", Count: 2},
 	}
-	_, err := repo.CreateRule("Help Request", conds, "human_handoff")
+	_, err := repo.CreateRule(core.ParsedRule{
+		Rule:             core.Rule{Name: "Help Request", Action: "human_handoff"},
+		ParsedConditions: conds,
+	})
 	if err != nil {
 		log.Printf("Failed to seed rule: %v", err)
 	}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+)
+
+func main() {
+	input := flag.String("input", "client/sample-intents.txt", "labeled transcripts in conv|sender|text|label format")
+	output := flag.String("output", "intent-model.json", "path to write the trained model")
+	flag.Parse()
+
+	f, err := os.Open(*input)
+	if err != nil {
+		log.Fatalf("failed to open training file: %v", err)
+	}
+	defer f.Close()
+
+	examples, err := core.ReadLabeledTranscripts(f)
+	if err != nil {
+		log.Fatalf("failed to read training file: %v", err)
+	}
+	if len(examples) == 0 {
+		log.Fatalf("no labeled examples in %s", *input)
+	}
+
+	classifier := core.NewClassifier()
+	for _, ex := range examples {
+		classifier.Train(ex.Text, ex.Label)
+	}
+
+	// Training accuracy is a sanity check, not an evaluation
+	correct := 0
+	for _, ex := range examples {
+		if classifier.Predict(ex.Text).Label == ex.Label {
+			correct++
+		}
+	}
+
+	if err := classifier.Save(*output); err != nil {
+		log.Fatalf("failed to save model: %v", err)
+	}
+
+	log.Printf("Trained intent model on %d examples, labels=%v, training accuracy=%.2f",
+		len(examples), classifier.Labels(), float64(correct)/float64(len(examples)))
+	log.Printf("Model written to %s", *output)
+}
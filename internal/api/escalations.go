@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+)
+
+func (h *Handler) HandlePolicies(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		policies, err := h.repo.GetAllPolicies()
+		if err != nil {
+			log.Printf("Failed to fetch policies: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to fetch policies")
+			return
+		}
+		writeJSON(w, http.StatusOK, policies)
+	case http.MethodPost:
+		var policy core.EscalationPolicy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		if err := policy.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid policy: "+err.Error())
+			return
+		}
+		created, err := h.repo.CreatePolicy(policy)
+		if err != nil {
+			log.Printf("Failed to create policy: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to create policy")
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+type AcknowledgeRequest struct {
+	By string `json:"by"`
+}
+
+// HandleEscalation serves
+//
+//	GET  /api/escalations/{id}     escalation ladder status
+//	POST /api/escalations/{id}/ack acknowledge and stop the ladder
+func (h *Handler) HandleEscalation(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/escalations/"), "/"), "/")
+	id := parts[0]
+	if id == "" {
+		writeError(w, http.StatusNotFound, "Escalation id is required")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		esc, err := h.repo.GetEscalation(id)
+		if errors.Is(err, db.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "Escalation not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to fetch escalation %s: %v", id, err)
+			writeError(w, http.StatusInternalServerError, "Failed to fetch escalation")
+			return
+		}
+		writeJSON(w, http.StatusOK, esc)
+	case len(parts) == 2 && parts[1] == "ack" && r.Method == http.MethodPost:
+		h.AcknowledgeEscalation(w, r, id)
+	case len(parts) <= 2:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// AcknowledgeEscalation stops the ladder and moves an escalated session to acknowledged
+func (h *Handler) AcknowledgeEscalation(w http.ResponseWriter, r *http.Request, id string) {
+	var req AcknowledgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	if req.By == "" {
+		writeError(w, http.StatusBadRequest, "Acknowledging user (by) is required")
+		return
+	}
+
+	esc, err := h.repo.AcknowledgeEscalation(id, req.By)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeError(w, http.StatusNotFound, "Escalation not found")
+		return
+	case errors.Is(err, core.ErrInvalidTransition):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Printf("Failed to acknowledge escalation %s: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to acknowledge escalation")
+		return
+	}
+
+	if state, err := h.repo.GetSessionState(esc.SessionID); err == nil && state.Status == core.SessionEscalated {
+		if _, err := h.repo.TransitionSession(esc.SessionID, core.SessionAcknowledged, "acknowledged by "+req.By); err != nil {
+			log.Printf("Failed to acknowledge session %s: %v", esc.SessionID, err)
+		}
+	}
+	writeJSON(w, http.StatusOK, esc)
+}
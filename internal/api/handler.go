@@ -3,122 +3,219 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
 )
 
 type Handler struct {
-	repo *db.Repository
+	repo     *db.Repository
+	analyzer *core.Analyzer
+	engine   *core.Engine
 }
 
 func NewHandler(repo *db.Repository) *Handler {
-	return &Handler{repo: repo}
+	return &Handler{
+		repo:     repo,
+		analyzer: core.NewAnalyzer(repo),
+		engine:   core.NewEngine(),
+	}
+}
+
+// SetClassifier lets rule tests evaluate intent conditions
+func (h *Handler) SetClassifier(classifier *core.Classifier) {
+	h.analyzer.SetClassifier(classifier)
 }
 
 type CreateRuleRequest struct {
-	Name       string           `json:"name"`
-	Conditions []core.Condition `json:"conditions"`
-	Action     string           `json:"action"`
+	Name          string              `json:"name"`
+	Conditions    []core.Condition    `json:"conditions"`
+	Action        string              `json:"action"`
+	Exemplars     map[string][]string `json:"exemplars,omitempty"`
+	PolicyID      string              `json:"policy_id,omitempty"`
+	Tests         []core.RuleTest     `json:"tests,omitempty"`
+	Mode          string              `json:"mode,omitempty"`    // active (default), shadow or disabled
+	Trigger       string              `json:"trigger,omitempty"` // message (default) or session_end
+	FireOnInterim bool                `json:"fire_on_interim,omitempty"`
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, ErrorResponse{Error: msg})
+}
+
 func (h *Handler) CreateRule(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	var req CreateRuleRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body: " + err.Error()})
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
 
-	// Validate input
-	if req.Name == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Rule name is required"})
+	parsed, ok := h.validateRule(w, req)
+	if !ok {
 		return
 	}
 
-	if len(req.Conditions) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "At least one condition is required"})
+	// Create rule
+	rule, err := h.repo.CreateRule(parsed)
+	if err != nil {
+		log.Printf("Failed to create rule: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to create rule")
 		return
 	}
 
-	if req.Action == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Action is required"})
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+// UpdateRule replaces an existing rule; like CreateRule it refuses the save
+// if any embedded test fails
+func (h *Handler) UpdateRule(w http.ResponseWriter, r *http.Request, id string) {
+	var req CreateRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
 
-	// Create rule
-	rule, err := h.repo.CreateRule(req.Name, req.Conditions, req.Action)
+	parsed, ok := h.validateRule(w, req)
+	if !ok {
+		return
+	}
+	parsed.ID = id
+
+	rule, err := h.repo.UpdateRule(parsed)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "Rule not found")
+		return
+	}
 	if err != nil {
-		log.Printf("Failed to create rule: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to create rule"})
+		log.Printf("Failed to update rule %s: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to update rule")
 		return
 	}
+	writeJSON(w, http.StatusOK, rule)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(rule)
+type RuleTestFailureResponse struct {
+	Error    string                `json:"error"`
+	Failures []core.RuleTestResult `json:"failures"`
+}
+
+// validateRule checks a create or update request and runs the rule's embedded
+// tests. On failure it writes the error response and returns false.
+func (h *Handler) validateRule(w http.ResponseWriter, req CreateRuleRequest) (core.ParsedRule, bool) {
+	// Validate input
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "Rule name is required")
+		return core.ParsedRule{}, false
+	}
+
+	if len(req.Conditions) == 0 {
+		writeError(w, http.StatusBadRequest, "At least one condition is required")
+		return core.ParsedRule{}, false
+	}
+
+	if req.Action == "" {
+		writeError(w, http.StatusBadRequest, "Action is required")
+		return core.ParsedRule{}, false
+	}
+
+	parsed := core.ParsedRule{
+		Rule: core.Rule{
+			Name:          req.Name,
+			Action:        req.Action,
+			Exemplars:     req.Exemplars,
+			PolicyID:      req.PolicyID,
+			Tests:         req.Tests,
+			Mode:          req.Mode,
+			Trigger:       req.Trigger,
+			FireOnInterim: req.FireOnInterim,
+		},
+		ParsedConditions: req.Conditions,
+		ExemplarIndexes:  core.IndexExemplars(req.Exemplars),
+	}
+	if err := parsed.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid rule: "+err.Error())
+		return core.ParsedRule{}, false
+	}
+	if req.PolicyID != "" {
+		if _, err := h.repo.GetPolicy(req.PolicyID); err != nil {
+			writeError(w, http.StatusBadRequest, "Unknown escalation policy: "+req.PolicyID)
+			return core.ParsedRule{}, false
+		}
+	}
+
+	if failed := core.FailedRuleTests(core.RunRuleTests(h.analyzer, h.engine, parsed)); len(failed) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, RuleTestFailureResponse{
+			Error:    fmt.Sprintf("%d of %d rule tests failed", len(failed), len(parsed.Tests)),
+			Failures: failed,
+		})
+		return core.ParsedRule{}, false
+	}
+	return parsed, true
 }
 
 type RuleResponse struct {
-	ID         string           `json:"id"`
-	Name       string           `json:"name"`
-	Conditions []core.Condition `json:"conditions"`
-	Action     string           `json:"action"`
+	ID            string              `json:"id"`
+	Name          string              `json:"name"`
+	Conditions    []core.Condition    `json:"conditions"`
+	Action        string              `json:"action"`
+	Exemplars     map[string][]string `json:"exemplars,omitempty"`
+	PolicyID      string              `json:"policy_id,omitempty"`
+	Tests         []core.RuleTest     `json:"tests,omitempty"`
+	Mode          string              `json:"mode"`
+	Trigger       string              `json:"trigger"`
+	FireOnInterim bool                `json:"fire_on_interim"`
 }
 
 func (h *Handler) GetAllRules(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	rules, err := h.repo.GetAllRules()
 	if err != nil {
 		log.Printf("Failed to fetch rules: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to fetch rules"})
+		writeError(w, http.StatusInternalServerError, "Failed to fetch rules")
 		return
 	}
 
 	var response []RuleResponse
 	for _, rule := range rules {
 		response = append(response, RuleResponse{
-			ID:         rule.ID,
-			Name:       rule.Name,
-			Conditions: rule.ParsedConditions,
-			Action:     rule.Action,
+			ID:            rule.ID,
+			Name:          rule.Name,
+			Conditions:    rule.ParsedConditions,
+			Action:        rule.Action,
+			Exemplars:     rule.Exemplars,
+			PolicyID:      rule.PolicyID,
+			Tests:         rule.Tests,
+			Mode:          rule.RuleMode(),
+			Trigger:       rule.RuleTrigger(),
+			FireOnInterim: rule.FireOnInterim,
 		})
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, http.StatusOK, response)
 }
 
 func (h *Handler) HandleRules(w http.ResponseWriter, r *http.Request) {
@@ -128,10 +225,60 @@ func (h *Handler) HandleRules(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		h.CreateRule(w, r)
 	default:
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// HandleRule serves
+//
+//	PUT  /api/rules/{id} update a rule, refused if its tests fail
+//	POST /api/rules/test run the embedded tests of every rule
+func (h *Handler) HandleRule(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/rules/"), "/")
+	switch {
+	case id == "" || strings.Contains(id, "/"):
+		writeError(w, http.StatusNotFound, "Not found")
+	case id == "test" && r.Method == http.MethodPost:
+		h.RunAllRuleTests(w, r)
+	case id != "test" && r.Method == http.MethodPut:
+		h.UpdateRule(w, r, id)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+type RuleTestReport struct {
+	Passed  bool                  `json:"passed"`
+	Rules   int                   `json:"rules"`
+	Tests   int                   `json:"tests"`
+	Failed  int                   `json:"failed"`
+	Results []core.RuleTestResult `json:"results"`
+}
+
+// RunAllRuleTests runs every stored rule's tests, for CI-like checks. It
+// responds 200 when all pass and 422 otherwise.
+func (h *Handler) RunAllRuleTests(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.repo.GetAllRules()
+	if err != nil {
+		log.Printf("Failed to fetch rules: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to fetch rules")
+		return
+	}
+
+	report := RuleTestReport{Rules: len(rules), Results: []core.RuleTestResult{}}
+	for _, rule := range rules {
+		results := core.RunRuleTests(h.analyzer, h.engine, rule)
+		report.Tests += len(results)
+		report.Failed += len(core.FailedRuleTests(results))
+		report.Results = append(report.Results, results...)
+	}
+	report.Passed = report.Failed == 0
+
+	status := http.StatusOK
+	if !report.Passed {
+		status = http.StatusUnprocessableEntity
 	}
+	writeJSON(w, status, report)
 }
 
 type ExecuteFlowRequest struct {
@@ -147,17 +294,13 @@ type ExecuteFlowRequest struct {
 
 func (h *Handler) ExecuteFlow(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed"})
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	var req ExecuteFlowRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body: " + err.Error()})
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
 		return
 	}
 
@@ -165,9 +308,7 @@ func (h *Handler) ExecuteFlow(w http.ResponseWriter, r *http.Request) {
 	rules, err := h.repo.GetAllRules()
 	if err != nil {
 		log.Printf("Failed to fetch rules: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to fetch rules"})
+		writeError(w, http.StatusInternalServerError, "Failed to fetch rules")
 		return
 	}
 
@@ -180,9 +321,7 @@ func (h *Handler) ExecuteFlow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if targetRule == nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Rule not found"})
+		writeError(w, http.StatusNotFound, "Rule not found")
 		return
 	}
 
@@ -192,9 +331,7 @@ func (h *Handler) ExecuteFlow(w http.ResponseWriter, r *http.Request) {
 	wordCounts, err := h.repo.GetWordCounts("default_conversation")
 	if err != nil {
 		log.Printf("Failed to get word counts: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to get word counts"})
+		writeError(w, http.StatusInternalServerError, "Failed to get word counts")
 		return
 	}
 
@@ -209,18 +346,12 @@ func (h *Handler) ExecuteFlow(w http.ResponseWriter, r *http.Request) {
 		// Match found! Call external API
 		if err := h.callExternalFlowAPI(req); err != nil {
 			log.Printf("Failed to call external flow API: %v", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to execute flow: " + err.Error()})
+			writeError(w, http.StatusInternalServerError, "Failed to execute flow: "+err.Error())
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Flow executed"})
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success", "message": "Flow executed"})
 	} else {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "ignored", "message": "Rule conditions not met"})
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored", "message": "Rule conditions not met"})
 	}
 }
 
@@ -266,5 +397,10 @@ func (h *Handler) callExternalFlowAPI(req ExecuteFlowRequest) error {
 
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/rules", h.HandleRules)
+	mux.HandleFunc("/api/rules/", h.HandleRule)
 	mux.HandleFunc("/api/test-rule", h.ExecuteFlow)
+	mux.HandleFunc("/api/sessions/", h.HandleSession)
+	mux.HandleFunc("/api/policies", h.HandlePolicies)
+	mux.HandleFunc("/api/escalations/", h.HandleEscalation)
+	mux.HandleFunc("/api/reports/shadow", h.ShadowReport)
 }
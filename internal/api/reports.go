@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+)
+
+// ShadowReport compares shadow rule firings with actual escalations.
+//
+//	GET /api/reports/shadow?from=...&to=...
+//
+// from and to are RFC 3339 times or unix millis; the default range is the last 24 hours.
+func (h *Handler) ShadowReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	now := time.Now()
+	to, err := parseTime(r.URL.Query().Get("to"), now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid to: "+err.Error())
+		return
+	}
+	from, err := parseTime(r.URL.Query().Get("from"), to.Add(-24*time.Hour))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid from: "+err.Error())
+		return
+	}
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	firings, err := h.repo.GetShadowFirings(from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		log.Printf("Failed to fetch shadow firings: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to fetch shadow firings")
+		return
+	}
+	escalations, err := h.repo.GetEscalationsBetween(from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		log.Printf("Failed to fetch escalations: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to fetch escalations")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, core.BuildShadowReport(from.UnixMilli(), to.UnixMilli(), firings, escalations))
+}
+
+// parseTime accepts RFC 3339 or unix millis, returning def for an empty value
+func parseTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 or unix millis")
+	}
+	return t, nil
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+)
+
+type TransitionRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// HandleSession serves
+//
+//	GET  /api/sessions/{id}                         current status and transition history
+//	POST /api/sessions/{id}/transition              manual status change
+//	GET  /api/sessions/{id}/explain?message_id={id} why a message did or didn't escalate
+//	GET  /api/sessions/{id}/summary                 summary persisted when the session ended
+func (h *Handler) HandleSession(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sessions/"), "/"), "/")
+	sessionID := parts[0]
+	if sessionID == "" {
+		writeError(w, http.StatusNotFound, "Session id is required")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		h.GetSession(w, sessionID)
+	case len(parts) == 2 && parts[1] == "transition" && r.Method == http.MethodPost:
+		h.TransitionSession(w, r, sessionID)
+	case len(parts) == 2 && parts[1] == "explain" && r.Method == http.MethodGet:
+		h.ExplainDecision(w, r, sessionID)
+	case len(parts) == 2 && parts[1] == "summary" && r.Method == http.MethodGet:
+		h.GetSessionSummary(w, sessionID)
+	case len(parts) <= 2:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (h *Handler) GetSession(w http.ResponseWriter, sessionID string) {
+	state, err := h.repo.GetSessionState(sessionID)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch session %s: %v", sessionID, err)
+		writeError(w, http.StatusInternalServerError, "Failed to fetch session")
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+func (h *Handler) GetSessionSummary(w http.ResponseWriter, sessionID string) {
+	summary, err := h.repo.GetSessionSummary(sessionID)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "Session has not ended")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch summary of session %s: %v", sessionID, err)
+		writeError(w, http.StatusInternalServerError, "Failed to fetch session summary")
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+func (h *Handler) TransitionSession(w http.ResponseWriter, r *http.Request, sessionID string) {
+	var req TransitionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	to, err := core.ParseSessionStatus(req.Status)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "manual"
+	}
+
+	state, err := h.repo.TransitionSession(sessionID, to, req.Reason)
+	if errors.Is(err, core.ErrInvalidTransition) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to transition session %s: %v", sessionID, err)
+		writeError(w, http.StatusInternalServerError, "Failed to transition session")
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+// ExplainDecision returns the decision trace of a message, or of the latest
+// message of the session when no message_id is given
+func (h *Handler) ExplainDecision(w http.ResponseWriter, r *http.Request, sessionID string) {
+	messageID := r.URL.Query().Get("message_id")
+	trace, err := h.repo.GetDecisionTrace(sessionID, messageID)
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "No decision recorded for this session and message")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to fetch decision trace for session %s: %v", sessionID, err)
+		writeError(w, http.StatusInternalServerError, "Failed to fetch decision trace")
+		return
+	}
+	writeJSON(w, http.StatusOK, trace)
+}
@@ -0,0 +1,240 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+)
+
+// Member is a server replica
+type Member struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"` // gRPC address the other replicas forward to
+}
+
+// Discovery lists the replicas of the cluster
+type Discovery interface {
+	Members(ctx context.Context) ([]Member, error)
+}
+
+// Registrar is a Discovery that replicas register with, e.g. through heartbeats
+type Registrar interface {
+	Discovery
+	Register(ctx context.Context, self Member) error
+	Deregister(ctx context.Context, id string) error
+}
+
+// Static is a fixed list of replicas. It never drops a replica that is down,
+// so the sessions it owns are lost until it is back; see CheckedPeers for
+// failover.
+type Static []Member
+
+func (s Static) Members(context.Context) ([]Member, error) {
+	return s, nil
+}
+
+// ParsePeers parses comma separated id=addr pairs, e.g.
+// "a=10.0.0.1:50051,b=10.0.0.2:50051"
+func ParsePeers(s string) (Static, error) {
+	var peers Static
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, addr, ok := strings.Cut(pair, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("expected id=addr, got %q", pair)
+		}
+		peers = append(peers, Member{ID: id, Addr: addr})
+	}
+	return peers, nil
+}
+
+// Heartbeats discovers replicas through their heartbeats in the database;
+// a replica without a heartbeat for ttl is gone
+type Heartbeats struct {
+	repo *db.Repository
+	ttl  time.Duration
+}
+
+func NewHeartbeats(repo *db.Repository, ttl time.Duration) *Heartbeats {
+	return &Heartbeats{repo: repo, ttl: ttl}
+}
+
+func (h *Heartbeats) Register(_ context.Context, self Member) error {
+	return h.repo.Heartbeat(db.ClusterMember{ID: self.ID, Addr: self.Addr}, time.Now().UnixMilli())
+}
+
+func (h *Heartbeats) Deregister(_ context.Context, id string) error {
+	return h.repo.RemoveMember(id)
+}
+
+func (h *Heartbeats) Members(context.Context) ([]Member, error) {
+	rows, err := h.repo.LiveMembers(time.Now().Add(-h.ttl).UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	members := make([]Member, len(rows))
+	for i, row := range rows {
+		members[i] = Member{ID: row.ID, Addr: row.Addr}
+	}
+	return members, nil
+}
+
+// Cluster places sessions on the members of the cluster with a
+// consistent-hash ring, so that every replica agrees on the owner of a
+// session once they see the same members. The local replica is always a
+// member until it leaves.
+type Cluster struct {
+	self      Member
+	discovery Discovery
+	onChange  []func()
+
+	mu      sync.RWMutex
+	members map[string]Member
+	ring    *ring
+	left    bool
+}
+
+// New creates the cluster of self alone until the first Refresh
+func New(self Member, discovery Discovery) *Cluster {
+	return &Cluster{
+		self:      self,
+		discovery: discovery,
+		members:   map[string]Member{self.ID: self},
+		ring:      newRing([]string{self.ID}),
+	}
+}
+
+func (c *Cluster) Self() Member {
+	return c.self
+}
+
+// Owner returns the member owning a session; it is zero once the last member
+// left
+func (c *Cluster) Owner(sessionID string) Member {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.members[c.ring.owner(sessionID)]
+}
+
+// Members returns the members ordered by id
+func (c *Cluster) Members() []Member {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	members := make([]Member, 0, len(c.members))
+	for _, m := range c.members {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// OnChange registers fn to be called after the members changed, e.g. to hand
+// sessions over to their new owners. It must be called before the cluster is
+// refreshed.
+func (c *Cluster) OnChange(fn func()) {
+	c.onChange = append(c.onChange, fn)
+}
+
+// Update replaces the members and reports whether they changed, after
+// calling the OnChange functions
+func (c *Cluster) Update(members []Member) bool {
+	next := make(map[string]Member, len(members)+1)
+	for _, m := range members {
+		next[m.ID] = m
+	}
+
+	c.mu.Lock()
+	if c.left {
+		delete(next, c.self.ID)
+	} else {
+		next[c.self.ID] = c.self
+	}
+	changed := len(next) != len(c.members)
+	for id, m := range next {
+		if c.members[id] != m {
+			changed = true
+		}
+	}
+	if changed {
+		ids := make([]string, 0, len(next))
+		for id := range next {
+			ids = append(ids, id)
+		}
+		c.members, c.ring = next, newRing(ids)
+	}
+	c.mu.Unlock()
+
+	if changed {
+		log.Printf("[cluster] members: %v", c.Members())
+		for _, fn := range c.onChange {
+			fn()
+		}
+	}
+	return changed
+}
+
+// Refresh registers the local replica with a Registrar and updates the
+// members from the discovery
+func (c *Cluster) Refresh(ctx context.Context) error {
+	if r, ok := c.discovery.(Registrar); ok {
+		c.mu.RLock()
+		left := c.left
+		c.mu.RUnlock()
+		if !left {
+			if err := r.Register(ctx, c.self); err != nil {
+				return fmt.Errorf("failed to register %s: %w", c.self.ID, err)
+			}
+		}
+	}
+	members, err := c.discovery.Members(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list cluster members: %w", err)
+	}
+	c.Update(members)
+	return nil
+}
+
+// Run refreshes the cluster every interval until ctx is done
+func (c *Cluster) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Refresh(ctx); err != nil {
+			log.Printf("[cluster] %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Leave deregisters the local replica and takes it off the ring, so that
+// its sessions move to the other members
+func (c *Cluster) Leave(ctx context.Context) error {
+	c.mu.Lock()
+	c.left = true
+	members := make([]Member, 0, len(c.members))
+	for _, m := range c.members {
+		members = append(members, m)
+	}
+	c.mu.Unlock()
+
+	var err error
+	if r, ok := c.discovery.(Registrar); ok {
+		err = r.Deregister(ctx, c.self.ID)
+	}
+	c.Update(members)
+	return err
+}
@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// maxFailedChecks is how many health checks in a row a peer may fail before
+// it is dropped, so that a single lost check doesn't move its sessions
+const maxFailedChecks = 2
+
+// CheckedPeers is a fixed list of replicas filtered by the standard gRPC
+// health service: a peer that doesn't answer its health checks is dropped
+// until it answers again, so that its sessions fail over to the others. A
+// peer that answers NOT_SERVING, e.g. because its queues are full, stays a
+// member so that load doesn't move sessions around.
+type CheckedPeers struct {
+	peers   Static
+	service string // health service name checked
+	timeout time.Duration
+
+	mu     sync.Mutex
+	conns  map[string]*grpc.ClientConn // by address
+	failed map[string]int              // failed checks in a row by member id
+}
+
+// NewCheckedPeers checks service on every peer, each check bounded by timeout
+func NewCheckedPeers(peers Static, service string, timeout time.Duration) *CheckedPeers {
+	return &CheckedPeers{
+		peers:   peers,
+		service: service,
+		timeout: timeout,
+		conns:   make(map[string]*grpc.ClientConn),
+		failed:  make(map[string]int),
+	}
+}
+
+// Members checks every peer concurrently and returns the live ones
+func (p *CheckedPeers) Members(ctx context.Context) ([]Member, error) {
+	alive := make([]bool, len(p.peers))
+	var wg sync.WaitGroup
+	for i, m := range p.peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			alive[i] = p.check(ctx, m)
+		}()
+	}
+	wg.Wait()
+
+	var members []Member
+	for i, m := range p.peers {
+		if alive[i] {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+// check reports whether the peer is still a member after checking it
+func (p *CheckedPeers) check(ctx context.Context, m Member) bool {
+	conn, err := p.conn(m.Addr)
+	if err == nil {
+		checkCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		_, err = healthpb.NewHealthClient(conn).Check(checkCtx, &healthpb.HealthCheckRequest{Service: p.service})
+		cancel()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		if p.failed[m.ID] >= maxFailedChecks {
+			log.Printf("[cluster] peer %s at %s is back", m.ID, m.Addr)
+		}
+		delete(p.failed, m.ID)
+		return true
+	}
+	p.failed[m.ID]++
+	if p.failed[m.ID] == maxFailedChecks {
+		log.Printf("[cluster] dropping peer %s at %s: %v", m.ID, m.Addr, err)
+	}
+	return p.failed[m.ID] < maxFailedChecks
+}
+
+func (p *CheckedPeers) conn(addr string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if conn, ok := p.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	p.conns[addr] = conn
+	return conn, nil
+}
+
+// Close closes the connections to the peers
+func (p *CheckedPeers) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, addr)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// servePeer starts a gRPC server reporting service as NOT_SERVING, which
+// still counts as alive
+func servePeer(t *testing.T, service string) (string, func()) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	return lis.Addr().String(), srv.Stop
+}
+
+func TestCheckedPeersDropsUnreachablePeer(t *testing.T) {
+	const service = "conversation"
+	addrA, stopA := servePeer(t, service)
+	defer stopA()
+	addrB, stopB := servePeer(t, service)
+
+	peers := NewCheckedPeers(Static{{ID: "a", Addr: addrA}, {ID: "b", Addr: addrB}}, service, time.Second)
+	defer peers.Close()
+	c := New(Member{ID: "a", Addr: addrA}, peers)
+
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.Members()); n != 2 {
+		t.Fatalf("Expected both peers, got %v", c.Members())
+	}
+
+	stopB()
+	for i := 1; i <= maxFailedChecks; i++ {
+		if err := c.Refresh(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if i < maxFailedChecks && len(c.Members()) != 2 {
+			t.Fatalf("Expected b to survive %d failed check, got %v", i, c.Members())
+		}
+	}
+	if members := c.Members(); len(members) != 1 || members[0].ID != "a" {
+		t.Errorf("Expected b to be dropped, got %v", members)
+	}
+	if owner := c.Owner("any-session"); owner.ID != "a" {
+		t.Errorf("Expected sessions to fail over to a, got %v", owner)
+	}
+}
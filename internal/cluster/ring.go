@@ -0,0 +1,75 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// ringReplicas is the number of points each member has on the ring; more
+// points spread sessions more evenly
+const ringReplicas = 128
+
+// ring is a consistent-hash ring of member ids. A member joining or leaving
+// only moves the sessions hashing next to its points.
+type ring struct {
+	points []uint32 // sorted
+	owners []string // member id of each point
+}
+
+func newRing(ids []string) *ring {
+	type point struct {
+		hash  uint32
+		owner string
+	}
+	points := make([]point, 0, len(ids)*ringReplicas)
+	for _, id := range ids {
+		for i := 0; i < ringReplicas; i++ {
+			points = append(points, point{hash: ringHash(id + "-" + strconv.Itoa(i)), owner: id})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner < points[j].owner
+	})
+
+	r := &ring{
+		points: make([]uint32, len(points)),
+		owners: make([]string, len(points)),
+	}
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+// owner returns the member of the first point at or after the key's hash, or
+// "" on an empty ring
+func (r *ring) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// ringHash is FNV-1a with a final avalanche step, so that similar keys such
+// as the point names of a member land far apart
+func ringHash(s string) uint32 {
+	f := fnv.New32a()
+	f.Write([]byte(s))
+	h := f.Sum32()
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"sort"
+	"strings"
+
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+// maxWordTurns bounds how many message ids are kept per word for traces
+const maxWordTurns = 20
+
+// SessionAggregate accumulates the analysis of every chunk of a session so
+// that rules see the conversation so far, not just the latest message.
+// It is not safe for concurrent use; it is owned by the worker the session
+// hashes to.
+type SessionAggregate struct {
+	SessionID        string
+	WordCounts       map[string]int            // all senders
+	SenderWordCounts map[string]map[string]int // lowercased sender -> word -> count
+	TurnCounts       map[string]int            // lowercased sender -> turns
+	Turns            int
+	FirstTimestampMs int64
+	LastTimestampMs  int64
+
+	WordTurns map[string][]string // word -> most recent message ids it occurred in
+}
+
+func NewSessionAggregate(sessionID string) *SessionAggregate {
+	return &SessionAggregate{
+		SessionID:        sessionID,
+		WordCounts:       make(map[string]int),
+		SenderWordCounts: make(map[string]map[string]int),
+		TurnCounts:       make(map[string]int),
+		WordTurns:        make(map[string][]string),
+	}
+}
+
+// Add folds the word counts of a chunk into the aggregate
+func (a *SessionAggregate) Add(chunk *conversationv1.ConversationChunk, counts map[string]int) {
+	sender := strings.ToLower(chunk.Sender)
+	senderCounts, ok := a.SenderWordCounts[sender]
+	if !ok {
+		senderCounts = make(map[string]int)
+		a.SenderWordCounts[sender] = senderCounts
+	}
+
+	for word, n := range counts {
+		a.WordCounts[word] += n
+		senderCounts[word] += n
+		if chunk.MessageId != "" {
+			turns := append(a.WordTurns[word], chunk.MessageId)
+			if len(turns) > maxWordTurns {
+				turns = turns[len(turns)-maxWordTurns:]
+			}
+			a.WordTurns[word] = turns
+		}
+	}
+
+	a.Turns++
+	a.TurnCounts[sender]++
+	if a.FirstTimestampMs == 0 || chunk.TimestampMs < a.FirstTimestampMs {
+		a.FirstTimestampMs = chunk.TimestampMs
+	}
+	if chunk.TimestampMs > a.LastTimestampMs {
+		a.LastTimestampMs = chunk.TimestampMs
+	}
+}
+
+// Merge folds in the aggregate of earlier chunks of the session, e.g. handed
+// over by another replica after this one started counting
+func (a *SessionAggregate) Merge(earlier *SessionAggregate) {
+	for word, n := range earlier.WordCounts {
+		a.WordCounts[word] += n
+	}
+	for sender, counts := range earlier.SenderWordCounts {
+		senderCounts, ok := a.SenderWordCounts[sender]
+		if !ok {
+			senderCounts = make(map[string]int, len(counts))
+			a.SenderWordCounts[sender] = senderCounts
+		}
+		for word, n := range counts {
+			senderCounts[word] += n
+		}
+	}
+	for sender, n := range earlier.TurnCounts {
+		a.TurnCounts[sender] += n
+	}
+	a.Turns += earlier.Turns
+	for word, ids := range earlier.WordTurns {
+		turns := append(append([]string(nil), ids...), a.WordTurns[word]...)
+		if len(turns) > maxWordTurns {
+			turns = turns[len(turns)-maxWordTurns:]
+		}
+		a.WordTurns[word] = turns
+	}
+	if earlier.FirstTimestampMs != 0 && (a.FirstTimestampMs == 0 || earlier.FirstTimestampMs < a.FirstTimestampMs) {
+		a.FirstTimestampMs = earlier.FirstTimestampMs
+	}
+	if earlier.LastTimestampMs > a.LastTimestampMs {
+		a.LastTimestampMs = earlier.LastTimestampMs
+	}
+}
+
+// Analysis combines the cumulative counts with the analysis of the latest
+// chunk; text, intent and similarity conditions still apply to that chunk
+func (a *SessionAggregate) Analysis(current Analysis) Analysis {
+	current.WordCounts = a.WordCounts
+	current.SenderWordCounts = a.SenderWordCounts
+	current.TokenTurns = a.WordTurns
+	return current
+}
+
+// Speculate returns the analysis the session would have if the chunk were
+// added, without adding it; used for interim transcripts that a final chunk
+// will replace
+func (a *SessionAggregate) Speculate(chunk *conversationv1.ConversationChunk, current Analysis) Analysis {
+	sender := strings.ToLower(chunk.Sender)
+	words := make(map[string]int, len(a.WordCounts)+len(current.WordCounts))
+	for word, n := range a.WordCounts {
+		words[word] = n
+	}
+	senders := make(map[string]map[string]int, len(a.SenderWordCounts)+1)
+	for s, counts := range a.SenderWordCounts {
+		senders[s] = counts
+	}
+	senderCounts := make(map[string]int, len(a.SenderWordCounts[sender])+len(current.WordCounts))
+	for word, n := range a.SenderWordCounts[sender] {
+		senderCounts[word] = n
+	}
+	senders[sender] = senderCounts
+
+	for word, n := range current.WordCounts {
+		words[word] += n
+		senderCounts[word] += n
+	}
+
+	current.WordCounts = words
+	current.SenderWordCounts = senders
+	current.TokenTurns = a.WordTurns
+	return current
+}
+
+// WordCount is a word with its number of occurrences
+type WordCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// SessionSummary is persisted when a session ends
+type SessionSummary struct {
+	SessionID   string         `json:"session_id"`
+	StartedAt   int64          `json:"started_at"` // unix millis of the first chunk
+	EndedAt     int64          `json:"ended_at"`   // unix millis of the last chunk
+	Turns       int            `json:"turns"`
+	TurnCounts  map[string]int `json:"turn_counts"`
+	TopWords    []WordCount    `json:"top_words"`
+	EndReason   string         `json:"end_reason"` // e.g. "end_of_session", "stream_closed", "idle"
+	FinalStatus SessionStatus  `json:"final_status,omitempty"`
+	FinalizedAt int64          `json:"finalized_at"`       // unix millis
+	Degraded    string         `json:"degraded,omitempty"` // why processing of the session failed, if it did
+}
+
+// Summary returns the summary of the session with its n most frequent words
+func (a *SessionAggregate) Summary(reason string, n int) SessionSummary {
+	words := make([]WordCount, 0, len(a.WordCounts))
+	for word, count := range a.WordCounts {
+		words = append(words, WordCount{Word: word, Count: count})
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if words[i].Count != words[j].Count {
+			return words[i].Count > words[j].Count
+		}
+		return words[i].Word < words[j].Word
+	})
+	if len(words) > n {
+		words = words[:n]
+	}
+
+	return SessionSummary{
+		SessionID:  a.SessionID,
+		StartedAt:  a.FirstTimestampMs,
+		EndedAt:    a.LastTimestampMs,
+		Turns:      a.Turns,
+		TurnCounts: a.TurnCounts,
+		TopWords:   words,
+		EndReason:  reason,
+	}
+}
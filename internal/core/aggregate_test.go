@@ -0,0 +1,98 @@
+package core
+
+import (
+	"testing"
+
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+func TestSessionAggregateCumulativeRules(t *testing.T) {
+	analyzer := NewAnalyzer(nil)
+	engine := NewEngine()
+	rule := ParsedRule{
+		Rule: Rule{Name: "Customer asks for help twice", Action: "human_handoff"},
+		ParsedConditions: []Condition{
+			{Word: "help", Operator: ">=", Count: 2, Sender: "CUSTOMER"},
+		},
+	}
+
+	agg := NewSessionAggregate("s1")
+	chunks := []*conversationv1.ConversationChunk{
+		{SessionId: "s1", MessageId: "m1", Sender: "CUSTOMER", Text: "I need help", TimestampMs: 1000},
+		{SessionId: "s1", MessageId: "m2", Sender: "AGENT", Text: "Happy to help", TimestampMs: 2000},
+		{SessionId: "s1", MessageId: "m3", Sender: "CUSTOMER", Text: "help, it still fails", TimestampMs: 3000},
+	}
+
+	var decision Decision
+	for i, chunk := range chunks {
+		current := analyzer.AnalyzeChunk(chunk)
+		agg.Add(chunk, current.WordCounts)
+		decision = engine.Decide(agg.Analysis(current), []ParsedRule{rule})
+		if i < 2 && len(decision.Matched) != 0 {
+			t.Fatalf("Rule fired early on chunk %d", i)
+		}
+	}
+
+	if len(decision.Matched) != 1 {
+		t.Fatalf("Expected the rule to fire on the cumulative counts, got %+v", decision.Trace)
+	}
+	turns := decision.Trace[0].Conditions[0].Turns
+	if len(turns) != 3 || turns[0] != "m1" || turns[2] != "m3" {
+		t.Errorf("Expected help to be attributed to m1, m2, m3, got %v", turns)
+	}
+
+	if agg.WordCounts["help"] != 3 || agg.SenderWordCounts["customer"]["help"] != 2 {
+		t.Errorf("Unexpected counts %v / %v", agg.WordCounts, agg.SenderWordCounts)
+	}
+	if agg.Turns != 3 || agg.TurnCounts["customer"] != 2 || agg.TurnCounts["agent"] != 1 {
+		t.Errorf("Unexpected turn counts %d / %v", agg.Turns, agg.TurnCounts)
+	}
+	if agg.FirstTimestampMs != 1000 || agg.LastTimestampMs != 3000 {
+		t.Errorf("Unexpected timestamps %d..%d", agg.FirstTimestampMs, agg.LastTimestampMs)
+	}
+}
+
+func TestSessionAggregateSummary(t *testing.T) {
+	analyzer := NewAnalyzer(nil)
+	agg := NewSessionAggregate("s1")
+	for i, text := range []string{"refund refund please", "refund is late", "please hurry"} {
+		chunk := &conversationv1.ConversationChunk{SessionId: "s1", Sender: "customer", Text: text, TimestampMs: int64(1000 * (i + 1))}
+		agg.Add(chunk, analyzer.Analyze(chunk))
+	}
+
+	summary := agg.Summary("idle", 2)
+	if summary.EndReason != "idle" || summary.Turns != 3 || summary.StartedAt != 1000 || summary.EndedAt != 3000 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if len(summary.TopWords) != 2 || summary.TopWords[0] != (WordCount{"refund", 3}) || summary.TopWords[1] != (WordCount{"please", 2}) {
+		t.Errorf("Unexpected top words %v", summary.TopWords)
+	}
+}
+
+func TestSessionAggregateMerge(t *testing.T) {
+	analyzer := NewAnalyzer(nil)
+	add := func(agg *SessionAggregate, chunk *conversationv1.ConversationChunk) {
+		agg.Add(chunk, analyzer.AnalyzeChunk(chunk).WordCounts)
+	}
+
+	// The earlier chunks were counted by the previous owner of the session
+	earlier := NewSessionAggregate("s1")
+	add(earlier, &conversationv1.ConversationChunk{MessageId: "m1", Sender: "CUSTOMER", Text: "I need help", TimestampMs: 1000})
+	later := NewSessionAggregate("s1")
+	add(later, &conversationv1.ConversationChunk{MessageId: "m3", Sender: "CUSTOMER", Text: "help again", TimestampMs: 3000})
+	add(later, &conversationv1.ConversationChunk{MessageId: "m4", Sender: "AGENT", Text: "on it", TimestampMs: 4000})
+
+	later.Merge(earlier)
+	if later.WordCounts["help"] != 2 || later.SenderWordCounts["customer"]["help"] != 2 {
+		t.Errorf("Unexpected counts %v / %v", later.WordCounts, later.SenderWordCounts)
+	}
+	if later.Turns != 3 || later.TurnCounts["customer"] != 2 || later.TurnCounts["agent"] != 1 {
+		t.Errorf("Unexpected turn counts %d / %v", later.Turns, later.TurnCounts)
+	}
+	if turns := later.WordTurns["help"]; len(turns) != 2 || turns[0] != "m1" || turns[1] != "m3" {
+		t.Errorf("Expected help in m1 then m3, got %v", turns)
+	}
+	if later.FirstTimestampMs != 1000 || later.LastTimestampMs != 4000 {
+		t.Errorf("Unexpected timestamps %d..%d", later.FirstTimestampMs, later.LastTimestampMs)
+	}
+}
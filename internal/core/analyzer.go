@@ -4,21 +4,32 @@ import (
 	"strings"
 	"unicode"
 
-	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
 	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
 )
 
+// RuleStore is the subset of the repository the analyzer depends on.
+// It is an interface so that core does not import the db package.
+type RuleStore interface {
+	GetAllRules() ([]ParsedRule, error)
+}
+
 // Analyzer is responsible for processing text and extracting metrics
 type Analyzer struct {
-	repo *db.Repository
+	repo       RuleStore
+	classifier *Classifier
 }
 
-func NewAnalyzer(repo *db.Repository) *Analyzer {
+func NewAnalyzer(repo RuleStore) *Analyzer {
 	return &Analyzer{
 		repo: repo,
 	}
 }
 
+// SetClassifier enables intent classification for subsequent analyses
+func (a *Analyzer) SetClassifier(c *Classifier) {
+	a.classifier = c
+}
+
 // Analyze returns a map of word counts from the input text
 func (a *Analyzer) Analyze(convoChunk *conversationv1.ConversationChunk) map[string]int {
 	text := convoChunk.Text
@@ -39,3 +50,20 @@ func (a *Analyzer) Analyze(convoChunk *conversationv1.ConversationChunk) map[str
 
 	return counts
 }
+
+// AnalyzeChunk returns the full analysis of a chunk: word counts plus the
+// predicted intent when a classifier is configured
+func (a *Analyzer) AnalyzeChunk(convoChunk *conversationv1.ConversationChunk) Analysis {
+	analysis := Analysis{
+		WordCounts: a.Analyze(convoChunk),
+		Text:       convoChunk.Text,
+		MessageID:  convoChunk.MessageId,
+	}
+	if a.classifier != nil {
+		prediction := a.classifier.Predict(convoChunk.Text)
+		analysis.Intent = prediction.Label
+		analysis.IntentConfidence = prediction.Confidence
+		analysis.IntentEvidence = a.classifier.Evidence(convoChunk.Text, prediction.Label, 3)
+	}
+	return analysis
+}
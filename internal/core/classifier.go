@@ -0,0 +1,242 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Classifier is a multinomial naive Bayes intent classifier over word
+// unigrams and bigrams. Bigrams let it separate "cancel my order" from
+// "don't cancel my order", which plain keyword counts cannot.
+type Classifier struct {
+	// DocCounts is the number of training examples per label
+	DocCounts map[string]int `json:"doc_counts"`
+	// FeatureCounts holds n-gram counts per label
+	FeatureCounts map[string]map[string]int `json:"feature_counts"`
+	// TotalFeatures is the sum of FeatureCounts per label
+	TotalFeatures map[string]int `json:"total_features"`
+	// Vocabulary is every n-gram seen during training
+	Vocabulary map[string]struct{} `json:"vocabulary"`
+}
+
+// Prediction is the outcome of classifying a piece of text
+type Prediction struct {
+	Label      string             `json:"label"`
+	Confidence float64            `json:"confidence"`
+	Scores     map[string]float64 `json:"scores"` // posterior probability per label
+}
+
+// LabeledExample is one training line
+type LabeledExample struct {
+	ConversationID string
+	Sender         string
+	Text           string
+	Label          string
+}
+
+func NewClassifier() *Classifier {
+	return &Classifier{
+		DocCounts:     make(map[string]int),
+		FeatureCounts: make(map[string]map[string]int),
+		TotalFeatures: make(map[string]int),
+		Vocabulary:    make(map[string]struct{}),
+	}
+}
+
+// Train adds a single labeled example to the model
+func (c *Classifier) Train(text, label string) {
+	c.DocCounts[label]++
+	counts, ok := c.FeatureCounts[label]
+	if !ok {
+		counts = make(map[string]int)
+		c.FeatureCounts[label] = counts
+	}
+	for _, feature := range intentFeatures(text) {
+		counts[feature]++
+		c.TotalFeatures[label]++
+		c.Vocabulary[feature] = struct{}{}
+	}
+}
+
+// Labels returns the known labels in sorted order
+func (c *Classifier) Labels() []string {
+	labels := make([]string, 0, len(c.DocCounts))
+	for label := range c.DocCounts {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Predict returns the most likely label for text with its posterior probability
+func (c *Classifier) Predict(text string) Prediction {
+	labels := c.Labels()
+	if len(labels) == 0 {
+		return Prediction{}
+	}
+
+	totalDocs := 0
+	for _, n := range c.DocCounts {
+		totalDocs += n
+	}
+	vocab := float64(len(c.Vocabulary))
+	features := intentFeatures(text)
+
+	// Log-likelihoods with Laplace smoothing
+	logScores := make(map[string]float64, len(labels))
+	maxLog := math.Inf(-1)
+	for _, label := range labels {
+		score := math.Log(float64(c.DocCounts[label]) / float64(totalDocs))
+		denom := float64(c.TotalFeatures[label]) + vocab
+		for _, feature := range features {
+			if _, known := c.Vocabulary[feature]; !known {
+				continue
+			}
+			score += math.Log((float64(c.FeatureCounts[label][feature]) + 1) / denom)
+		}
+		logScores[label] = score
+		if score > maxLog {
+			maxLog = score
+		}
+	}
+
+	// Normalize to probabilities (log-sum-exp)
+	var sum float64
+	for _, score := range logScores {
+		sum += math.Exp(score - maxLog)
+	}
+	prediction := Prediction{Scores: make(map[string]float64, len(labels))}
+	for _, label := range labels {
+		p := math.Exp(logScores[label]-maxLog) / sum
+		prediction.Scores[label] = p
+		if p > prediction.Confidence {
+			prediction.Label = label
+			prediction.Confidence = p
+		}
+	}
+	return prediction
+}
+
+// Evidence returns up to n features of text that most favour label over the
+// other labels, by log-likelihood ratio
+func (c *Classifier) Evidence(text, label string, n int) []string {
+	vocab := float64(len(c.Vocabulary))
+	var otherTotal int
+	for l, total := range c.TotalFeatures {
+		if l != label {
+			otherTotal += total
+		}
+	}
+
+	type scored struct {
+		feature string
+		ratio   float64
+	}
+	seen := make(map[string]bool)
+	var candidates []scored
+	for _, feature := range intentFeatures(text) {
+		if _, known := c.Vocabulary[feature]; !known || seen[feature] {
+			continue
+		}
+		seen[feature] = true
+		var other int
+		for l, counts := range c.FeatureCounts {
+			if l != label {
+				other += counts[feature]
+			}
+		}
+		pLabel := (float64(c.FeatureCounts[label][feature]) + 1) / (float64(c.TotalFeatures[label]) + vocab)
+		pOther := (float64(other) + 1) / (float64(otherTotal) + vocab)
+		if ratio := math.Log(pLabel / pOther); ratio > 0 {
+			candidates = append(candidates, scored{feature, ratio})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ratio > candidates[j].ratio })
+
+	var evidence []string
+	for i := 0; i < len(candidates) && i < n; i++ {
+		evidence = append(evidence, candidates[i].feature)
+	}
+	return evidence
+}
+
+// Save writes the model to path as JSON
+func (c *Classifier) Save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal classifier: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write classifier: %w", err)
+	}
+	return nil
+}
+
+// LoadClassifier reads a model previously written by Save
+func LoadClassifier(path string) (*Classifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read classifier: %w", err)
+	}
+	c := NewClassifier()
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal classifier: %w", err)
+	}
+	return c, nil
+}
+
+// ReadLabeledTranscripts parses lines in the "conv|sender|text|label" format.
+// Blank lines and lines starting with '#' are ignored.
+func ReadLabeledTranscripts(r io.Reader) ([]LabeledExample, error) {
+	var examples []LabeledExample
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, "|")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("line %d: expected conv|sender|text|label, got %d fields", lineNo, len(parts))
+		}
+		label := strings.TrimSpace(parts[3])
+		if label == "" {
+			return nil, fmt.Errorf("line %d: missing label", lineNo)
+		}
+		examples = append(examples, LabeledExample{
+			ConversationID: strings.TrimSpace(parts[0]),
+			Sender:         strings.TrimSpace(parts[1]),
+			Text:           strings.TrimSpace(parts[2]),
+			Label:          label,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return examples, nil
+}
+
+// intentFeatures returns lowercased unigrams and bigrams. Apostrophes are kept
+// inside words so that negations like "don't" survive as a single token.
+func intentFeatures(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+	features := make([]string, 0, len(words)*2)
+	for i, word := range words {
+		features = append(features, word)
+		if i > 0 {
+			features = append(features, words[i-1]+" "+word)
+		}
+	}
+	return features
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const trainingData = `
+conv-1|user-1|I want to cancel my order|cancellation
+conv-1|user-1|Please cancel my subscription|cancellation
+conv-2|user-2|cancel my order now|cancellation
+conv-3|user-3|Don't cancel my order|retention
+conv-3|user-3|Please do not cancel my order|retention
+conv-4|user-4|don't cancel it, I still want my order|retention
+conv-5|user-5|Where is my package|order_status
+`
+
+func trainedClassifier(t *testing.T) *Classifier {
+	t.Helper()
+	examples, err := ReadLabeledTranscripts(strings.NewReader(trainingData))
+	if err != nil {
+		t.Fatalf("ReadLabeledTranscripts: %v", err)
+	}
+	c := NewClassifier()
+	for _, ex := range examples {
+		c.Train(ex.Text, ex.Label)
+	}
+	return c
+}
+
+func TestClassifierSeparatesNegation(t *testing.T) {
+	c := trainedClassifier(t)
+
+	if p := c.Predict("cancel my order please"); p.Label != "cancellation" {
+		t.Errorf("Expected cancellation, got %s (%.2f)", p.Label, p.Confidence)
+	}
+	if p := c.Predict("don't cancel my order"); p.Label != "retention" {
+		t.Errorf("Expected retention, got %s (%.2f)", p.Label, p.Confidence)
+	}
+}
+
+func TestClassifierSaveLoad(t *testing.T) {
+	c := trainedClassifier(t)
+	path := filepath.Join(t.TempDir(), "model.json")
+	if err := c.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := LoadClassifier(path)
+	if err != nil {
+		t.Fatalf("LoadClassifier: %v", err)
+	}
+
+	want := c.Predict("cancel my subscription")
+	got := loaded.Predict("cancel my subscription")
+	if got.Label != want.Label || got.Confidence != want.Confidence {
+		t.Errorf("Expected %+v after reload, got %+v", want, got)
+	}
+}
+
+func TestReadLabeledTranscriptsRejectsMissingLabel(t *testing.T) {
+	if _, err := ReadLabeledTranscripts(strings.NewReader("conv-1|user-1|hello")); err == nil {
+		t.Error("Expected error for line without label")
+	}
+}
+
+func TestEngineIntentCondition(t *testing.T) {
+	engine := NewEngine()
+	cond := Condition{Type: ConditionIntent, Intent: "cancellation", Operator: ">", Confidence: 0.8}
+	rule := ParsedRule{
+		Rule:             Rule{Name: "Cancellation", Action: "retention_team"},
+		ParsedConditions: []Condition{cond},
+	}
+
+	analysis := Analysis{Intent: "cancellation", IntentConfidence: 0.93}
+	if actions := engine.EvaluateAnalysis(analysis, []ParsedRule{rule}); len(actions) != 1 {
+		t.Errorf("Expected escalation, got %v", actions)
+	}
+
+	analysis = Analysis{Intent: "cancellation", IntentConfidence: 0.6}
+	if actions := engine.EvaluateAnalysis(analysis, []ParsedRule{rule}); len(actions) != 0 {
+		t.Errorf("Expected no action below confidence, got %v", actions)
+	}
+
+	analysis = Analysis{Intent: "retention", IntentConfidence: 0.99}
+	if actions := engine.EvaluateAnalysis(analysis, []ParsedRule{rule}); len(actions) != 0 {
+		t.Errorf("Expected no action for other intent, got %v", actions)
+	}
+}
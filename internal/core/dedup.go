@@ -0,0 +1,70 @@
+package core
+
+import "sync"
+
+// DedupKey identifies a message across retries and redeliveries
+type DedupKey struct {
+	ClientID  string
+	SessionID string
+	MessageID string
+}
+
+// DedupWindow remembers the most recent message keys, evicting the oldest
+// once it holds size keys. It is safe for concurrent use.
+type DedupWindow struct {
+	mu    sync.Mutex
+	keys  map[DedupKey]struct{}
+	order []DedupKey // ring of keys in insertion order
+	next  int        // ring slot of the next insertion
+}
+
+func NewDedupWindow(size int) *DedupWindow {
+	if size < 1 {
+		size = 1
+	}
+	return &DedupWindow{
+		keys:  make(map[DedupKey]struct{}, size),
+		order: make([]DedupKey, 0, size),
+	}
+}
+
+// Add records key and reports whether it was already in the window
+func (w *DedupWindow) Add(key DedupKey) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.keys[key]; ok {
+		return true
+	}
+	if len(w.order) < cap(w.order) {
+		w.order = append(w.order, key)
+	} else {
+		delete(w.keys, w.order[w.next])
+		w.order[w.next] = key
+		w.next = (w.next + 1) % len(w.order)
+	}
+	w.keys[key] = struct{}{}
+	return false
+}
+
+// Contains reports whether key is in the window without recording it
+func (w *DedupWindow) Contains(key DedupKey) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.keys[key]
+	return ok
+}
+
+// Remove forgets key, e.g. when its message could not be processed
+func (w *DedupWindow) Remove(key DedupKey) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.keys, key)
+}
+
+// Len returns the number of keys in the window
+func (w *DedupWindow) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.keys)
+}
@@ -0,0 +1,25 @@
+package core
+
+import "testing"
+
+func TestDedupWindow(t *testing.T) {
+	w := NewDedupWindow(2)
+	a := DedupKey{ClientID: "acme", SessionID: "s1", MessageID: "m1"}
+	b := DedupKey{ClientID: "acme", SessionID: "s1", MessageID: "m2"}
+	c := DedupKey{ClientID: "globex", SessionID: "s1", MessageID: "m1"}
+
+	if w.Add(a) || w.Add(b) {
+		t.Fatal("First sightings can't be duplicates")
+	}
+	if !w.Add(a) {
+		t.Error("Expected a repeat to be a duplicate")
+	}
+	// Same session and message id from another client is a different message
+	if w.Add(c) {
+		t.Error("Expected another client's message not to be a duplicate")
+	}
+	// c evicted a, the oldest key
+	if w.Len() != 2 || w.Add(a) {
+		t.Errorf("Expected the oldest key to be evicted, len=%d", w.Len())
+	}
+}
@@ -1,31 +1,228 @@
 package core
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Condition types
+const (
+	ConditionWord       = "word"
+	ConditionIntent     = "intent"
+	ConditionSimilarity = "similarity"
+)
 
 // Condition represents a single check, e.g., "word 'help' count >= 3"
+// or "intent 'cancellation' with confidence > 0.8"
+// or "similar_to('complaints') > 0.6"
 type Condition struct {
-	Word     string `json:"word"`
-	Operator string `json:"operator"` // ">", ">=", "==", etc.
-	Count    int    `json:"count"`
+	Type       string  `json:"type,omitempty"` // "word" (default), "intent" or "similarity"
+	Word       string  `json:"word"`
+	Operator   string  `json:"operator"` // ">", ">=", "==", etc.
+	Count      int     `json:"count"`
+	Intent     string  `json:"intent,omitempty"`     // label predicted by the intent classifier
+	Confidence float64 `json:"confidence,omitempty"` // compared against the prediction confidence
+	Set        string  `json:"set,omitempty"`        // exemplar set of the rule to compare against
+	Threshold  float64 `json:"threshold,omitempty"`  // compared against the best cosine similarity
+	Sender     string  `json:"sender,omitempty"`     // restricts a word count to one sender, e.g. "CUSTOMER"
+}
+
+// Kind returns the condition type, defaulting to a word count check
+func (c Condition) Kind() string {
+	if c.Type == "" {
+		return ConditionWord
+	}
+	return c.Type
+}
+
+// Validate checks that the condition is well formed for its type
+func (c Condition) Validate() error {
+	if !validOperator(c.Operator) {
+		return fmt.Errorf("invalid operator %q", c.Operator)
+	}
+	switch c.Kind() {
+	case ConditionWord:
+		if c.Word == "" {
+			return fmt.Errorf("word condition requires a word")
+		}
+	case ConditionIntent:
+		if c.Intent == "" {
+			return fmt.Errorf("intent condition requires an intent")
+		}
+		if c.Confidence < 0 || c.Confidence > 1 {
+			return fmt.Errorf("intent confidence must be between 0 and 1")
+		}
+	case ConditionSimilarity:
+		if c.Set == "" {
+			return fmt.Errorf("similarity condition requires an exemplar set")
+		}
+		if c.Threshold < 0 || c.Threshold > 1 {
+			return fmt.Errorf("similarity threshold must be between 0 and 1")
+		}
+	default:
+		return fmt.Errorf("unknown condition type %q", c.Type)
+	}
+	return nil
 }
 
+// Rule modes
+const (
+	// RuleModeActive rules are evaluated and dispatch their action
+	RuleModeActive = "active"
+	// RuleModeShadow rules are evaluated and their would-be firings recorded, but never act
+	RuleModeShadow = "shadow"
+	// RuleModeDisabled rules are not evaluated
+	RuleModeDisabled = "disabled"
+)
+
+// Rule triggers
+const (
+	// TriggerMessage rules are evaluated on every message
+	TriggerMessage = "message"
+	// TriggerSessionEnd rules are evaluated once, on the whole conversation,
+	// when the session ends, e.g. "ended without a resolution phrase"
+	TriggerSessionEnd = "session_end"
+)
+
 // Rule represents an escalation rule
 type Rule struct {
 	ID         string          `json:"id"`
 	Name       string          `json:"name"`
 	Conditions json.RawMessage `json:"conditions"` // Stored as JSON in DB, unmarshaled to []Condition
 	Action     string          `json:"action"`     // e.g., "log", "webhook"
+
+	// Exemplars are named sets of example phrases used by similarity conditions
+	Exemplars map[string][]string `json:"exemplars,omitempty"`
+
+	// PolicyID optionally attaches an escalation ladder; without one the rule
+	// dispatches Action once
+	PolicyID string `json:"policy_id,omitempty"`
+
+	// Tests are example utterances with expected outcomes, run on every save
+	Tests []RuleTest `json:"tests,omitempty"`
+
+	// Mode is "active" (default), "shadow" or "disabled"
+	Mode string `json:"mode,omitempty"`
+
+	// Trigger is "message" (default) or "session_end"
+	Trigger string `json:"trigger,omitempty"`
+
+	// FireOnInterim lets the rule fire on interim transcripts; other rules
+	// only mark the session at risk until the final transcript arrives
+	FireOnInterim bool `json:"fire_on_interim,omitempty"`
+}
+
+// RuleTrigger returns when the rule is evaluated, defaulting to every message
+func (r Rule) RuleTrigger() string {
+	if r.Trigger == "" {
+		return TriggerMessage
+	}
+	return r.Trigger
+}
+
+// RulesForTrigger filters the rules evaluated on the given trigger
+func RulesForTrigger(rules []ParsedRule, trigger string) []ParsedRule {
+	var filtered []ParsedRule
+	for _, rule := range rules {
+		if rule.RuleTrigger() == trigger {
+			filtered = append(filtered, rule)
+		}
+	}
+	return filtered
+}
+
+// RuleMode returns the rule mode, defaulting to active
+func (r Rule) RuleMode() string {
+	if r.Mode == "" {
+		return RuleModeActive
+	}
+	return r.Mode
 }
 
 // ParsedRule is a helper struct with unmarshaled conditions
 type ParsedRule struct {
 	Rule
 	ParsedConditions []Condition
+
+	// ExemplarIndexes is built from Rule.Exemplars, see IndexExemplars
+	ExemplarIndexes map[string]*ExemplarIndex
+}
+
+// Validate checks every condition and that similarity conditions reference
+// an exemplar set of the rule
+func (r ParsedRule) Validate() error {
+	switch r.RuleMode() {
+	case RuleModeActive, RuleModeShadow, RuleModeDisabled:
+	default:
+		return fmt.Errorf("unknown rule mode %q", r.Mode)
+	}
+	switch r.RuleTrigger() {
+	case TriggerMessage, TriggerSessionEnd:
+	default:
+		return fmt.Errorf("unknown rule trigger %q", r.Trigger)
+	}
+	if r.FireOnInterim && r.RuleTrigger() != TriggerMessage {
+		return fmt.Errorf("only %s rules can fire on interim transcripts", TriggerMessage)
+	}
+	for i, cond := range r.ParsedConditions {
+		if err := cond.Validate(); err != nil {
+			return fmt.Errorf("condition %d: %w", i, err)
+		}
+		if cond.Kind() == ConditionSimilarity && len(r.Exemplars[cond.Set]) == 0 {
+			return fmt.Errorf("condition %d: exemplar set %q is missing or empty", i, cond.Set)
+		}
+	}
+	for _, test := range r.Tests {
+		if err := test.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Analysis represents the result of analyzing a conversation
 type Analysis struct {
 	WordCounts map[string]int
+	Text       string
+	MessageID  string
+
+	// SenderWordCounts and TokenTurns are only set for cumulative session analyses
+	SenderWordCounts map[string]map[string]int // lowercased sender -> word -> count
+	TokenTurns       map[string][]string       // word -> message ids it occurred in
+
+	// Intent is the top label of the intent classifier, if one is configured
+	Intent           string
+	IntentConfidence float64
+	IntentEvidence   []string // n-grams that most favoured Intent
+}
+
+// turns returns the message ids the analysis was computed from
+func (a Analysis) turns() []string {
+	if a.MessageID == "" {
+		return nil
+	}
+	return []string{a.MessageID}
+}
+
+// turnsFor returns the message ids in which word occurred
+func (a Analysis) turnsFor(word string) []string {
+	if a.TokenTurns != nil {
+		return a.TokenTurns[word]
+	}
+	return a.turns()
+}
+
+// wordCount returns the count of word, for a single sender if one is given
+func (a Analysis) wordCount(word, sender string) int {
+	if sender == "" {
+		return a.WordCounts[word]
+	}
+	if a.SenderWordCounts == nil {
+		// Per-message analyses don't know the sender breakdown
+		return 0
+	}
+	return a.SenderWordCounts[strings.ToLower(sender)][word]
 }
 
 // Tokenize splits content into words (simple implementation)
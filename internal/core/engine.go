@@ -12,32 +12,172 @@ func NewEngine() *Engine {
 	return &Engine{}
 }
 
-// Evaluate checks if the analysis meets any rule conditions and returns triggered actions
+// Evaluate checks if the word counts meet any rule conditions and returns triggered actions
 func (e *Engine) Evaluate(analysis map[string]int, rules []ParsedRule) []string {
+	return e.EvaluateAnalysis(Analysis{WordCounts: analysis}, rules)
+}
+
+// EvaluateAnalysis checks if the analysis meets any rule conditions and returns triggered actions
+func (e *Engine) EvaluateAnalysis(analysis Analysis, rules []ParsedRule) []string {
 	var actions []string
 
+	for _, rule := range e.MatchedRules(analysis, rules) {
+		actions = append(actions, rule.Action)
+	}
+
+	return actions
+}
+
+// MatchedRules returns the rules whose conditions all hold for the analysis
+func (e *Engine) MatchedRules(analysis Analysis, rules []ParsedRule) []ParsedRule {
+	var matched []ParsedRule
+
 	for _, rule := range rules {
-		if e.matches(analysis, rule.ParsedConditions) {
+		if rule.RuleMode() != RuleModeActive {
+			continue
+		}
+		if e.matches(analysis, rule) {
 			log.Printf("Rule matched: %s", rule.Name)
-			actions = append(actions, rule.Action)
+			matched = append(matched, rule)
 		}
 	}
 
-	return actions
+	return matched
+}
+
+// AtRisk returns the names of rules for which some, but not all, conditions hold.
+// A session with such a partial match is moved to the at-risk status.
+func (e *Engine) AtRisk(analysis Analysis, rules []ParsedRule) []string {
+	var names []string
+	for _, rule := range rules {
+		if rule.RuleMode() != RuleModeActive {
+			continue
+		}
+		passed := 0
+		for _, cond := range rule.ParsedConditions {
+			if e.check(analysis, rule, cond) {
+				passed++
+			}
+		}
+		if passed > 0 && passed < len(rule.ParsedConditions) {
+			names = append(names, rule.Name)
+		}
+	}
+	return names
 }
 
-func (e *Engine) matches(analysis map[string]int, conditions []Condition) bool {
+func (e *Engine) matches(analysis Analysis, rule ParsedRule) bool {
 	// All conditions must match (AND logic)
 	// For OR logic, we'd need a more complex structure
-	for _, cond := range conditions {
-		actualCount := analysis[strings.ToLower(cond.Word)]
-		if !compare(actualCount, cond.Count, cond.Operator) {
+	for _, cond := range rule.ParsedConditions {
+		if !e.check(analysis, rule, cond) {
 			return false
 		}
 	}
 	return true
 }
 
+func (e *Engine) check(analysis Analysis, rule ParsedRule, cond Condition) bool {
+	return e.evaluate(analysis, rule, cond).Passed
+}
+
+// evaluate checks a single condition and records what was observed
+func (e *Engine) evaluate(analysis Analysis, rule ParsedRule, cond Condition) ConditionTrace {
+	trace := ConditionTrace{Type: cond.Kind(), Operator: cond.Operator}
+
+	switch cond.Kind() {
+	case ConditionWord:
+		word := strings.ToLower(cond.Word)
+		actualCount := analysis.wordCount(word, cond.Sender)
+		trace.Subject = word
+		if cond.Sender != "" {
+			trace.Detail = "sender " + cond.Sender
+		}
+		trace.Observed = float64(actualCount)
+		trace.Threshold = float64(cond.Count)
+		trace.Passed = compare(actualCount, cond.Count, cond.Operator)
+		if actualCount > 0 {
+			trace.Tokens = []string{word}
+			trace.Turns = analysis.turnsFor(word)
+		}
+	case ConditionIntent:
+		trace.Subject = cond.Intent
+		trace.Threshold = cond.Confidence
+		trace.Detail = "predicted " + analysis.Intent
+		// The intent has to be the top prediction; the operator applies to its confidence
+		if strings.EqualFold(analysis.Intent, cond.Intent) {
+			trace.Observed = analysis.IntentConfidence
+			trace.Passed = compareFloat(analysis.IntentConfidence, cond.Confidence, cond.Operator)
+			trace.Tokens = analysis.IntentEvidence
+			trace.Turns = analysis.turns()
+		}
+	case ConditionSimilarity:
+		trace.Subject = cond.Set
+		trace.Threshold = cond.Threshold
+		index := rule.ExemplarIndexes[cond.Set]
+		if index == nil {
+			// Indexes are built once when rules are loaded, see IndexRules
+			trace.Detail = "unknown or unindexed exemplar set"
+			return trace
+		}
+		score, best := index.BestMatch(analysis.Text)
+		trace.Observed = score
+		trace.Passed = compareFloat(score, cond.Threshold, cond.Operator)
+		if best >= 0 {
+			trace.Detail = "closest exemplar: " + rule.Exemplars[cond.Set][best]
+			trace.Turns = analysis.turns()
+		}
+	default:
+		trace.Detail = "unknown condition type"
+	}
+	return trace
+}
+
+// Decide evaluates every condition of every enabled rule, without
+// short-circuiting, and returns the matched rules with a trace of the whole
+// evaluation. Shadow rules that would have fired are returned separately and
+// must not be acted on.
+func (e *Engine) Decide(analysis Analysis, rules []ParsedRule) Decision {
+	var decision Decision
+	for _, rule := range rules {
+		mode := rule.RuleMode()
+		if mode == RuleModeDisabled {
+			continue
+		}
+		rt := RuleTrace{
+			RuleID:   rule.ID,
+			RuleName: rule.Name,
+			Action:   rule.Action,
+			Mode:     mode,
+			Fired:    len(rule.ParsedConditions) > 0,
+		}
+		for _, cond := range rule.ParsedConditions {
+			ct := e.evaluate(analysis, rule, cond)
+			rt.Fired = rt.Fired && ct.Passed
+			rt.Conditions = append(rt.Conditions, ct)
+		}
+		switch {
+		case rt.Fired && mode == RuleModeShadow:
+			log.Printf("Shadow rule matched: %s", rule.Name)
+			decision.Shadow = append(decision.Shadow, rule)
+		case rt.Fired:
+			log.Printf("Rule matched: %s", rule.Name)
+			decision.Matched = append(decision.Matched, rule)
+		}
+		decision.Trace = append(decision.Trace, rt)
+	}
+	return decision
+}
+
+func validOperator(op string) bool {
+	switch op {
+	case ">", ">=", "<", "<=", "==":
+		return true
+	default:
+		return false
+	}
+}
+
 func compare(actual, target int, op string) bool {
 	switch op {
 	case ">":
@@ -54,3 +194,20 @@ func compare(actual, target int, op string) bool {
 		return false
 	}
 }
+
+func compareFloat(actual, target float64, op string) bool {
+	switch op {
+	case ">":
+		return actual > target
+	case ">=":
+		return actual >= target
+	case "<":
+		return actual < target
+	case "<=":
+		return actual <= target
+	case "==":
+		return actual == target
+	default:
+		return false
+	}
+}
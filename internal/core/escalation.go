@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// EscalationLevel is one step of an escalation ladder
+type EscalationLevel struct {
+	Action string `json:"action"` // e.g., "notify_team_lead"
+	// TimeoutSeconds is how long to wait for an acknowledgement before moving
+	// to the next level. It is ignored on the last level.
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// Timeout returns the level timeout as a duration
+func (l EscalationLevel) Timeout() time.Duration {
+	return time.Duration(l.TimeoutSeconds) * time.Second
+}
+
+// EscalationPolicy is an ordered ladder of levels attached to rules, e.g.
+// team lead -> supervisor (after 2m) -> duty manager (after 5m)
+type EscalationPolicy struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name"`
+	Levels []EscalationLevel `json:"levels"`
+}
+
+// Validate checks that the policy has levels with actions and that every
+// level except the last has a timeout
+func (p EscalationPolicy) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("policy name is required")
+	}
+	if len(p.Levels) == 0 {
+		return fmt.Errorf("at least one level is required")
+	}
+	for i, level := range p.Levels {
+		if level.Action == "" {
+			return fmt.Errorf("level %d: action is required", i)
+		}
+		if i < len(p.Levels)-1 && level.TimeoutSeconds <= 0 {
+			return fmt.Errorf("level %d: timeout_seconds must be positive", i)
+		}
+	}
+	return nil
+}
+
+// SingleLevelPolicy wraps a plain rule action for rules without a policy
+func SingleLevelPolicy(action string) EscalationPolicy {
+	return EscalationPolicy{Name: action, Levels: []EscalationLevel{{Action: action}}}
+}
+
+// EscalationStatus is the state of a running escalation ladder
+type EscalationStatus string
+
+const (
+	// EscalationOpen escalations advance to the next level when their timeout expires
+	EscalationOpen EscalationStatus = "open"
+	// EscalationAcknowledged escalations were stopped by an acknowledgement
+	EscalationAcknowledged EscalationStatus = "acknowledged"
+	// EscalationExhausted escalations reached the last level without acknowledgement
+	EscalationExhausted EscalationStatus = "exhausted"
+)
+
+// Escalation is a ladder started by a rule for a session
+type Escalation struct {
+	ID             string           `json:"id"`
+	SessionID      string           `json:"session_id"`
+	RuleID         string           `json:"rule_id"`
+	PolicyID       string           `json:"policy_id,omitempty"`
+	Level          int              `json:"level"` // index of the last dispatched level
+	Status         EscalationStatus `json:"status"`
+	CreatedAt      int64            `json:"created_at"` // unix millis
+	UpdatedAt      int64            `json:"updated_at"` // unix millis
+	NextAt         int64            `json:"next_at"`    // unix millis when the next level is due, 0 if none
+	AcknowledgedBy string           `json:"acknowledged_by,omitempty"`
+	TraceID        string           `json:"trace_id,omitempty"` // decision trace of the message that fired the rule
+	ClientID       string           `json:"client_id,omitempty"`
+}
+
+// EscalationEvent is published whenever an escalation level is dispatched:
+// when a rule starts a ladder and when the ladder advances
+type EscalationEvent struct {
+	ID           int64   `json:"id"` // increasing, subscribers resume after the last id they saw
+	SessionID    string  `json:"session_id"`
+	ClientID     string  `json:"client_id,omitempty"`
+	MessageID    string  `json:"message_id,omitempty"` // empty for ladder advancement
+	EscalationID string  `json:"escalation_id"`
+	RuleID       string  `json:"rule_id"`
+	RuleName     string  `json:"rule_name,omitempty"`
+	Action       string  `json:"action"`
+	Level        int     `json:"level"`
+	Score        float64 `json:"score"`
+	TraceID      string  `json:"trace_id,omitempty"`
+	Timestamp    int64   `json:"timestamp"` // unix millis
+}
+
+// EscalationFilter selects escalation events; empty fields match everything
+type EscalationFilter struct {
+	ClientID  string `json:"client_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	RuleID    string `json:"rule_id,omitempty"`
+	Action    string `json:"action,omitempty"`
+}
+
+// Matches reports whether the event passes every set field of the filter
+func (f EscalationFilter) Matches(event EscalationEvent) bool {
+	return (f.ClientID == "" || f.ClientID == event.ClientID) &&
+		(f.SessionID == "" || f.SessionID == event.SessionID) &&
+		(f.RuleID == "" || f.RuleID == event.RuleID) &&
+		(f.Action == "" || f.Action == event.Action)
+}
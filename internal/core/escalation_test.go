@@ -0,0 +1,50 @@
+package core
+
+import "testing"
+
+func TestEscalationPolicyValidate(t *testing.T) {
+	policy := EscalationPolicy{
+		Name: "Complaint ladder",
+		Levels: []EscalationLevel{
+			{Action: "notify_team_lead", TimeoutSeconds: 120},
+			{Action: "notify_supervisor", TimeoutSeconds: 300},
+			{Action: "page_duty_manager"},
+		},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Errorf("Expected valid policy, got %v", err)
+	}
+
+	policy.Levels[1].TimeoutSeconds = 0
+	if err := policy.Validate(); err == nil {
+		t.Error("Expected error for intermediate level without timeout")
+	}
+
+	if err := (EscalationPolicy{Name: "empty"}).Validate(); err == nil {
+		t.Error("Expected error for policy without levels")
+	}
+
+	if err := SingleLevelPolicy("human_handoff").Validate(); err != nil {
+		t.Errorf("Expected single level policy to be valid, got %v", err)
+	}
+}
+
+func TestEscalationFilterMatches(t *testing.T) {
+	event := EscalationEvent{SessionID: "s1", ClientID: "acme", RuleID: "r1", Action: "notify_team_lead"}
+
+	tests := []struct {
+		filter EscalationFilter
+		want   bool
+	}{
+		{EscalationFilter{}, true},
+		{EscalationFilter{ClientID: "acme"}, true},
+		{EscalationFilter{ClientID: "acme", Action: "notify_team_lead"}, true},
+		{EscalationFilter{ClientID: "globex"}, false},
+		{EscalationFilter{SessionID: "s1", RuleID: "r2"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Matches(event); got != tt.want {
+			t.Errorf("%+v.Matches() = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
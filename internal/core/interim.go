@@ -0,0 +1,71 @@
+package core
+
+import conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+
+// IsInterim reports whether a chunk is an interim transcript hypothesis that
+// a later chunk with the same message id replaces. Chunks without is_final
+// are final.
+func IsInterim(chunk *conversationv1.ConversationChunk) bool {
+	return chunk.IsFinal != nil && !*chunk.IsFinal
+}
+
+// utterance tracks the interim hypotheses of a message
+type utterance struct {
+	revision uint32
+	final    bool
+	fired    map[string]bool // rule ids fired on its interim text
+}
+
+// InterimTracker follows the interim revisions of the utterances of a session
+// so that stale hypotheses are superseded and rules fired on interim text
+// don't fire again on the final text. Only utterances that had interim
+// chunks are tracked. It is not safe for concurrent use; it is owned by the
+// session's worker.
+type InterimTracker struct {
+	utterances map[string]*utterance
+}
+
+func NewInterimTracker() *InterimTracker {
+	return &InterimTracker{utterances: make(map[string]*utterance)}
+}
+
+// Interim records an interim hypothesis and reports whether it is the newest
+// one of its utterance. Hypotheses older than one already seen, or arriving
+// after the final result, are superseded.
+func (t *InterimTracker) Interim(messageID string, revision uint32) bool {
+	u, ok := t.utterances[messageID]
+	if !ok {
+		t.utterances[messageID] = &utterance{revision: revision, fired: make(map[string]bool)}
+		return true
+	}
+	if u.final || revision <= u.revision {
+		return false
+	}
+	u.revision = revision
+	return true
+}
+
+// Fire records that a rule fired on the interim text of an utterance and
+// reports whether it already had
+func (t *InterimTracker) Fire(messageID, ruleID string) bool {
+	u, ok := t.utterances[messageID]
+	if !ok {
+		return false
+	}
+	fired := u.fired[ruleID]
+	u.fired[ruleID] = true
+	return fired
+}
+
+// Final marks the utterance final and returns the rules that already fired
+// on its interim text
+func (t *InterimTracker) Final(messageID string) map[string]bool {
+	u, ok := t.utterances[messageID]
+	if !ok {
+		return nil
+	}
+	u.final = true
+	fired := u.fired
+	u.fired = nil
+	return fired
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"testing"
+
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+func TestInterimTracker(t *testing.T) {
+	tr := NewInterimTracker()
+
+	if !tr.Interim("m1", 1) || !tr.Interim("m1", 3) {
+		t.Fatal("Expected newer revisions to be accepted")
+	}
+	if tr.Interim("m1", 2) || tr.Interim("m1", 3) {
+		t.Error("Expected older and repeated revisions to be superseded")
+	}
+	if tr.Fire("m1", "r1") || !tr.Fire("m1", "r1") {
+		t.Error("Expected a rule to fire once per utterance")
+	}
+
+	fired := tr.Final("m1")
+	if !fired["r1"] || len(fired) != 1 {
+		t.Errorf("Expected r1 to be reported as fired, got %v", fired)
+	}
+	if tr.Interim("m1", 4) {
+		t.Error("Expected interim text after the final result to be superseded")
+	}
+	if tr.Final("m2") != nil {
+		t.Error("Expected no fired rules for a message without interim text")
+	}
+}
+
+func TestSessionAggregateSpeculate(t *testing.T) {
+	final := false
+	agg := NewSessionAggregate("s1")
+	agg.Add(&conversationv1.ConversationChunk{MessageId: "m1", Sender: "customer"}, map[string]int{"refund": 1})
+
+	interim := &conversationv1.ConversationChunk{MessageId: "m2", Sender: "customer", IsFinal: &final}
+	if !IsInterim(interim) || IsInterim(&conversationv1.ConversationChunk{}) {
+		t.Fatal("Expected only chunks with is_final=false to be interim")
+	}
+	analysis := agg.Speculate(interim, Analysis{WordCounts: map[string]int{"refund": 1, "now": 1}})
+	if analysis.WordCounts["refund"] != 2 || analysis.SenderWordCounts["customer"]["now"] != 1 {
+		t.Errorf("Expected the interim counts on top of the session, got %v", analysis.WordCounts)
+	}
+	if agg.WordCounts["refund"] != 1 || agg.SenderWordCounts["customer"]["now"] != 0 || agg.Turns != 1 {
+		t.Errorf("Expected the session to be unchanged, got %v", agg.WordCounts)
+	}
+}
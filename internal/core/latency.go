@@ -0,0 +1,393 @@
+package core
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// LatencyStage is a step of a chunk's path from the producer to its actions,
+// named after the timestamp it ends at
+type LatencyStage string
+
+const (
+	// StageNetwork runs from the producer timestamp to the gRPC receive. It
+	// compares two clocks, so skew shows up in it; negative values count as 0.
+	StageNetwork LatencyStage = "network"
+	// StageIntake runs from the receive to queueing on the session worker
+	StageIntake LatencyStage = "intake"
+	// StageQueue is the wait for the session worker, including for queue space
+	StageQueue LatencyStage = "queue"
+	// StageAnalysis runs from the worker start to the analysis, including any
+	// wait in the reorder buffer
+	StageAnalysis LatencyStage = "analysis"
+	// StageDecision is the rule evaluation
+	StageDecision LatencyStage = "decision"
+	// StageDispatch records the decision and dispatches the actions
+	StageDispatch LatencyStage = "dispatch"
+	// StageTotal runs from the producer timestamp, or the receive without
+	// one, to the last timestamp reached
+	StageTotal LatencyStage = "total"
+)
+
+// latencyStages are the stages between consecutive timestamps, in path order
+var latencyStages = []LatencyStage{StageNetwork, StageIntake, StageQueue, StageAnalysis, StageDecision, StageDispatch}
+
+// ChunkTimings are the timestamps of a chunk along its path. Timestamps the
+// chunk did not reach, e.g. the decision without a database, are zero.
+type ChunkTimings struct {
+	Produced   time.Time // the chunk's TimestampMs
+	Received   time.Time
+	Enqueued   time.Time
+	Started    time.Time // the session worker picked up the chunk
+	Analyzed   time.Time
+	Decided    time.Time
+	Dispatched time.Time
+}
+
+func (t ChunkTimings) stamps() []time.Time {
+	return []time.Time{t.Produced, t.Received, t.Enqueued, t.Started, t.Analyzed, t.Decided, t.Dispatched}
+}
+
+// Stages returns the duration of every stage both ends of which were reached,
+// and the total
+func (t ChunkTimings) Stages() map[LatencyStage]time.Duration {
+	stamps := t.stamps()
+	stages := make(map[LatencyStage]time.Duration, len(stamps))
+	for i, stage := range latencyStages {
+		from, to := stamps[i], stamps[i+1]
+		if from.IsZero() || to.IsZero() {
+			continue
+		}
+		stages[stage] = max(to.Sub(from), 0)
+	}
+	if total, ok := t.Total(); ok {
+		stages[StageTotal] = total
+	}
+	return stages
+}
+
+// Total returns the time from the producer timestamp, or the receive, to the
+// last timestamp reached; false when neither start is known
+func (t ChunkTimings) Total() (time.Duration, bool) {
+	start := t.Produced
+	if start.IsZero() {
+		start = t.Received
+	}
+	if start.IsZero() {
+		return 0, false
+	}
+	var end time.Time
+	for _, stamp := range t.stamps() {
+		if stamp.After(end) {
+			end = stamp
+		}
+	}
+	return max(end.Sub(start), 0), true
+}
+
+// Histogram buckets span 100µs to about a minute, each bound 25% above the
+// previous one, so that percentiles are within 25% of the exact value
+const (
+	histogramMin     = 100 * time.Microsecond
+	histogramFactor  = 1.25
+	histogramBuckets = 60
+)
+
+// Histogram counts durations in exponential buckets. It is not safe for
+// concurrent use.
+type Histogram struct {
+	counts [histogramBuckets + 1]uint64 // the last bucket holds everything above the bounds
+	count  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// bucket returns the index of the bucket holding d
+func bucket(d time.Duration) int {
+	if d <= histogramMin {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(histogramMin)) / math.Log(histogramFactor)))
+	return min(i, histogramBuckets)
+}
+
+// bucketBound returns the upper bound of bucket i
+func bucketBound(i int) time.Duration {
+	return time.Duration(float64(histogramMin) * math.Pow(histogramFactor, float64(i)))
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	d = max(d, 0)
+	h.counts[bucket(d)]++
+	h.count++
+	h.sum += d
+	h.max = max(h.max, d)
+}
+
+// Merge adds the observations of o
+func (h *Histogram) Merge(o *Histogram) {
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.count += o.count
+	h.sum += o.sum
+	h.max = max(h.max, o.max)
+}
+
+// Quantile returns the upper bound of the bucket holding the q quantile,
+// capped at the largest observation
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank && c > 0 {
+			return min(bucketBound(i), h.max)
+		}
+	}
+	return h.max
+}
+
+// LatencySummary summarises a histogram in milliseconds
+type LatencySummary struct {
+	Count  uint64  `json:"count"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P95Ms  float64 `json:"p95_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+func (h *Histogram) Summary() LatencySummary {
+	s := LatencySummary{
+		Count: h.count,
+		P50Ms: ms(h.Quantile(0.50)),
+		P95Ms: ms(h.Quantile(0.95)),
+		P99Ms: ms(h.Quantile(0.99)),
+		MaxMs: ms(h.max),
+	}
+	if h.count > 0 {
+		s.MeanMs = ms(h.sum / time.Duration(h.count))
+	}
+	return s
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// maxLatencyClients bounds the clients with their own histograms; further
+// clients are aggregated under OtherClients
+const maxLatencyClients = 1000
+
+const (
+	// UnknownClient aggregates chunks without a client id
+	UnknownClient = "unknown"
+	// OtherClients aggregates the clients beyond maxLatencyClients
+	OtherClients = "other"
+)
+
+// ClientLatency is the latency of one client's chunks
+type ClientLatency struct {
+	Stages     map[LatencyStage]LatencySummary `json:"stages"`
+	OverBudget uint64                          `json:"over_budget"`
+}
+
+// LatencyStats is a snapshot of a LatencyRecorder
+type LatencyStats struct {
+	BudgetMs   float64                         `json:"budget_ms"` // 0 when disabled
+	WindowMs   float64                         `json:"window_ms"` // the stats cover the last one to two windows
+	Stages     map[LatencyStage]LatencySummary `json:"stages"`
+	OverBudget uint64                          `json:"over_budget"`
+	Clients    map[string]ClientLatency        `json:"clients"`
+}
+
+type latencyHistograms struct {
+	stages     map[LatencyStage]*Histogram
+	overBudget uint64
+}
+
+func newLatencyHistograms() *latencyHistograms {
+	return &latencyHistograms{stages: make(map[LatencyStage]*Histogram)}
+}
+
+func (l *latencyHistograms) observe(stages map[LatencyStage]time.Duration, over bool) {
+	for stage, d := range stages {
+		h, ok := l.stages[stage]
+		if !ok {
+			h = &Histogram{}
+			l.stages[stage] = h
+		}
+		h.Observe(d)
+	}
+	if over {
+		l.overBudget++
+	}
+}
+
+// merge adds the observations of o
+func (l *latencyHistograms) merge(o *latencyHistograms) {
+	for stage, h := range o.stages {
+		mine, ok := l.stages[stage]
+		if !ok {
+			mine = &Histogram{}
+			l.stages[stage] = mine
+		}
+		mine.Merge(h)
+	}
+	l.overBudget += o.overBudget
+}
+
+func (l *latencyHistograms) summaries() map[LatencyStage]LatencySummary {
+	summaries := make(map[LatencyStage]LatencySummary, len(l.stages))
+	for stage, h := range l.stages {
+		summaries[stage] = h.Summary()
+	}
+	return summaries
+}
+
+// DefaultLatencyWindow is how long observations count towards the stats
+const DefaultLatencyWindow = time.Minute
+
+// latencySlot holds the observations of one window
+type latencySlot struct {
+	all     *latencyHistograms
+	clients map[string]*latencyHistograms
+}
+
+func newLatencySlot() *latencySlot {
+	return &latencySlot{
+		all:     newLatencyHistograms(),
+		clients: make(map[string]*latencyHistograms),
+	}
+}
+
+// LatencyRecorder aggregates chunk timings into histograms per stage, overall
+// and per client, and flags chunks whose total latency exceeds a budget. The
+// histograms slide: observations are kept for the current and the previous
+// window, so the stats cover the last one to two windows and long-past
+// spikes don't weigh on them. It is safe for concurrent use.
+type LatencyRecorder struct {
+	mu        sync.Mutex
+	budget    time.Duration
+	window    time.Duration
+	current   *latencySlot
+	previous  *latencySlot
+	rotatedAt time.Time
+	now       func() time.Time
+}
+
+// NewLatencyRecorder creates a recorder over DefaultLatencyWindow; a budget
+// of 0 flags no chunk
+func NewLatencyRecorder(budget time.Duration) *LatencyRecorder {
+	return &LatencyRecorder{
+		budget:    budget,
+		window:    DefaultLatencyWindow,
+		current:   newLatencySlot(),
+		previous:  newLatencySlot(),
+		rotatedAt: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (r *LatencyRecorder) SetBudget(budget time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.budget = budget
+}
+
+// SetWindow changes how long observations count towards the stats
+func (r *LatencyRecorder) SetWindow(window time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.window = window
+}
+
+// rotate starts a new window once the current one is over; r.mu must be held
+func (r *LatencyRecorder) rotate() {
+	elapsed := r.now().Sub(r.rotatedAt)
+	if elapsed < r.window {
+		return
+	}
+	if elapsed < 2*r.window {
+		r.previous = r.current
+	} else {
+		r.previous = newLatencySlot()
+	}
+	r.current = newLatencySlot()
+	r.rotatedAt = r.now()
+}
+
+// Over reports whether the total latency of t exceeds the budget
+func (r *LatencyRecorder) Over(t ChunkTimings) bool {
+	r.mu.Lock()
+	budget := r.budget
+	r.mu.Unlock()
+	total, ok := t.Total()
+	return ok && budget > 0 && total > budget
+}
+
+// Observe records the timings of a chunk of clientID and reports whether it
+// exceeded the budget
+func (r *LatencyRecorder) Observe(clientID string, t ChunkTimings) bool {
+	stages := t.Stages()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rotate()
+
+	total, ok := stages[StageTotal]
+	over := ok && r.budget > 0 && total > r.budget
+	if clientID == "" {
+		clientID = UnknownClient
+	}
+	slot := r.current
+	client, ok := slot.clients[clientID]
+	if !ok {
+		if len(slot.clients) >= maxLatencyClients {
+			clientID = OtherClients
+		}
+		if client, ok = slot.clients[clientID]; !ok {
+			client = newLatencyHistograms()
+			slot.clients[clientID] = client
+		}
+	}
+	slot.all.observe(stages, over)
+	client.observe(stages, over)
+	return over
+}
+
+func (r *LatencyRecorder) Stats() LatencyStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rotate()
+
+	all := newLatencyHistograms()
+	clients := make(map[string]*latencyHistograms)
+	for _, slot := range []*latencySlot{r.previous, r.current} {
+		all.merge(slot.all)
+		for id, h := range slot.clients {
+			client, ok := clients[id]
+			if !ok {
+				client = newLatencyHistograms()
+				clients[id] = client
+			}
+			client.merge(h)
+		}
+	}
+
+	stats := LatencyStats{
+		BudgetMs:   ms(r.budget),
+		WindowMs:   ms(r.window),
+		Stages:     all.summaries(),
+		OverBudget: all.overBudget,
+		Clients:    make(map[string]ClientLatency, len(clients)),
+	}
+	for id, client := range clients {
+		stats.Clients[id] = ClientLatency{Stages: client.summaries(), OverBudget: client.overBudget}
+	}
+	return stats
+}
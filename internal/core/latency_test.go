@@ -0,0 +1,134 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantiles(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 100; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.50, 50 * time.Millisecond},
+		{0.95, 95 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+	} {
+		got := h.Quantile(tc.q)
+		// Buckets are 25% wide and report their upper bound
+		if got < tc.want || float64(got) > float64(tc.want)*histogramFactor {
+			t.Errorf("Quantile(%v) = %v, expected within 25%% above %v", tc.q, got, tc.want)
+		}
+	}
+	if got := h.Quantile(1); got != 100*time.Millisecond {
+		t.Errorf("Expected the top quantile capped at the max, got %v", got)
+	}
+
+	s := h.Summary()
+	if s.Count != 100 || s.MaxMs != 100 || s.MeanMs != 50.5 {
+		t.Errorf("Unexpected summary %+v", s)
+	}
+}
+
+func TestChunkTimingsStages(t *testing.T) {
+	start := time.UnixMilli(1_700_000_000_000)
+	timings := ChunkTimings{
+		Produced: start,
+		Received: start.Add(5 * time.Millisecond),
+		Enqueued: start.Add(6 * time.Millisecond),
+		Started:  start.Add(16 * time.Millisecond),
+		Analyzed: start.Add(18 * time.Millisecond),
+		// No database: no decision nor dispatch
+	}
+
+	stages := timings.Stages()
+	want := map[LatencyStage]time.Duration{
+		StageNetwork:  5 * time.Millisecond,
+		StageIntake:   time.Millisecond,
+		StageQueue:    10 * time.Millisecond,
+		StageAnalysis: 2 * time.Millisecond,
+		StageTotal:    18 * time.Millisecond,
+	}
+	if len(stages) != len(want) {
+		t.Errorf("Expected stages %v, got %v", want, stages)
+	}
+	for stage, d := range want {
+		if stages[stage] != d {
+			t.Errorf("Expected %s=%v, got %v", stage, d, stages[stage])
+		}
+	}
+
+	// A producer clock ahead of the server's doesn't make the network negative
+	timings.Produced = start.Add(10 * time.Millisecond)
+	if got := timings.Stages()[StageNetwork]; got != 0 {
+		t.Errorf("Expected skew clamped to 0, got %v", got)
+	}
+}
+
+func TestLatencyRecorderBudget(t *testing.T) {
+	r := NewLatencyRecorder(50 * time.Millisecond)
+	now := time.Now()
+	fast := ChunkTimings{Received: now, Dispatched: now.Add(10 * time.Millisecond)}
+	slow := ChunkTimings{Received: now, Dispatched: now.Add(80 * time.Millisecond)}
+
+	if r.Observe("acme", fast) {
+		t.Error("Expected a fast chunk within the budget")
+	}
+	if !r.Observe("acme", slow) || !r.Over(slow) {
+		t.Error("Expected a slow chunk over the budget")
+	}
+	r.Observe("", fast)
+
+	stats := r.Stats()
+	if stats.OverBudget != 1 || stats.Stages[StageTotal].Count != 3 {
+		t.Errorf("Unexpected overall stats %+v", stats)
+	}
+	if c := stats.Clients["acme"]; c.OverBudget != 1 || c.Stages[StageTotal].Count != 2 {
+		t.Errorf("Unexpected client stats %+v", c)
+	}
+	if stats.Clients[UnknownClient].Stages[StageTotal].Count != 1 {
+		t.Error("Expected chunks without client id under the unknown client")
+	}
+
+	r.SetBudget(0)
+	if r.Observe("acme", slow) {
+		t.Error("Expected no budget to flag nothing")
+	}
+}
+
+func TestLatencyRecorderWindow(t *testing.T) {
+	r := NewLatencyRecorder(50 * time.Millisecond)
+	clock := time.Now()
+	r.now = func() time.Time { return clock }
+	r.rotatedAt = clock
+	r.SetWindow(time.Minute)
+
+	slow := ChunkTimings{Received: clock, Dispatched: clock.Add(time.Second)}
+	fast := ChunkTimings{Received: clock, Dispatched: clock.Add(time.Millisecond)}
+	r.Observe("acme", slow)
+
+	clock = clock.Add(90 * time.Second)
+	r.Observe("acme", fast)
+	if stats := r.Stats(); stats.Stages[StageTotal].Count != 2 || stats.OverBudget != 1 {
+		t.Errorf("Expected the previous window to still count, got %+v", stats)
+	}
+
+	clock = clock.Add(time.Minute)
+	stats := r.Stats()
+	if stats.Stages[StageTotal].Count != 1 || stats.OverBudget != 0 {
+		t.Errorf("Expected the slow chunk to slide out, got %+v", stats)
+	}
+	if c := stats.Clients["acme"]; c.Stages[StageTotal].MaxMs > 10 {
+		t.Errorf("Expected only the fast chunk in the client stats, got %+v", c)
+	}
+
+	clock = clock.Add(3 * time.Minute)
+	if stats := r.Stats(); len(stats.Stages) != 0 || len(stats.Clients) != 0 {
+		t.Errorf("Expected an idle recorder to forget everything, got %+v", stats)
+	}
+}
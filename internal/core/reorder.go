@@ -0,0 +1,395 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+// LatePolicy decides what happens to a chunk that arrives after later chunks
+// of its session were already processed
+type LatePolicy string
+
+const (
+	// LateProcess processes the late chunk as if it arrived in order
+	LateProcess LatePolicy = "process"
+	// LateDrop discards the late chunk
+	LateDrop LatePolicy = "drop"
+	// LateReevaluate folds the late chunk into the session counts and
+	// re-evaluates the rules on the conversation so far. Intent and similarity
+	// conditions, which apply to the latest message, are not evaluated on it.
+	LateReevaluate LatePolicy = "reevaluate"
+)
+
+// ParseLatePolicy validates a late chunk policy name
+func ParseLatePolicy(s string) (LatePolicy, error) {
+	switch p := LatePolicy(s); p {
+	case LateProcess, LateDrop, LateReevaluate:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown late chunk policy %q", s)
+}
+
+// lateWindow is how far behind the next expected sequence skipped sequences
+// are remembered to recognise late chunks. Chunks further behind can't be
+// told apart from copies and are reported as late; copies are still caught
+// by their message id.
+const lateWindow = 1024
+
+// ReorderStats counts how chunks arrived
+type ReorderStats struct {
+	InOrder   int64 `json:"in_order"`
+	Reordered int64 `json:"reordered"` // held back until the chunks before them arrived
+	Skipped   int64 `json:"skipped"`   // sequences given up on after the bounded wait
+	Late      int64 `json:"late"`      // arrived after later chunks were released
+}
+
+// Add sums the counters
+func (s *ReorderStats) Add(o ReorderStats) {
+	s.InOrder += o.InOrder
+	s.Reordered += o.Reordered
+	s.Skipped += o.Skipped
+	s.Late += o.Late
+}
+
+// SeqRange is a range of sequences, both ends included
+type SeqRange struct {
+	From, To uint64
+}
+
+// Arrival classifies a chunk pushed to a ReorderBuffer
+type Arrival int
+
+const (
+	// ArrivalReady chunks are released in order, possibly with held ones
+	ArrivalReady Arrival = iota
+	// ArrivalHeld chunks wait for the chunks before them
+	ArrivalHeld
+	// ArrivalLate chunks arrived after later chunks were released
+	ArrivalLate
+	// ArrivalDuplicate chunks were already released or are already held
+	ArrivalDuplicate
+)
+
+type pendingChunk struct {
+	chunk   *conversationv1.ConversationChunk
+	arrived time.Time
+}
+
+// ReorderBuffer releases the chunks of a session in sequence order. A chunk
+// that arrives ahead of a gap is held until the gap fills, for at most MaxWait
+// or until MaxPending chunks are held; the missing sequences are then skipped
+// and reported as late if they show up. Unsequenced chunks are held for
+// MaxWait and released in TimestampMs order; they are late when their
+// TimestampMs is before the latest one released. Chunks with neither are
+// released as they arrive.
+// It is not safe for concurrent use; it is owned by the session's worker.
+type ReorderBuffer struct {
+	MaxWait    time.Duration
+	MaxPending int
+	Stats      ReorderStats
+
+	next          uint64 // next expected sequence
+	lastTimestamp int64
+	pending       map[uint64]pendingChunk
+	stamped       []pendingChunk // unsequenced chunks held, by TimestampMs
+	skipped       []SeqRange     // skipped within lateWindow, in order
+	gaps          []SeqRange     // skipped since the last call to Gaps
+}
+
+// NewReorderBuffer creates a buffer expecting next as the first sequence
+func NewReorderBuffer(next uint64, maxWait time.Duration, maxPending int) *ReorderBuffer {
+	if next == 0 {
+		next = 1
+	}
+	return &ReorderBuffer{
+		MaxWait:    maxWait,
+		MaxPending: maxPending,
+		next:       next,
+		pending:    make(map[uint64]pendingChunk),
+	}
+}
+
+// Push adds a chunk that arrived at now and returns the chunks ready to be
+// processed, in order, with how the chunk itself arrived. Late and duplicate
+// chunks are not part of ready; the caller applies its LatePolicy to late
+// ones. Held chunks may be released by the same call, e.g. when the buffer
+// is full.
+func (b *ReorderBuffer) Push(chunk *conversationv1.ConversationChunk, now time.Time) ([]*conversationv1.ConversationChunk, Arrival) {
+	seq := chunk.Sequence
+	var ready []*conversationv1.ConversationChunk
+	arrival := ArrivalReady
+	switch {
+	case seq == 0 && chunk.TimestampMs == 0:
+		b.Stats.InOrder++
+		ready = append(ready, chunk)
+
+	case seq == 0:
+		if chunk.TimestampMs < b.lastTimestamp {
+			b.Stats.Late++
+			return nil, ArrivalLate
+		}
+		b.hold(chunk, now)
+		arrival = ArrivalHeld
+		if b.Pending() > b.MaxPending {
+			ready = b.releaseStamped(b.stamped[0].chunk.TimestampMs)
+		}
+
+	case seq < b.next:
+		if b.unskip(seq) {
+			b.Stats.Late++
+			return nil, ArrivalLate
+		}
+		return nil, ArrivalDuplicate
+
+	case seq == b.next:
+		b.Stats.InOrder++
+		b.next++
+		b.release(chunk)
+		ready = append([]*conversationv1.ConversationChunk{chunk}, b.drain()...)
+
+	default:
+		if _, ok := b.pending[seq]; ok {
+			return nil, ArrivalDuplicate
+		}
+		b.pending[seq] = pendingChunk{chunk: chunk, arrived: now}
+		arrival = ArrivalHeld
+		if b.Pending() > b.MaxPending {
+			ready = b.skipGap()
+		}
+	}
+	return append(ready, b.Expire(now)...), arrival
+}
+
+// Expire releases the chunks held for longer than MaxWait, skipping the
+// sequences they were waiting for. Unsequenced chunks are released with the
+// ones stamped before them.
+func (b *ReorderBuffer) Expire(now time.Time) []*conversationv1.ConversationChunk {
+	var ready []*conversationv1.ConversationChunk
+	for {
+		oldest, ok := b.oldestPending()
+		if !ok || now.Sub(oldest) < b.MaxWait {
+			break
+		}
+		ready = append(ready, b.skipGap()...)
+	}
+	var until int64
+	expired := false
+	for _, p := range b.stamped {
+		if now.Sub(p.arrived) >= b.MaxWait {
+			until, expired = max(until, p.chunk.TimestampMs), true
+		}
+	}
+	if expired {
+		ready = append(ready, b.releaseStamped(until)...)
+	}
+	return ready
+}
+
+// Flush releases every held chunk, e.g. when the session ends
+func (b *ReorderBuffer) Flush() []*conversationv1.ConversationChunk {
+	var ready []*conversationv1.ConversationChunk
+	for len(b.pending) > 0 {
+		ready = append(ready, b.skipGap()...)
+	}
+	if len(b.stamped) > 0 {
+		ready = append(ready, b.releaseStamped(b.stamped[len(b.stamped)-1].chunk.TimestampMs)...)
+	}
+	return ready
+}
+
+// Advance moves the next expected sequence up to next, e.g. once the chunks
+// before it were processed by another replica. It returns the held chunks
+// then in order, and the held chunks before next, which are duplicates.
+func (b *ReorderBuffer) Advance(next uint64) (ready, duplicates []*conversationv1.ConversationChunk) {
+	if next <= b.next {
+		return nil, nil
+	}
+	for seq, p := range b.pending {
+		if seq < next {
+			delete(b.pending, seq)
+			duplicates = append(duplicates, p.chunk)
+		}
+	}
+	b.next = next
+	ready = b.drain()
+	b.forgetSkipped()
+	return ready, duplicates
+}
+
+// Gaps returns the ranges of sequences skipped since the last call, in order,
+// so that the caller stops waiting for them too
+func (b *ReorderBuffer) Gaps() []SeqRange {
+	gaps := b.gaps
+	b.gaps = nil
+	return gaps
+}
+
+// Released reports whether the chunk with seq was already released or
+// skipped, e.g. to recognise the retry of a chunk that failed
+func (b *ReorderBuffer) Released(seq uint64) bool {
+	return seq != 0 && seq < b.next
+}
+
+// Deadline returns when the oldest held chunk expires, if any is held
+func (b *ReorderBuffer) Deadline() (time.Time, bool) {
+	oldest, ok := b.oldestPending()
+	for _, p := range b.stamped {
+		if !ok || p.arrived.Before(oldest) {
+			oldest, ok = p.arrived, true
+		}
+	}
+	if !ok {
+		return time.Time{}, false
+	}
+	return oldest.Add(b.MaxWait), true
+}
+
+// Holds reports whether the chunk is held waiting for the chunks before it
+func (b *ReorderBuffer) Holds(chunk *conversationv1.ConversationChunk) bool {
+	if chunk.Sequence == 0 {
+		for _, p := range b.stamped {
+			if p.chunk == chunk {
+				return true
+			}
+		}
+		return false
+	}
+	p, ok := b.pending[chunk.Sequence]
+	return ok && p.chunk == chunk
+}
+
+// Pending returns the number of held chunks
+func (b *ReorderBuffer) Pending() int {
+	return len(b.pending) + len(b.stamped)
+}
+
+// hold keeps an unsequenced chunk in TimestampMs order, after the chunks
+// stamped the same
+func (b *ReorderBuffer) hold(chunk *conversationv1.ConversationChunk, now time.Time) {
+	i := sort.Search(len(b.stamped), func(i int) bool {
+		return b.stamped[i].chunk.TimestampMs > chunk.TimestampMs
+	})
+	b.stamped = append(b.stamped, pendingChunk{})
+	copy(b.stamped[i+1:], b.stamped[i:])
+	b.stamped[i] = pendingChunk{chunk: chunk, arrived: now}
+}
+
+// releaseStamped releases the unsequenced chunks stamped at or before until
+func (b *ReorderBuffer) releaseStamped(until int64) []*conversationv1.ConversationChunk {
+	var ready []*conversationv1.ConversationChunk
+	n := 0
+	for ; n < len(b.stamped) && b.stamped[n].chunk.TimestampMs <= until; n++ {
+		p := b.stamped[n]
+		if p.chunk.TimestampMs < b.lastTimestamp {
+			// A sequenced chunk stamped later was released meanwhile
+			b.Stats.Reordered++
+		} else {
+			b.Stats.InOrder++
+		}
+		b.release(p.chunk)
+		ready = append(ready, p.chunk)
+	}
+	b.stamped = append(b.stamped[:0], b.stamped[n:]...)
+	return ready
+}
+
+// skipGap gives up on the missing sequences before the lowest held one,
+// recording them for Gaps, and releases the chunks that are then in order
+func (b *ReorderBuffer) skipGap() []*conversationv1.ConversationChunk {
+	if len(b.pending) == 0 {
+		return nil
+	}
+	seqs := make([]uint64, 0, len(b.pending))
+	for seq := range b.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	if b.next < seqs[0] {
+		gap := SeqRange{From: b.next, To: seqs[0] - 1}
+		b.Stats.Skipped += int64(gap.To - gap.From + 1)
+		b.gaps = append(b.gaps, gap)
+		b.skipped = append(b.skipped, gap)
+	}
+	b.next = seqs[0]
+	ready := b.drain()
+	b.forgetSkipped()
+	return ready
+}
+
+// unskip reports whether seq was skipped, and no longer expects it. Sequences
+// too far behind to be remembered are assumed skipped.
+func (b *ReorderBuffer) unskip(seq uint64) bool {
+	if b.next > lateWindow && seq < b.next-lateWindow {
+		return true
+	}
+	for i, r := range b.skipped {
+		if seq < r.From || seq > r.To {
+			continue
+		}
+		switch {
+		case r.From == r.To:
+			b.skipped = append(b.skipped[:i], b.skipped[i+1:]...)
+		case seq == r.From:
+			b.skipped[i].From++
+		case seq == r.To:
+			b.skipped[i].To--
+		default:
+			b.skipped = append(b.skipped[:i+1], b.skipped[i:]...)
+			b.skipped[i].To = seq - 1
+			b.skipped[i+1].From = seq + 1
+		}
+		return true
+	}
+	return false
+}
+
+// forgetSkipped drops the skipped sequences that fell out of lateWindow
+func (b *ReorderBuffer) forgetSkipped() {
+	if b.next <= lateWindow {
+		return
+	}
+	floor := b.next - lateWindow
+	for len(b.skipped) > 0 && b.skipped[0].To < floor {
+		b.skipped = b.skipped[1:]
+	}
+	if len(b.skipped) > 0 && b.skipped[0].From < floor {
+		b.skipped[0].From = floor
+	}
+}
+
+// drain releases the held chunks that continue the sequence
+func (b *ReorderBuffer) drain() []*conversationv1.ConversationChunk {
+	var ready []*conversationv1.ConversationChunk
+	for {
+		p, ok := b.pending[b.next]
+		if !ok {
+			return ready
+		}
+		delete(b.pending, b.next)
+		b.next++
+		b.Stats.Reordered++
+		b.release(p.chunk)
+		ready = append(ready, p.chunk)
+	}
+}
+
+func (b *ReorderBuffer) release(chunk *conversationv1.ConversationChunk) {
+	if chunk.TimestampMs > b.lastTimestamp {
+		b.lastTimestamp = chunk.TimestampMs
+	}
+}
+
+// oldestPending returns when the oldest held sequenced chunk arrived
+func (b *ReorderBuffer) oldestPending() (time.Time, bool) {
+	var oldest time.Time
+	for _, p := range b.pending {
+		if oldest.IsZero() || p.arrived.Before(oldest) {
+			oldest = p.arrived
+		}
+	}
+	return oldest, !oldest.IsZero()
+}
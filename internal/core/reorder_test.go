@@ -0,0 +1,196 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+func seqs(chunks []*conversationv1.ConversationChunk) []uint64 {
+	var out []uint64
+	for _, c := range chunks {
+		out = append(out, c.Sequence)
+	}
+	return out
+}
+
+func equalSeqs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReorderBufferReorders(t *testing.T) {
+	buf := NewReorderBuffer(1, time.Second, 10)
+	now := time.Unix(0, 0)
+
+	ready, _ := buf.Push(&conversationv1.ConversationChunk{Sequence: 1}, now)
+	if !equalSeqs(seqs(ready), []uint64{1}) {
+		t.Fatalf("Expected 1 to be released, got %v", seqs(ready))
+	}
+	ready, arrival := buf.Push(&conversationv1.ConversationChunk{Sequence: 3}, now)
+	if arrival != ArrivalHeld || len(ready) != 0 || buf.Pending() != 1 {
+		t.Fatalf("Expected 3 to wait for 2, got %v", seqs(ready))
+	}
+	if _, arrival := buf.Push(&conversationv1.ConversationChunk{Sequence: 3}, now); arrival != ArrivalDuplicate {
+		t.Errorf("Expected a resend of a held chunk to be a duplicate, got %v", arrival)
+	}
+	ready, _ = buf.Push(&conversationv1.ConversationChunk{Sequence: 2}, now)
+	if !equalSeqs(seqs(ready), []uint64{2, 3}) {
+		t.Fatalf("Expected 2, 3 to be released, got %v", seqs(ready))
+	}
+	if buf.Stats.InOrder != 2 || buf.Stats.Reordered != 1 {
+		t.Errorf("Unexpected stats %+v", buf.Stats)
+	}
+}
+
+func TestReorderBufferBoundedWaitAndLate(t *testing.T) {
+	buf := NewReorderBuffer(1, time.Second, 10)
+	start := time.Unix(0, 0)
+
+	buf.Push(&conversationv1.ConversationChunk{Sequence: 1}, start)
+	buf.Push(&conversationv1.ConversationChunk{Sequence: 3}, start)
+	if deadline, ok := buf.Deadline(); !ok || !deadline.Equal(start.Add(time.Second)) {
+		t.Fatalf("Unexpected deadline %v %v", deadline, ok)
+	}
+	if ready := buf.Expire(start.Add(500 * time.Millisecond)); len(ready) != 0 {
+		t.Fatalf("Expected 3 to still wait, got %v", seqs(ready))
+	}
+	if ready := buf.Expire(start.Add(time.Second)); !equalSeqs(seqs(ready), []uint64{3}) {
+		t.Fatalf("Expected 3 to be released after the wait, got %v", seqs(ready))
+	}
+
+	ready, arrival := buf.Push(&conversationv1.ConversationChunk{Sequence: 2}, start.Add(2*time.Second))
+	if arrival != ArrivalLate || len(ready) != 0 {
+		t.Errorf("Expected 2 to be late, got %v arrival=%v", seqs(ready), arrival)
+	}
+	if _, arrival := buf.Push(&conversationv1.ConversationChunk{Sequence: 1}, start); arrival != ArrivalDuplicate {
+		t.Errorf("Expected a resend of a released chunk to be a duplicate, got %v", arrival)
+	}
+	if buf.Stats.Skipped != 1 || buf.Stats.Late != 1 {
+		t.Errorf("Unexpected stats %+v", buf.Stats)
+	}
+}
+
+func stamps(chunks []*conversationv1.ConversationChunk) []uint64 {
+	var out []uint64
+	for _, c := range chunks {
+		out = append(out, uint64(c.TimestampMs))
+	}
+	return out
+}
+
+func TestReorderBufferUnsequenced(t *testing.T) {
+	buf := NewReorderBuffer(1, time.Second, 10)
+	now := time.Unix(0, 0)
+
+	if _, arrival := buf.Push(&conversationv1.ConversationChunk{TimestampMs: 2000}, now); arrival != ArrivalHeld {
+		t.Fatalf("Expected the chunk to be held, got %v", arrival)
+	}
+	if _, arrival := buf.Push(&conversationv1.ConversationChunk{TimestampMs: 1000}, now.Add(500*time.Millisecond)); arrival != ArrivalHeld {
+		t.Fatalf("Expected an older chunk within the wait to be held, got %v", arrival)
+	}
+	if ready := buf.Expire(now.Add(time.Second)); !equalSeqs(stamps(ready), []uint64{1000, 2000}) {
+		t.Fatalf("Expected both chunks in timestamp order, got %v", stamps(ready))
+	}
+	if _, arrival := buf.Push(&conversationv1.ConversationChunk{TimestampMs: 1500}, now); arrival != ArrivalLate {
+		t.Errorf("Expected an older timestamp to be late, got %v", arrival)
+	}
+	if ready, arrival := buf.Push(&conversationv1.ConversationChunk{Text: "no timestamp"}, now); arrival != ArrivalReady || len(ready) != 1 {
+		t.Errorf("Expected a chunk without timestamp to be ready, got %v", arrival)
+	}
+}
+
+func TestReorderBufferRemembersRecentSkips(t *testing.T) {
+	buf := NewReorderBuffer(1, time.Second, 1)
+	now := time.Unix(0, 0)
+
+	// Skip 1, 3, 5, ... one at a time, well past the late window
+	for seq := uint64(2); seq <= 4*lateWindow; seq += 2 {
+		buf.Push(&conversationv1.ConversationChunk{Sequence: seq}, now)
+		buf.Push(&conversationv1.ConversationChunk{Sequence: seq + 2}, now)
+	}
+	if n := len(buf.skipped); n > lateWindow {
+		t.Fatalf("Expected the skipped sequences to stay within the late window, got %d ranges", n)
+	}
+	for _, seq := range []uint64{1, 4*lateWindow - 1} {
+		if _, arrival := buf.Push(&conversationv1.ConversationChunk{Sequence: seq}, now); arrival != ArrivalLate {
+			t.Errorf("Expected skipped %d to be late, got %v", seq, arrival)
+		}
+	}
+	if _, arrival := buf.Push(&conversationv1.ConversationChunk{Sequence: 4*lateWindow - 2}, now); arrival != ArrivalDuplicate {
+		t.Errorf("Expected a released sequence to be a duplicate, got %v", arrival)
+	}
+}
+
+func TestReorderBufferMaxPending(t *testing.T) {
+	buf := NewReorderBuffer(1, time.Hour, 2)
+	now := time.Unix(0, 0)
+
+	buf.Push(&conversationv1.ConversationChunk{Sequence: 3}, now)
+	buf.Push(&conversationv1.ConversationChunk{Sequence: 4}, now)
+	ready, _ := buf.Push(&conversationv1.ConversationChunk{Sequence: 5}, now)
+	if !equalSeqs(seqs(ready), []uint64{3, 4, 5}) {
+		t.Errorf("Expected the gap to be skipped once the buffer is full, got %v", seqs(ready))
+	}
+
+	if _, err := ParseLatePolicy("drop"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseLatePolicy("ignore"); err == nil {
+		t.Error("Expected an unknown policy to be rejected")
+	}
+}
+
+func TestReorderBufferAdvance(t *testing.T) {
+	buf := NewReorderBuffer(1, time.Second, 10)
+	now := time.Unix(0, 0)
+	for _, seq := range []uint64{3, 5, 6} {
+		buf.Push(&conversationv1.ConversationChunk{Sequence: seq}, now)
+	}
+
+	// 1 to 4 were processed elsewhere: 3 is a duplicate, 5 and 6 are in order
+	ready, duplicates := buf.Advance(5)
+	if !equalSeqs(seqs(ready), []uint64{5, 6}) || buf.Pending() != 0 {
+		t.Fatalf("Expected 5, 6 to be released, got %v", seqs(ready))
+	}
+	if !equalSeqs(seqs(duplicates), []uint64{3}) {
+		t.Errorf("Expected 3 to be a duplicate, got %v", seqs(duplicates))
+	}
+	if ready, _ := buf.Advance(2); len(ready) != 0 {
+		t.Errorf("Expected advancing backwards to release nothing, got %v", seqs(ready))
+	}
+	if _, arrival := buf.Push(&conversationv1.ConversationChunk{Sequence: 4}, now); arrival != ArrivalDuplicate {
+		t.Errorf("Expected a sequence before the advance to be a duplicate, got %v", arrival)
+	}
+}
+
+func TestReorderBufferGaps(t *testing.T) {
+	buf := NewReorderBuffer(1, time.Second, 10)
+	now := time.Unix(0, 0)
+	buf.Push(&conversationv1.ConversationChunk{Sequence: 1}, now)
+	buf.Push(&conversationv1.ConversationChunk{Sequence: 4}, now)
+	buf.Push(&conversationv1.ConversationChunk{Sequence: 7}, now)
+	if gaps := buf.Gaps(); len(gaps) != 0 {
+		t.Fatalf("Expected no gap while waiting, got %v", gaps)
+	}
+
+	ready := buf.Expire(now.Add(2 * time.Second))
+	if !equalSeqs(seqs(ready), []uint64{4, 7}) {
+		t.Fatalf("Expected 4 and 7 to be released, got %v", seqs(ready))
+	}
+	gaps := buf.Gaps()
+	if len(gaps) != 2 || gaps[0] != (SeqRange{From: 2, To: 3}) || gaps[1] != (SeqRange{From: 5, To: 6}) {
+		t.Errorf("Expected gaps 2-3 and 5-6, got %v", gaps)
+	}
+	if gaps := buf.Gaps(); len(gaps) != 0 {
+		t.Errorf("Expected the gaps to be reported once, got %v", gaps)
+	}
+}
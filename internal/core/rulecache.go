@@ -0,0 +1,56 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultRuleTTL is how long rules are served from memory before reloading;
+// rule edits take effect within this delay
+const DefaultRuleTTL = 5 * time.Second
+
+// RuleCache avoids a database round trip per message. It builds the exemplar
+// indexes of the rules once per reload, so evaluating them reuses the
+// indexes. It is a RuleStore itself and is safe for concurrent use.
+type RuleCache struct {
+	store RuleStore
+	ttl   time.Duration
+
+	mu       sync.RWMutex
+	rules    []ParsedRule
+	loadedAt time.Time
+}
+
+func NewRuleCache(store RuleStore, ttl time.Duration) *RuleCache {
+	return &RuleCache{store: store, ttl: ttl}
+}
+
+// GetAllRules returns the cached rules, reloading them once they are older
+// than the ttl. If reloading fails the last known rules are kept.
+func (c *RuleCache) GetAllRules() ([]ParsedRule, error) {
+	c.mu.RLock()
+	if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < c.ttl {
+		rules := c.rules
+		c.mu.RUnlock()
+		return rules, nil
+	}
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < c.ttl {
+		return c.rules, nil
+	}
+	rules, err := c.store.GetAllRules()
+	if err != nil {
+		if c.loadedAt.IsZero() {
+			return nil, err
+		}
+		// Keep serving the last known rules; retry after another ttl
+		c.loadedAt = time.Now()
+		return c.rules, nil
+	}
+	IndexRules(rules)
+	c.rules, c.loadedAt = rules, time.Now()
+	return rules, nil
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"fmt"
+
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+// RuleTest is an example embedded in a rule with its expected outcome.
+// Utterances is a single message or a whole conversation in order; the rule
+// is expected to fire on at least one of them when ExpectFire is set, and on
+// none of them otherwise.
+type RuleTest struct {
+	Name       string   `json:"name,omitempty"`
+	Utterances []string `json:"utterances"`
+	ExpectFire bool     `json:"expect_fire"`
+}
+
+// RuleTestResult is the outcome of running one RuleTest
+type RuleTestResult struct {
+	RuleID     string    `json:"rule_id,omitempty"`
+	RuleName   string    `json:"rule_name"`
+	Test       RuleTest  `json:"test"`
+	Fired      bool      `json:"fired"`
+	Passed     bool      `json:"passed"`
+	FiredOn    int       `json:"fired_on"` // index of the first utterance that fired, -1 if none
+	Trace      RuleTrace `json:"trace"`    // trace of the firing utterance, or of the last one
+	FailReason string    `json:"fail_reason,omitempty"`
+}
+
+// Validate checks that the test has at least one utterance
+func (t RuleTest) Validate() error {
+	if len(t.Utterances) == 0 {
+		return fmt.Errorf("test %q has no utterances", t.Name)
+	}
+	return nil
+}
+
+// RunRuleTests runs every embedded test of the rule through the analyzer and
+// engine. The rule is evaluated as if it were active, whatever its mode. The
+// utterances of a test are folded into one session aggregate like the chunks
+// of a live gRPC session, so counts accumulate across them. Per-message rules
+// are evaluated after every utterance; session-end rules are evaluated once on
+// the whole conversation. The Kafka consumer evaluates each message on its
+// own, so a multi-utterance test that only fires on the accumulated counts
+// does not fire on the same messages consumed from Kafka.
+func RunRuleTests(analyzer *Analyzer, engine *Engine, rule ParsedRule) []RuleTestResult {
+	rule.Mode = RuleModeActive
+	if rule.ExemplarIndexes == nil {
+		rule.ExemplarIndexes = IndexExemplars(rule.Exemplars)
+	}
+	sessionEnd := rule.RuleTrigger() == TriggerSessionEnd
+	results := make([]RuleTestResult, 0, len(rule.Tests))
+	for i, test := range rule.Tests {
+		result := RuleTestResult{
+			RuleID:   rule.ID,
+			RuleName: rule.Name,
+			Test:     test,
+			FiredOn:  -1,
+		}
+		agg := NewSessionAggregate("rule-test")
+		for j, text := range test.Utterances {
+			chunk := &conversationv1.ConversationChunk{
+				SessionId: "rule-test",
+				MessageId: fmt.Sprintf("test-%d-%d", i, j),
+				Text:      text,
+			}
+			current := analyzer.AnalyzeChunk(chunk)
+			agg.Add(chunk, current.WordCounts)
+			if sessionEnd {
+				continue
+			}
+			decision := engine.Decide(agg.Analysis(current), []ParsedRule{rule})
+			result.Trace = decision.Trace[0]
+			if len(decision.Matched) > 0 {
+				result.Fired = true
+				result.FiredOn = j
+				break
+			}
+		}
+		if sessionEnd {
+			decision := engine.Decide(agg.Analysis(Analysis{}), []ParsedRule{rule})
+			result.Trace = decision.Trace[0]
+			if len(decision.Matched) > 0 {
+				result.Fired = true
+				result.FiredOn = len(test.Utterances) - 1
+			}
+		}
+		results = append(results, result.finish())
+	}
+	return results
+}
+
+// finish compares the outcome with the expectation
+func (r RuleTestResult) finish() RuleTestResult {
+	r.Passed = r.Fired == r.Test.ExpectFire
+	if !r.Passed {
+		if r.Test.ExpectFire {
+			r.FailReason = "expected rule to fire but it did not"
+		} else {
+			r.FailReason = fmt.Sprintf("expected rule not to fire but it fired on utterance %d", r.FiredOn)
+		}
+	}
+	return r
+}
+
+// FailedRuleTests filters the failing results
+func FailedRuleTests(results []RuleTestResult) []RuleTestResult {
+	var failed []RuleTestResult
+	for _, r := range results {
+		if !r.Passed {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,78 @@
+package core
+
+import "testing"
+
+func TestRunRuleTests(t *testing.T) {
+	rule := ParsedRule{
+		Rule: Rule{
+			Name:   "Help Request",
+			Action: "human_handoff",
+			Tests: []RuleTest{
+				{Name: "repeated help", Utterances: []string{"hello", "help me please help"}, ExpectFire: true},
+				{Name: "help across utterances", Utterances: []string{"help", "thanks", "help again"}, ExpectFire: true},
+				{Name: "single help", Utterances: []string{"can you help"}, ExpectFire: false},
+				{Name: "wrong expectation", Utterances: []string{"thanks"}, ExpectFire: true},
+			},
+		},
+		ParsedConditions: []Condition{{Word: "help", Operator: ">=", Count: 2}},
+	}
+
+	results := RunRuleTests(NewAnalyzer(nil), NewEngine(), rule)
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(results))
+	}
+	if !results[0].Passed || results[0].FiredOn != 1 {
+		t.Errorf("Expected first test to pass firing on utterance 1, got %+v", results[0])
+	}
+	if !results[1].Passed || results[1].FiredOn != 2 {
+		t.Errorf("Expected the counts to accumulate across utterances, got %+v", results[1])
+	}
+	if !results[2].Passed {
+		t.Errorf("Expected third test to pass, got %+v", results[2])
+	}
+
+	failed := FailedRuleTests(results)
+	if len(failed) != 1 || failed[0].Test.Name != "wrong expectation" || failed[0].FailReason == "" {
+		t.Errorf("Expected only the wrong expectation to fail, got %+v", failed)
+	}
+}
+
+func TestRunRuleTestsSessionEnd(t *testing.T) {
+	// Fires when the conversation ends without the agent resolving it
+	rule := ParsedRule{
+		Rule: Rule{
+			Name:    "Ended Unresolved",
+			Action:  "follow_up",
+			Trigger: TriggerSessionEnd,
+			Tests: []RuleTest{
+				{Name: "unresolved", Utterances: []string{"my order is late", "we are looking into it"}, ExpectFire: true},
+				{Name: "resolved", Utterances: []string{"my order is late", "it is resolved now"}, ExpectFire: false},
+			},
+		},
+		ParsedConditions: []Condition{{Word: "resolved", Operator: "==", Count: 0}},
+	}
+	if err := rule.Validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+
+	results := RunRuleTests(NewAnalyzer(nil), NewEngine(), rule)
+	if failed := FailedRuleTests(results); len(failed) != 0 {
+		t.Errorf("Expected all tests to pass, got %+v", failed)
+	}
+	if results[0].FiredOn != 1 {
+		t.Errorf("Expected the rule to fire on the whole conversation, got utterance %d", results[0].FiredOn)
+	}
+
+	rules := []ParsedRule{rule, {Rule: Rule{Name: "per message"}}}
+	if end := RulesForTrigger(rules, TriggerSessionEnd); len(end) != 1 || end[0].Name != "Ended Unresolved" {
+		t.Errorf("Unexpected session end rules %+v", end)
+	}
+	if msg := RulesForTrigger(rules, TriggerMessage); len(msg) != 1 || msg[0].Name != "per message" {
+		t.Errorf("Unexpected message rules %+v", msg)
+	}
+
+	rule.Trigger = "hourly"
+	if err := rule.Validate(); err == nil {
+		t.Error("Expected an unknown trigger to be rejected")
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+// SequenceTracker records which chunk sequence numbers of a session were
+// processed, so that a reconnecting producer learns where to resume and
+// resent chunks are not counted twice. Sequences start at 1.
+// It is not safe for concurrent use; it is owned by the session's worker.
+type SequenceTracker struct {
+	// Contiguous is the highest sequence such that every sequence up to it was processed
+	Contiguous uint64
+	// ahead holds processed sequences beyond a gap
+	ahead map[uint64]struct{}
+	// skipped maps the first sequence of each gap given up on to its last
+	skipped map[uint64]uint64
+}
+
+// Seen reports whether seq was already processed
+func (t *SequenceTracker) Seen(seq uint64) bool {
+	if seq <= t.Contiguous {
+		return true
+	}
+	_, ok := t.ahead[seq]
+	return ok
+}
+
+// Mark records seq as processed
+func (t *SequenceTracker) Mark(seq uint64) {
+	if seq <= t.Contiguous {
+		return
+	}
+	if seq != t.Contiguous+1 {
+		if t.ahead == nil {
+			t.ahead = make(map[uint64]struct{})
+		}
+		t.ahead[seq] = struct{}{}
+		return
+	}
+	t.Contiguous = seq
+	t.extend()
+}
+
+// Advance records every sequence up to seq as processed, e.g. by the replica
+// the session was handed over from
+func (t *SequenceTracker) Advance(seq uint64) {
+	if seq <= t.Contiguous {
+		return
+	}
+	for s := range t.ahead {
+		if s <= seq {
+			delete(t.ahead, s)
+		}
+	}
+	for from, to := range t.skipped {
+		if to <= seq {
+			delete(t.skipped, from)
+		} else if from <= seq {
+			delete(t.skipped, from)
+			t.skipped[seq+1] = to
+		}
+	}
+	t.Contiguous = seq
+	t.extend()
+}
+
+// Skip records the sequences from..to as given up on, e.g. after the reorder
+// buffer stopped waiting for them, so that Contiguous moves past them once
+// the sequences before them are processed. If they arrive later they are
+// processed as late chunks.
+func (t *SequenceTracker) Skip(from, to uint64) {
+	if to <= t.Contiguous || from > to {
+		return
+	}
+	if from <= t.Contiguous {
+		from = t.Contiguous + 1
+	}
+	if t.skipped == nil {
+		t.skipped = make(map[uint64]uint64)
+	}
+	t.skipped[from] = to
+	t.extend()
+}
+
+// extend moves Contiguous over the processed and skipped sequences that
+// follow it
+func (t *SequenceTracker) extend() {
+	for {
+		if to, ok := t.skipped[t.Contiguous+1]; ok {
+			delete(t.skipped, t.Contiguous+1)
+			t.Contiguous = to
+			continue
+		}
+		if _, ok := t.ahead[t.Contiguous+1]; !ok {
+			return
+		}
+		delete(t.ahead, t.Contiguous+1)
+		t.Contiguous++
+	}
+}
@@ -0,0 +1,66 @@
+package core
+
+import "testing"
+
+func TestSequenceTracker(t *testing.T) {
+	var tracker SequenceTracker
+	for _, seq := range []uint64{1, 2, 4, 5} {
+		tracker.Mark(seq)
+	}
+	if tracker.Contiguous != 2 {
+		t.Fatalf("Expected contiguous 2 with a gap at 3, got %d", tracker.Contiguous)
+	}
+	if !tracker.Seen(4) || tracker.Seen(3) || tracker.Seen(6) {
+		t.Errorf("Unexpected seen state %+v", tracker)
+	}
+
+	tracker.Mark(3)
+	if tracker.Contiguous != 5 || len(tracker.ahead) != 0 {
+		t.Errorf("Expected the gap to close up to 5, got %+v", tracker)
+	}
+
+	// Resent chunks are recognised
+	tracker.Mark(2)
+	if tracker.Contiguous != 5 || !tracker.Seen(2) {
+		t.Errorf("Unexpected state after a resend %+v", tracker)
+	}
+}
+
+func TestSequenceTrackerAdvance(t *testing.T) {
+	var tracker SequenceTracker
+	tracker.Mark(1)
+	tracker.Mark(5)
+	tracker.Mark(7)
+
+	// Another replica processed up to 4
+	tracker.Advance(4)
+	if tracker.Contiguous != 5 || !tracker.Seen(7) || tracker.Seen(6) {
+		t.Errorf("Expected contiguous 5 with 7 ahead, got %+v", tracker)
+	}
+	tracker.Advance(3)
+	if tracker.Contiguous != 5 {
+		t.Errorf("Expected advancing backwards to be a no-op, got %+v", tracker)
+	}
+}
+
+func TestSequenceTrackerSkip(t *testing.T) {
+	var tracker SequenceTracker
+	tracker.Mark(1)
+	// 3 and 4 were given up on before 2 was processed
+	tracker.Skip(3, 4)
+	if tracker.Contiguous != 1 {
+		t.Fatalf("Expected contiguous to wait for 2, got %+v", tracker)
+	}
+	tracker.Mark(2)
+	tracker.Mark(5)
+	if tracker.Contiguous != 5 || len(tracker.skipped) != 0 {
+		t.Errorf("Expected contiguous 5 past the skipped gap, got %+v", tracker)
+	}
+
+	// A gap behind a processed sequence ahead
+	tracker.Mark(8)
+	tracker.Skip(6, 7)
+	if tracker.Contiguous != 8 || len(tracker.ahead) != 0 {
+		t.Errorf("Expected contiguous 8, got %+v", tracker)
+	}
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SessionStatus is the escalation status of a conversation
+type SessionStatus string
+
+const (
+	SessionActive       SessionStatus = "active"
+	SessionAtRisk       SessionStatus = "at_risk"
+	SessionEscalated    SessionStatus = "escalated"
+	SessionAcknowledged SessionStatus = "acknowledged"
+	SessionResolved     SessionStatus = "resolved"
+	SessionClosed       SessionStatus = "closed"
+)
+
+// ErrInvalidTransition is returned when a status change is not allowed
+var ErrInvalidTransition = errors.New("invalid session transition")
+
+// sessionTransitions lists the allowed target statuses for each status.
+// Resolved sessions may be reopened, and closed ones when their conversation
+// resumes, e.g. a producer reconnecting after its stream closed.
+var sessionTransitions = map[SessionStatus][]SessionStatus{
+	SessionActive:       {SessionAtRisk, SessionEscalated, SessionResolved, SessionClosed},
+	SessionAtRisk:       {SessionActive, SessionEscalated, SessionResolved, SessionClosed},
+	SessionEscalated:    {SessionAcknowledged, SessionResolved, SessionClosed},
+	SessionAcknowledged: {SessionEscalated, SessionResolved, SessionClosed},
+	SessionResolved:     {SessionActive, SessionClosed},
+	SessionClosed:       {SessionActive},
+}
+
+// ParseSessionStatus validates a status string
+func ParseSessionStatus(s string) (SessionStatus, error) {
+	status := SessionStatus(s)
+	if _, ok := sessionTransitions[status]; !ok {
+		return "", fmt.Errorf("unknown session status %q", s)
+	}
+	return status, nil
+}
+
+// CanTransition reports whether a session may move from one status to another
+func CanTransition(from, to SessionStatus) bool {
+	for _, allowed := range sessionTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTransition returns ErrInvalidTransition if the change is not allowed
+func CheckTransition(from, to SessionStatus) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+	return nil
+}
+
+// SessionState is the current status of a session and its history
+type SessionState struct {
+	SessionID   string              `json:"session_id"`
+	Status      SessionStatus       `json:"status"`
+	CreatedAt   int64               `json:"created_at"` // unix millis
+	UpdatedAt   int64               `json:"updated_at"` // unix millis
+	Transitions []SessionTransition `json:"transitions,omitempty"`
+}
+
+// SessionTransition records a single status change
+type SessionTransition struct {
+	From      SessionStatus `json:"from"`
+	To        SessionStatus `json:"to"`
+	Reason    string        `json:"reason,omitempty"`
+	Timestamp int64         `json:"timestamp"` // unix millis
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSessionTransitions(t *testing.T) {
+	allowed := [][2]SessionStatus{
+		{SessionActive, SessionAtRisk},
+		{SessionAtRisk, SessionEscalated},
+		{SessionEscalated, SessionAcknowledged},
+		{SessionAcknowledged, SessionResolved},
+		{SessionResolved, SessionClosed},
+		{SessionClosed, SessionActive},
+	}
+	for _, tr := range allowed {
+		if err := CheckTransition(tr[0], tr[1]); err != nil {
+			t.Errorf("Expected %s -> %s to be allowed: %v", tr[0], tr[1], err)
+		}
+	}
+
+	denied := [][2]SessionStatus{
+		{SessionActive, SessionAcknowledged},
+		{SessionEscalated, SessionEscalated},
+		{SessionClosed, SessionEscalated},
+	}
+	for _, tr := range denied {
+		if err := CheckTransition(tr[0], tr[1]); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("Expected %s -> %s to be rejected, got %v", tr[0], tr[1], err)
+		}
+	}
+
+	if _, err := ParseSessionStatus("escalated"); err != nil {
+		t.Errorf("ParseSessionStatus: %v", err)
+	}
+	if _, err := ParseSessionStatus("paused"); err == nil {
+		t.Error("Expected error for unknown status")
+	}
+}
+
+func TestEngineAtRisk(t *testing.T) {
+	engine := NewEngine()
+	rule := ParsedRule{
+		Rule: Rule{Name: "Angry Refund"},
+		ParsedConditions: []Condition{
+			{Word: "refund", Operator: ">=", Count: 1},
+			{Word: "angry", Operator: ">=", Count: 1},
+		},
+	}
+
+	if names := engine.AtRisk(Analysis{WordCounts: map[string]int{"refund": 1}}, []ParsedRule{rule}); len(names) != 1 {
+		t.Errorf("Expected partial match, got %v", names)
+	}
+	if names := engine.AtRisk(Analysis{WordCounts: map[string]int{"refund": 1, "angry": 1}}, []ParsedRule{rule}); len(names) != 0 {
+		t.Errorf("Expected full match not to be at risk, got %v", names)
+	}
+}
@@ -0,0 +1,63 @@
+package core
+
+import "sort"
+
+// ShadowRuleReport summarizes the would-be firings of one shadow rule
+type ShadowRuleReport struct {
+	RuleID   string `json:"rule_id"`
+	RuleName string `json:"rule_name"`
+	Firings  int    `json:"firings"`
+	Sessions int    `json:"sessions"`
+	// OverlappingSessions were also escalated by an active rule in the range
+	OverlappingSessions int `json:"overlapping_sessions"`
+	// ShadowOnlySessions would have been escalated only by this rule
+	ShadowOnlySessions int `json:"shadow_only_sessions"`
+}
+
+// ShadowReport compares shadow firings with actual escalations over a time range
+type ShadowReport struct {
+	From              int64              `json:"from"` // unix millis
+	To                int64              `json:"to"`   // unix millis
+	Escalations       int                `json:"escalations"`
+	EscalatedSessions int                `json:"escalated_sessions"`
+	Rules             []ShadowRuleReport `json:"rules"`
+}
+
+// BuildShadowReport aggregates shadow firings per rule and compares the
+// sessions they fired on with the sessions that were actually escalated
+func BuildShadowReport(from, to int64, firings []ShadowFiring, escalations []Escalation) ShadowReport {
+	report := ShadowReport{From: from, To: to, Escalations: len(escalations), Rules: []ShadowRuleReport{}}
+
+	escalated := make(map[string]bool)
+	for _, esc := range escalations {
+		escalated[esc.SessionID] = true
+	}
+	report.EscalatedSessions = len(escalated)
+
+	byRule := make(map[string]*ShadowRuleReport)
+	sessions := make(map[string]map[string]bool)
+	for _, f := range firings {
+		r, ok := byRule[f.RuleID]
+		if !ok {
+			r = &ShadowRuleReport{RuleID: f.RuleID, RuleName: f.RuleName}
+			byRule[f.RuleID] = r
+			sessions[f.RuleID] = make(map[string]bool)
+		}
+		r.Firings++
+		sessions[f.RuleID][f.SessionID] = true
+	}
+
+	for ruleID, r := range byRule {
+		for session := range sessions[ruleID] {
+			r.Sessions++
+			if escalated[session] {
+				r.OverlappingSessions++
+			} else {
+				r.ShadowOnlySessions++
+			}
+		}
+		report.Rules = append(report.Rules, *r)
+	}
+	sort.Slice(report.Rules, func(i, j int) bool { return report.Rules[i].Firings > report.Rules[j].Firings })
+	return report
+}
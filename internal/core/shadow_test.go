@@ -0,0 +1,49 @@
+package core
+
+import "testing"
+
+func TestEngineShadowAndDisabledRules(t *testing.T) {
+	engine := NewEngine()
+	cond := []Condition{{Word: "refund", Operator: ">=", Count: 1}}
+	rules := []ParsedRule{
+		{Rule: Rule{ID: "a", Name: "Active", Action: "page"}, ParsedConditions: cond},
+		{Rule: Rule{ID: "s", Name: "Shadow", Action: "page", Mode: RuleModeShadow}, ParsedConditions: cond},
+		{Rule: Rule{ID: "d", Name: "Disabled", Action: "page", Mode: RuleModeDisabled}, ParsedConditions: cond},
+	}
+	analysis := Analysis{WordCounts: map[string]int{"refund": 1}}
+
+	decision := engine.Decide(analysis, rules)
+	if len(decision.Matched) != 1 || decision.Matched[0].ID != "a" {
+		t.Errorf("Expected only the active rule to match, got %v", decision.Matched)
+	}
+	if len(decision.Shadow) != 1 || decision.Shadow[0].ID != "s" {
+		t.Errorf("Expected the shadow rule to be reported, got %v", decision.Shadow)
+	}
+	if len(decision.Trace) != 2 {
+		t.Errorf("Expected disabled rule to be skipped in the trace, got %d traces", len(decision.Trace))
+	}
+	if actions := engine.EvaluateAnalysis(analysis, rules); len(actions) != 1 {
+		t.Errorf("Expected a single dispatched action, got %v", actions)
+	}
+}
+
+func TestBuildShadowReport(t *testing.T) {
+	firings := []ShadowFiring{
+		{RuleID: "s", RuleName: "Shadow", SessionID: "s1"},
+		{RuleID: "s", RuleName: "Shadow", SessionID: "s1"},
+		{RuleID: "s", RuleName: "Shadow", SessionID: "s2"},
+	}
+	escalations := []Escalation{{SessionID: "s1"}, {SessionID: "s3"}}
+
+	report := BuildShadowReport(0, 100, firings, escalations)
+	if report.Escalations != 2 || report.EscalatedSessions != 2 {
+		t.Errorf("Unexpected escalation totals %+v", report)
+	}
+	if len(report.Rules) != 1 {
+		t.Fatalf("Expected one rule, got %+v", report.Rules)
+	}
+	r := report.Rules[0]
+	if r.Firings != 3 || r.Sessions != 2 || r.OverlappingSessions != 1 || r.ShadowOnlySessions != 1 {
+		t.Errorf("Unexpected rule report %+v", r)
+	}
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// ngramSize is the character n-gram length used for exemplar similarity.
+// Trigrams are robust to typos and inflections ("refund", "refunded").
+const ngramSize = 3
+
+// ExemplarIndex is an in-memory TF-IDF index over character n-grams of a
+// set of exemplar phrases. It needs no external model or network access.
+type ExemplarIndex struct {
+	idf     map[string]float64
+	unseen  float64 // idf assigned to n-grams that no exemplar contains
+	vectors []map[string]float64
+}
+
+// NewExemplarIndex builds an index over the given phrases
+func NewExemplarIndex(phrases []string) *ExemplarIndex {
+	ix := &ExemplarIndex{idf: make(map[string]float64)}
+
+	docs := make([]map[string]int, 0, len(phrases))
+	df := make(map[string]int)
+	for _, phrase := range phrases {
+		tf := charNgrams(phrase)
+		if len(tf) == 0 {
+			continue
+		}
+		docs = append(docs, tf)
+		for gram := range tf {
+			df[gram]++
+		}
+	}
+
+	// Smoothed idf, as in scikit-learn
+	n := float64(len(docs))
+	for gram, count := range df {
+		ix.idf[gram] = math.Log((1+n)/(1+float64(count))) + 1
+	}
+	ix.unseen = math.Log(1+n) + 1
+
+	for _, tf := range docs {
+		ix.vectors = append(ix.vectors, ix.vector(tf))
+	}
+	return ix
+}
+
+// Len returns the number of indexed exemplars
+func (ix *ExemplarIndex) Len() int {
+	return len(ix.vectors)
+}
+
+// Similarity returns the highest cosine similarity between text and any exemplar, in [0, 1]
+func (ix *ExemplarIndex) Similarity(text string) float64 {
+	best, _ := ix.BestMatch(text)
+	return best
+}
+
+// BestMatch returns the highest similarity and the index of the exemplar that produced it,
+// or -1 if the index is empty
+func (ix *ExemplarIndex) BestMatch(text string) (float64, int) {
+	tf := charNgrams(text)
+	if len(tf) == 0 || len(ix.vectors) == 0 {
+		return 0, -1
+	}
+	query := ix.vector(tf)
+
+	best, bestIdx := 0.0, -1
+	for i, doc := range ix.vectors {
+		var dot float64
+		for gram, w := range query {
+			dot += w * doc[gram]
+		}
+		if dot > best {
+			best, bestIdx = dot, i
+		}
+	}
+	return best, bestIdx
+}
+
+// vector returns the L2-normalized tf-idf vector of the term frequencies
+func (ix *ExemplarIndex) vector(tf map[string]int) map[string]float64 {
+	vec := make(map[string]float64, len(tf))
+	var norm float64
+	for gram, count := range tf {
+		idf, ok := ix.idf[gram]
+		if !ok {
+			idf = ix.unseen
+		}
+		w := float64(count) * idf
+		vec[gram] = w
+		norm += w * w
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return vec
+	}
+	for gram := range vec {
+		vec[gram] /= norm
+	}
+	return vec
+}
+
+// charNgrams counts character n-grams of the normalized text. Words are
+// padded with spaces so n-grams also capture word boundaries.
+func charNgrams(text string) map[string]int {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return nil
+	}
+	runes := []rune(" " + strings.Join(words, " ") + " ")
+
+	grams := make(map[string]int)
+	for i := 0; i+ngramSize <= len(runes); i++ {
+		grams[string(runes[i:i+ngramSize])]++
+	}
+	return grams
+}
+
+// IndexExemplars builds a similarity index for every exemplar set of the rule
+func IndexExemplars(exemplars map[string][]string) map[string]*ExemplarIndex {
+	if len(exemplars) == 0 {
+		return nil
+	}
+	indexes := make(map[string]*ExemplarIndex, len(exemplars))
+	for set, phrases := range exemplars {
+		indexes[set] = NewExemplarIndex(phrases)
+	}
+	return indexes
+}
+
+// IndexRules builds the exemplar indexes of the rules that don't have them yet
+func IndexRules(rules []ParsedRule) {
+	for i := range rules {
+		if rules[i].ExemplarIndexes == nil {
+			rules[i].ExemplarIndexes = IndexExemplars(rules[i].Exemplars)
+		}
+	}
+}
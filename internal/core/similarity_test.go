@@ -0,0 +1,61 @@
+package core
+
+import "testing"
+
+var complaints = []string{
+	"I have been waiting for two weeks and nobody answers",
+	"This is the worst service I have ever received",
+	"I want a refund right now",
+	"Your agents keep hanging up on me",
+}
+
+func TestExemplarIndexSimilarity(t *testing.T) {
+	ix := NewExemplarIndex(complaints)
+
+	if got := ix.Similarity("I want a refund right now"); got < 0.99 {
+		t.Errorf("Expected ~1.0 for an exact exemplar, got %.3f", got)
+	}
+
+	near := ix.Similarity("worst service ever, I want my refund")
+	far := ix.Similarity("thanks, have a nice day")
+	if near <= far {
+		t.Errorf("Expected complaint (%.3f) to score above small talk (%.3f)", near, far)
+	}
+
+	if got := NewExemplarIndex(nil).Similarity("anything"); got != 0 {
+		t.Errorf("Expected 0 for empty index, got %.3f", got)
+	}
+}
+
+func TestEngineSimilarityCondition(t *testing.T) {
+	engine := NewEngine()
+	cond := Condition{Type: ConditionSimilarity, Set: "complaints", Operator: ">", Threshold: 0.5}
+	rule := ParsedRule{
+		Rule: Rule{
+			Name:      "Complaint",
+			Action:    "supervisor",
+			Exemplars: map[string][]string{"complaints": complaints},
+		},
+		ParsedConditions: []Condition{cond},
+	}
+	rule.ExemplarIndexes = IndexExemplars(rule.Exemplars)
+
+	if err := rule.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	analysis := Analysis{Text: "this is the worst service I have received"}
+	if actions := engine.EvaluateAnalysis(analysis, []ParsedRule{rule}); len(actions) != 1 {
+		t.Errorf("Expected escalation, got %v", actions)
+	}
+
+	analysis = Analysis{Text: "what time do you open tomorrow"}
+	if actions := engine.EvaluateAnalysis(analysis, []ParsedRule{rule}); len(actions) != 0 {
+		t.Errorf("Expected no action, got %v", actions)
+	}
+
+	rule.ParsedConditions[0].Set = "unknown"
+	if err := rule.Validate(); err == nil {
+		t.Error("Expected validation error for missing exemplar set")
+	}
+}
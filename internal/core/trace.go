@@ -0,0 +1,75 @@
+package core
+
+// ConditionTrace explains how a single condition was evaluated
+type ConditionTrace struct {
+	Type      string  `json:"type"`
+	Subject   string  `json:"subject"` // word, intent label or exemplar set
+	Operator  string  `json:"operator"`
+	Observed  float64 `json:"observed"`  // count, confidence or similarity
+	Threshold float64 `json:"threshold"` // value the observation is compared against
+	Passed    bool    `json:"passed"`
+
+	// Tokens are the words or n-grams that contributed to the observation
+	Tokens []string `json:"tokens,omitempty"`
+	// Turns are the message ids that contributed to the observation
+	Turns []string `json:"turns,omitempty"`
+	// Detail is extra context, e.g. the predicted intent or the closest exemplar
+	Detail string `json:"detail,omitempty"`
+}
+
+// RuleTrace explains why a rule did or did not fire
+type RuleTrace struct {
+	RuleID     string           `json:"rule_id"`
+	RuleName   string           `json:"rule_name"`
+	Action     string           `json:"action"`
+	Mode       string           `json:"mode"`
+	Fired      bool             `json:"fired"` // for shadow rules: would have fired
+	Conditions []ConditionTrace `json:"conditions"`
+}
+
+// Score is the confidence of the rule's outcome in [0, 1]: the weakest intent
+// confidence or exemplar similarity among its conditions, or 1 when it only
+// has word conditions
+func (t RuleTrace) Score() float64 {
+	score := 1.0
+	for _, cond := range t.Conditions {
+		if cond.Type != ConditionIntent && cond.Type != ConditionSimilarity {
+			continue
+		}
+		if cond.Observed < score {
+			score = cond.Observed
+		}
+	}
+	return score
+}
+
+// DecisionTrace is the evaluation of every rule for one message
+type DecisionTrace struct {
+	ID        string      `json:"id"`
+	SessionID string      `json:"session_id"`
+	MessageID string      `json:"message_id"`
+	Escalated bool        `json:"escalated"`
+	Timestamp int64       `json:"timestamp"` // unix millis
+	Rules     []RuleTrace `json:"rules"`
+}
+
+// Decision is the outcome of Engine.Decide
+type Decision struct {
+	Matched []ParsedRule // active rules that fired
+	Shadow  []ParsedRule // shadow rules that would have fired
+	Trace   []RuleTrace
+}
+
+// ShadowFiring records a shadow rule that would have fired, with the context
+// it fired in; no action is dispatched for it
+type ShadowFiring struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"session_id"`
+	MessageID string    `json:"message_id"`
+	RuleID    string    `json:"rule_id"`
+	RuleName  string    `json:"rule_name"`
+	Action    string    `json:"action"` // the action that would have been dispatched
+	Text      string    `json:"text"`
+	Trace     RuleTrace `json:"trace"`
+	Timestamp int64     `json:"timestamp"` // unix millis
+}
@@ -0,0 +1,61 @@
+package core
+
+import "testing"
+
+func TestEngineDecideTrace(t *testing.T) {
+	engine := NewEngine()
+	rule := ParsedRule{
+		Rule: Rule{ID: "r1", Name: "Angry Refund", Action: "supervisor"},
+		ParsedConditions: []Condition{
+			{Word: "refund", Operator: ">=", Count: 1},
+			{Word: "angry", Operator: ">=", Count: 2},
+		},
+	}
+	analysis := Analysis{
+		WordCounts: map[string]int{"refund": 2, "angry": 1},
+		MessageID:  "msg-7",
+	}
+
+	decision := engine.Decide(analysis, []ParsedRule{rule})
+	if len(decision.Matched) != 0 {
+		t.Fatalf("Expected no match, got %v", decision.Matched)
+	}
+	if len(decision.Trace) != 1 || len(decision.Trace[0].Conditions) != 2 {
+		t.Fatalf("Expected a trace of both conditions, got %+v", decision.Trace)
+	}
+
+	refund := decision.Trace[0].Conditions[0]
+	if !refund.Passed || refund.Observed != 2 || refund.Threshold != 1 {
+		t.Errorf("Unexpected refund trace %+v", refund)
+	}
+	if len(refund.Turns) != 1 || refund.Turns[0] != "msg-7" || refund.Tokens[0] != "refund" {
+		t.Errorf("Expected refund to be attributed to msg-7, got %+v", refund)
+	}
+
+	angry := decision.Trace[0].Conditions[1]
+	if angry.Passed || angry.Observed != 1 || angry.Threshold != 2 {
+		t.Errorf("Unexpected angry trace %+v", angry)
+	}
+
+	analysis.WordCounts["angry"] = 2
+	decision = engine.Decide(analysis, []ParsedRule{rule})
+	if len(decision.Matched) != 1 || !decision.Trace[0].Fired {
+		t.Errorf("Expected rule to fire, got %+v", decision.Trace)
+	}
+}
+
+func TestRuleTraceScore(t *testing.T) {
+	keywords := RuleTrace{Conditions: []ConditionTrace{{Type: ConditionWord, Observed: 3}}}
+	if score := keywords.Score(); score != 1 {
+		t.Errorf("Expected keyword-only rules to score 1, got %v", score)
+	}
+
+	mixed := RuleTrace{Conditions: []ConditionTrace{
+		{Type: ConditionWord, Observed: 2},
+		{Type: ConditionIntent, Observed: 0.9},
+		{Type: ConditionSimilarity, Observed: 0.72},
+	}}
+	if score := mixed.Score(); score != 0.72 {
+		t.Errorf("Expected the weakest confidence 0.72, got %v", score)
+	}
+}
@@ -0,0 +1,46 @@
+package db
+
+import "fmt"
+
+// ClusterMember is a server replica registered through heartbeats
+type ClusterMember struct {
+	ID   string
+	Addr string
+}
+
+// Heartbeat registers a replica, or refreshes its registration, at timestamp at
+func (r *Repository) Heartbeat(member ClusterMember, at int64) error {
+	query := `INSERT INTO cluster_members (id, addr, heartbeat_at) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE addr = VALUES(addr), heartbeat_at = VALUES(heartbeat_at)`
+	if _, err := r.db.Exec(query, member.ID, member.Addr, at); err != nil {
+		return fmt.Errorf("failed to save heartbeat: %w", err)
+	}
+	return nil
+}
+
+// LiveMembers returns the replicas whose last heartbeat is at or after since
+func (r *Repository) LiveMembers(since int64) ([]ClusterMember, error) {
+	rows, err := r.db.Query(`SELECT id, addr FROM cluster_members WHERE heartbeat_at >= ? ORDER BY id`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cluster members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []ClusterMember
+	for rows.Next() {
+		var m ClusterMember
+		if err := rows.Scan(&m.ID, &m.Addr); err != nil {
+			return nil, fmt.Errorf("failed to scan cluster member: %w", err)
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// RemoveMember deregisters a replica that is leaving
+func (r *Repository) RemoveMember(id string) error {
+	if _, err := r.db.Exec(`DELETE FROM cluster_members WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to remove cluster member: %w", err)
+	}
+	return nil
+}
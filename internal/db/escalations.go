@@ -0,0 +1,248 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+)
+
+func (r *Repository) CreatePolicy(policy core.EscalationPolicy) (*core.EscalationPolicy, error) {
+	policy.ID = uuid.New().String()
+	levels, err := json.Marshal(policy.Levels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal levels: %w", err)
+	}
+	query := `INSERT INTO escalation_policies (id, name, levels) VALUES (?, ?, ?)`
+	if _, err := r.db.Exec(query, policy.ID, policy.Name, levels); err != nil {
+		return nil, fmt.Errorf("failed to insert policy: %w", err)
+	}
+	return &policy, nil
+}
+
+func (r *Repository) GetPolicy(id string) (*core.EscalationPolicy, error) {
+	policy := &core.EscalationPolicy{ID: id}
+	var levels []byte
+	err := r.db.QueryRow(`SELECT name, levels FROM escalation_policies WHERE id = ?`, id).Scan(&policy.Name, &levels)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query policy: %w", err)
+	}
+	if err := json.Unmarshal(levels, &policy.Levels); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal levels for policy %s: %w", id, err)
+	}
+	return policy, nil
+}
+
+func (r *Repository) GetAllPolicies() ([]core.EscalationPolicy, error) {
+	rows, err := r.db.Query(`SELECT id, name, levels FROM escalation_policies`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []core.EscalationPolicy
+	for rows.Next() {
+		var policy core.EscalationPolicy
+		var levels []byte
+		if err := rows.Scan(&policy.ID, &policy.Name, &levels); err != nil {
+			return nil, fmt.Errorf("failed to scan policy: %w", err)
+		}
+		if err := json.Unmarshal(levels, &policy.Levels); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal levels for policy %s: %w", policy.ID, err)
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+const escalationColumns = `id, session_id, rule_id, policy_id, level, status, created_at, updated_at, next_at, acknowledged_by, trace_id, client_id`
+
+func scanEscalation(row interface{ Scan(...any) error }) (*core.Escalation, error) {
+	var esc core.Escalation
+	var policyID, ackBy, traceID, clientID sql.NullString
+	err := row.Scan(&esc.ID, &esc.SessionID, &esc.RuleID, &policyID, &esc.Level, &esc.Status,
+		&esc.CreatedAt, &esc.UpdatedAt, &esc.NextAt, &ackBy, &traceID, &clientID)
+	if err != nil {
+		return nil, err
+	}
+	esc.PolicyID = policyID.String
+	esc.AcknowledgedBy = ackBy.String
+	esc.TraceID = traceID.String
+	esc.ClientID = clientID.String
+	return &esc, nil
+}
+
+func (r *Repository) CreateEscalation(esc core.Escalation) (*core.Escalation, error) {
+	esc.ID = uuid.New().String()
+	now := time.Now().UnixMilli()
+	esc.CreatedAt, esc.UpdatedAt = now, now
+	query := `INSERT INTO escalations (` + escalationColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := r.db.Exec(query, esc.ID, esc.SessionID, esc.RuleID, nullString(esc.PolicyID), esc.Level, esc.Status,
+		esc.CreatedAt, esc.UpdatedAt, esc.NextAt, nullString(esc.AcknowledgedBy), nullString(esc.TraceID), nullString(esc.ClientID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert escalation: %w", err)
+	}
+	return &esc, nil
+}
+
+func (r *Repository) GetEscalation(id string) (*core.Escalation, error) {
+	esc, err := scanEscalation(r.db.QueryRow(`SELECT `+escalationColumns+` FROM escalations WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query escalation: %w", err)
+	}
+	return esc, nil
+}
+
+// GetActiveEscalation returns the unacknowledged ladder of a rule for a session, if any
+func (r *Repository) GetActiveEscalation(sessionID, ruleID string) (*core.Escalation, error) {
+	query := `SELECT ` + escalationColumns + ` FROM escalations WHERE session_id = ? AND rule_id = ? AND status IN (?, ?) LIMIT 1`
+	esc, err := scanEscalation(r.db.QueryRow(query, sessionID, ruleID, core.EscalationOpen, core.EscalationExhausted))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query escalation: %w", err)
+	}
+	return esc, nil
+}
+
+// GetLatestEscalation returns the last ladder a rule started for a session,
+// if any, whatever its status
+func (r *Repository) GetLatestEscalation(sessionID, ruleID string) (*core.Escalation, error) {
+	query := `SELECT ` + escalationColumns + ` FROM escalations WHERE session_id = ? AND rule_id = ? ORDER BY created_at DESC LIMIT 1`
+	esc, err := scanEscalation(r.db.QueryRow(query, sessionID, ruleID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query escalation: %w", err)
+	}
+	return esc, nil
+}
+
+// GetDueEscalations returns open escalations whose next level is due at or before now (unix millis)
+func (r *Repository) GetDueEscalations(now int64) ([]core.Escalation, error) {
+	query := `SELECT ` + escalationColumns + ` FROM escalations WHERE status = ? AND next_at > 0 AND next_at <= ? ORDER BY next_at`
+	rows, err := r.db.Query(query, core.EscalationOpen, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query due escalations: %w", err)
+	}
+	defer rows.Close()
+
+	var escalations []core.Escalation
+	for rows.Next() {
+		esc, err := scanEscalation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan escalation: %w", err)
+		}
+		escalations = append(escalations, *esc)
+	}
+	return escalations, rows.Err()
+}
+
+// AdvanceEscalation records that a level was dispatched. It only updates
+// escalations that are still open at fromLevel, so a concurrent acknowledgement
+// wins; the returned bool reports whether the row was updated.
+func (r *Repository) AdvanceEscalation(id string, fromLevel, toLevel int, nextAt int64, status core.EscalationStatus) (bool, error) {
+	query := `UPDATE escalations SET level = ?, next_at = ?, status = ?, updated_at = ? WHERE id = ? AND level = ? AND status = ?`
+	res, err := r.db.Exec(query, toLevel, nextAt, status, time.Now().UnixMilli(), id, fromLevel, core.EscalationOpen)
+	if err != nil {
+		return false, fmt.Errorf("failed to advance escalation: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to advance escalation: %w", err)
+	}
+	return n > 0, nil
+}
+
+// AcknowledgeEscalation stops an open or exhausted ladder. It returns ErrNotFound for
+// unknown escalations and core.ErrInvalidTransition if it was already acknowledged.
+func (r *Repository) AcknowledgeEscalation(id, by string) (*core.Escalation, error) {
+	query := `UPDATE escalations SET status = ?, next_at = 0, acknowledged_by = ?, updated_at = ? WHERE id = ? AND status IN (?, ?)`
+	res, err := r.db.Exec(query, core.EscalationAcknowledged, by, time.Now().UnixMilli(), id, core.EscalationOpen, core.EscalationExhausted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acknowledge escalation: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acknowledge escalation: %w", err)
+	}
+
+	esc, err := r.GetEscalation(id)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return esc, fmt.Errorf("%w: escalation is %s", core.ErrInvalidTransition, esc.Status)
+	}
+	return esc, nil
+}
+
+// GetEscalationsBetween returns escalations created with from <= created_at < to (unix millis)
+func (r *Repository) GetEscalationsBetween(from, to int64) ([]core.Escalation, error) {
+	query := `SELECT ` + escalationColumns + ` FROM escalations WHERE created_at >= ? AND created_at < ? ORDER BY created_at`
+	rows, err := r.db.Query(query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query escalations: %w", err)
+	}
+	defer rows.Close()
+
+	var escalations []core.Escalation
+	for rows.Next() {
+		esc, err := scanEscalation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan escalation: %w", err)
+		}
+		escalations = append(escalations, *esc)
+	}
+	return escalations, rows.Err()
+}
+
+// SaveEscalationEvent stores an event and returns it with its assigned id
+func (r *Repository) SaveEscalationEvent(event core.EscalationEvent) (*core.EscalationEvent, error) {
+	query := `INSERT INTO escalation_events (session_id, client_id, message_id, escalation_id, rule_id, rule_name, action, level, score, trace_id, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := r.db.Exec(query, event.SessionID, nullString(event.ClientID), nullString(event.MessageID), event.EscalationID,
+		event.RuleID, event.RuleName, event.Action, event.Level, event.Score, nullString(event.TraceID), event.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert escalation event: %w", err)
+	}
+	if event.ID, err = res.LastInsertId(); err != nil {
+		return nil, fmt.Errorf("failed to read escalation event id: %w", err)
+	}
+	return &event, nil
+}
+
+// GetEscalationEventsAfter returns up to limit events with an id greater than
+// afterID, oldest first
+func (r *Repository) GetEscalationEventsAfter(afterID int64, limit int) ([]core.EscalationEvent, error) {
+	query := `SELECT id, session_id, client_id, message_id, escalation_id, rule_id, rule_name, action, level, score, trace_id, timestamp FROM escalation_events WHERE id > ? ORDER BY id LIMIT ?`
+	rows, err := r.db.Query(query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query escalation events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []core.EscalationEvent
+	for rows.Next() {
+		var ev core.EscalationEvent
+		var clientID, messageID, ruleName, traceID sql.NullString
+		if err := rows.Scan(&ev.ID, &ev.SessionID, &clientID, &messageID, &ev.EscalationID, &ev.RuleID, &ruleName,
+			&ev.Action, &ev.Level, &ev.Score, &traceID, &ev.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan escalation event: %w", err)
+		}
+		ev.ClientID, ev.MessageID, ev.RuleName, ev.TraceID = clientID.String, messageID.String, ruleName.String, traceID.String
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
@@ -1,17 +1,22 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
 )
 
+// mysqlDuplicateEntry is the MySQL error number of unique key violations
+const mysqlDuplicateEntry = 1062
+
 type Repository struct {
 	db *sql.DB
 }
@@ -44,6 +49,19 @@ func NewRepository() (*Repository, error) {
 	return repo, nil
 }
 
+// Close waits for the queries in progress and closes the connections
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// Ping checks that the database is reachable
+func (r *Repository) Ping(ctx context.Context) error {
+	if err := r.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	return nil
+}
+
 func (r *Repository) initSchema() error {
 	log.Println("Initializing schema...")
 	queryRules := `
@@ -57,6 +75,24 @@ func (r *Repository) initSchema() error {
 	if _, err := r.db.Exec(queryRules); err != nil {
 		return fmt.Errorf("failed to create rules table: %w", err)
 	}
+	if err := r.ensureColumn("rules", "exemplars", "JSON NULL"); err != nil {
+		return err
+	}
+	if err := r.ensureColumn("rules", "policy_id", "VARCHAR(36) NULL"); err != nil {
+		return err
+	}
+	if err := r.ensureColumn("rules", "tests", "JSON NULL"); err != nil {
+		return err
+	}
+	if err := r.ensureColumn("rules", "mode", "VARCHAR(16) NOT NULL DEFAULT 'active'"); err != nil {
+		return err
+	}
+	if err := r.ensureColumn("rules", "rule_trigger", "VARCHAR(16) NOT NULL DEFAULT 'message'"); err != nil {
+		return err
+	}
+	if err := r.ensureColumn("rules", "fire_on_interim", "BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return err
+	}
 
 	queryMessages := `
 	CREATE TABLE IF NOT EXISTS messages (
@@ -69,16 +105,251 @@ func (r *Repository) initSchema() error {
 	if _, err := r.db.Exec(queryMessages); err != nil {
 		return fmt.Errorf("failed to create messages table: %w", err)
 	}
+	// Messages are unique per (client, conversation, message id) so that
+	// retried and redelivered chunks are stored and processed once
+	if err := r.ensureColumn("messages", "client_id", "VARCHAR(255) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := r.ensureColumn("messages", "message_id", "VARCHAR(255) NULL"); err != nil {
+		return err
+	}
+	if err := r.ensureUniqueIndex("messages", "uq_messages_message", "client_id, conversation_id, message_id"); err != nil {
+		return err
+	}
+
+	querySessions := `
+	CREATE TABLE IF NOT EXISTS session_states (
+		session_id VARCHAR(255) PRIMARY KEY,
+		status VARCHAR(32) NOT NULL,
+		created_at BIGINT NOT NULL,
+		updated_at BIGINT NOT NULL
+	);
+	`
+	if _, err := r.db.Exec(querySessions); err != nil {
+		return fmt.Errorf("failed to create session_states table: %w", err)
+	}
+
+	queryTransitions := `
+	CREATE TABLE IF NOT EXISTS session_transitions (
+		id VARCHAR(36) PRIMARY KEY,
+		session_id VARCHAR(255) NOT NULL,
+		from_status VARCHAR(32) NOT NULL,
+		to_status VARCHAR(32) NOT NULL,
+		reason TEXT,
+		timestamp BIGINT NOT NULL,
+		INDEX idx_session_transitions_session (session_id, timestamp)
+	);
+	`
+	if _, err := r.db.Exec(queryTransitions); err != nil {
+		return fmt.Errorf("failed to create session_transitions table: %w", err)
+	}
+
+	queryPolicies := `
+	CREATE TABLE IF NOT EXISTS escalation_policies (
+		id VARCHAR(36) PRIMARY KEY,
+		name TEXT NOT NULL,
+		levels JSON NOT NULL
+	);
+	`
+	if _, err := r.db.Exec(queryPolicies); err != nil {
+		return fmt.Errorf("failed to create escalation_policies table: %w", err)
+	}
+
+	queryEscalations := `
+	CREATE TABLE IF NOT EXISTS escalations (
+		id VARCHAR(36) PRIMARY KEY,
+		session_id VARCHAR(255) NOT NULL,
+		rule_id VARCHAR(36) NOT NULL,
+		policy_id VARCHAR(36),
+		level INT NOT NULL,
+		status VARCHAR(32) NOT NULL,
+		created_at BIGINT NOT NULL,
+		updated_at BIGINT NOT NULL,
+		next_at BIGINT NOT NULL,
+		acknowledged_by VARCHAR(255),
+		INDEX idx_escalations_due (status, next_at),
+		INDEX idx_escalations_session (session_id, rule_id)
+	);
+	`
+	if _, err := r.db.Exec(queryEscalations); err != nil {
+		return fmt.Errorf("failed to create escalations table: %w", err)
+	}
+	if err := r.ensureColumn("escalations", "trace_id", "VARCHAR(36) NULL"); err != nil {
+		return err
+	}
+	if err := r.ensureColumn("escalations", "client_id", "VARCHAR(255) NULL"); err != nil {
+		return err
+	}
+
+	queryEvents := `
+	CREATE TABLE IF NOT EXISTS escalation_events (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		session_id VARCHAR(255) NOT NULL,
+		client_id VARCHAR(255) NULL,
+		message_id VARCHAR(255) NULL,
+		escalation_id VARCHAR(36) NOT NULL,
+		rule_id VARCHAR(36) NOT NULL,
+		rule_name TEXT,
+		action TEXT NOT NULL,
+		level INT NOT NULL,
+		score DOUBLE NOT NULL,
+		trace_id VARCHAR(36) NULL,
+		timestamp BIGINT NOT NULL
+	);
+	`
+	if _, err := r.db.Exec(queryEvents); err != nil {
+		return fmt.Errorf("failed to create escalation_events table: %w", err)
+	}
+
+	queryTraces := `
+	CREATE TABLE IF NOT EXISTS decision_traces (
+		id VARCHAR(36) PRIMARY KEY,
+		session_id VARCHAR(255) NOT NULL,
+		message_id VARCHAR(255) NOT NULL,
+		escalated BOOLEAN NOT NULL,
+		trace JSON NOT NULL,
+		timestamp BIGINT NOT NULL,
+		INDEX idx_decision_traces_message (session_id, message_id)
+	);
+	`
+	if _, err := r.db.Exec(queryTraces); err != nil {
+		return fmt.Errorf("failed to create decision_traces table: %w", err)
+	}
+
+	queryShadow := `
+	CREATE TABLE IF NOT EXISTS shadow_firings (
+		id VARCHAR(36) PRIMARY KEY,
+		session_id VARCHAR(255) NOT NULL,
+		message_id VARCHAR(255) NOT NULL,
+		rule_id VARCHAR(36) NOT NULL,
+		rule_name TEXT NOT NULL,
+		action TEXT NOT NULL,
+		text TEXT,
+		trace JSON NOT NULL,
+		timestamp BIGINT NOT NULL,
+		INDEX idx_shadow_firings_time (timestamp)
+	);
+	`
+	if _, err := r.db.Exec(queryShadow); err != nil {
+		return fmt.Errorf("failed to create shadow_firings table: %w", err)
+	}
+
+	querySummaries := `
+	CREATE TABLE IF NOT EXISTS session_summaries (
+		session_id VARCHAR(255) PRIMARY KEY,
+		started_at BIGINT NOT NULL,
+		ended_at BIGINT NOT NULL,
+		turns INT NOT NULL,
+		turn_counts JSON NOT NULL,
+		top_words JSON NOT NULL,
+		end_reason VARCHAR(32) NOT NULL,
+		final_status VARCHAR(32) NULL,
+		finalized_at BIGINT NOT NULL
+	);
+	`
+	if _, err := r.db.Exec(querySummaries); err != nil {
+		return fmt.Errorf("failed to create session_summaries table: %w", err)
+	}
+	if err := r.ensureColumn("session_summaries", "degraded", "TEXT NULL"); err != nil {
+		return err
+	}
+
+	queryMembers := `
+	CREATE TABLE IF NOT EXISTS cluster_members (
+		id VARCHAR(64) PRIMARY KEY,
+		addr VARCHAR(255) NOT NULL,
+		heartbeat_at BIGINT NOT NULL
+	);
+	`
+	if _, err := r.db.Exec(queryMembers); err != nil {
+		return fmt.Errorf("failed to create cluster_members table: %w", err)
+	}
+
+	querySnapshots := `
+	CREATE TABLE IF NOT EXISTS session_snapshots (
+		node_id VARCHAR(64) PRIMARY KEY,
+		data LONGBLOB NOT NULL,
+		taken_at BIGINT NOT NULL
+	);
+	`
+	if _, err := r.db.Exec(querySnapshots); err != nil {
+		return fmt.Errorf("failed to create session_snapshots table: %w", err)
+	}
 
 	return nil
 }
 
-func (r *Repository) SaveMessage(conversationID, content string, timestamp int64) error {
+// ensureUniqueIndex adds a unique index to an existing table, for databases
+// created before the index was introduced
+func (r *Repository) ensureUniqueIndex(table, index, columns string) error {
+	var count int
+	query := `SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?`
+	if err := r.db.QueryRow(query, table, index).Scan(&count); err != nil {
+		return fmt.Errorf("failed to inspect index %s.%s: %w", table, index, err)
+	}
+	if count > 0 {
+		return nil
+	}
+	if _, err := r.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD UNIQUE INDEX %s (%s)", table, index, columns)); err != nil {
+		return fmt.Errorf("failed to add index %s.%s: %w", table, index, err)
+	}
+	return nil
+}
+
+// ensureColumn adds a column to an existing table, for databases created
+// before the column was introduced
+func (r *Repository) ensureColumn(table, column, definition string) error {
+	var count int
+	query := `SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`
+	if err := r.db.QueryRow(query, table, column).Scan(&count); err != nil {
+		return fmt.Errorf("failed to inspect %s.%s: %w", table, column, err)
+	}
+	if count > 0 {
+		return nil
+	}
+	if _, err := r.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// ErrDuplicate is returned when a message with the same client, conversation
+// and message id was already stored
+var ErrDuplicate = errors.New("duplicate message")
+
+// SaveMessage stores a message and returns its generated id. Messages with a
+// messageID are stored once; a repeat returns ErrDuplicate.
+func (r *Repository) SaveMessage(clientID, conversationID, messageID, content string, timestamp int64) (string, error) {
 	id := uuid.New().String()
-	query := `INSERT INTO messages (id, conversation_id, content, timestamp) VALUES (?, ?, ?, ?)`
-	_, err := r.db.Exec(query, id, conversationID, content, timestamp)
+	query := `INSERT INTO messages (id, client_id, conversation_id, message_id, content, timestamp) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := r.db.Exec(query, id, clientID, conversationID, nullString(messageID), content, timestamp)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return "", ErrDuplicate
+	}
 	if err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+		return "", fmt.Errorf("failed to save message: %w", err)
+	}
+	return id, nil
+}
+
+// MessageExists reports whether a message with the same client, conversation
+// and message id was already stored
+func (r *Repository) MessageExists(clientID, conversationID, messageID string) (bool, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM messages WHERE client_id = ? AND conversation_id = ? AND message_id = ?`
+	if err := r.db.QueryRow(query, clientID, conversationID, messageID).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to look up message: %w", err)
+	}
+	return count > 0, nil
+}
+
+// DeleteMessage removes a stored message, e.g. when processing it failed and
+// a retry must not be rejected as a duplicate
+func (r *Repository) DeleteMessage(clientID, conversationID, messageID string) error {
+	query := `DELETE FROM messages WHERE client_id = ? AND conversation_id = ? AND message_id = ?`
+	if _, err := r.db.Exec(query, clientID, conversationID, messageID); err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
 	}
 	return nil
 }
@@ -110,29 +381,113 @@ func (r *Repository) GetWordCounts(conversationID string) (map[string]int, error
 	return wordCounts, nil
 }
 
-func (r *Repository) CreateRule(name string, conditions []core.Condition, action string) (*core.Rule, error) {
-	id := uuid.New().String()
-	condBytes, err := json.Marshal(conditions)
+// CreateRule stores a new rule built from rule.ParsedConditions; the ID is generated
+func (r *Repository) CreateRule(rule core.ParsedRule) (*core.Rule, error) {
+	rule.ID = uuid.New().String()
+	condBytes, cols, err := ruleColumnValues(rule)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal conditions: %w", err)
+		return nil, err
 	}
 
-	query := `INSERT INTO rules (id, name, conditions, action) VALUES (?, ?, ?, ?)`
-	_, err = r.db.Exec(query, id, name, condBytes, action)
+	query := `INSERT INTO rules (id, name, conditions, action, exemplars, policy_id, tests, mode, rule_trigger, fire_on_interim) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = r.db.Exec(query, append([]any{rule.ID}, cols...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert rule: %w", err)
 	}
 
-	return &core.Rule{
-		ID:         id,
-		Name:       name,
-		Conditions: json.RawMessage(condBytes),
-		Action:     action,
+	rule.Conditions = json.RawMessage(condBytes)
+	return &rule.Rule, nil
+}
+
+// UpdateRule replaces every field of an existing rule. It returns ErrNotFound
+// if the rule does not exist.
+func (r *Repository) UpdateRule(rule core.ParsedRule) (*core.Rule, error) {
+	condBytes, cols, err := ruleColumnValues(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE rules SET name = ?, conditions = ?, action = ?, exemplars = ?, policy_id = ?, tests = ?, mode = ?, rule_trigger = ?, fire_on_interim = ? WHERE id = ?`
+	if _, err := r.db.Exec(query, append(cols, rule.ID)...); err != nil {
+		return nil, fmt.Errorf("failed to update rule: %w", err)
+	}
+	// RowsAffected is 0 for unchanged rows in MySQL, so check existence separately
+	if _, err := r.GetRule(rule.ID); err != nil {
+		return nil, err
+	}
+
+	rule.Conditions = json.RawMessage(condBytes)
+	return &rule.Rule, nil
+}
+
+// ruleColumnValues returns the marshaled conditions and the values of the
+// name, conditions, action, exemplars, policy_id, tests, mode, rule_trigger
+// and fire_on_interim columns
+func ruleColumnValues(rule core.ParsedRule) ([]byte, []any, error) {
+	condBytes, err := json.Marshal(rule.ParsedConditions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal conditions: %w", err)
+	}
+	exemplarBytes, err := marshalNullable(rule.Exemplars, len(rule.Exemplars) == 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal exemplars: %w", err)
+	}
+	testBytes, err := marshalNullable(rule.Tests, len(rule.Tests) == 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal tests: %w", err)
+	}
+	values := []any{rule.Name, condBytes, rule.Action, exemplarBytes, nullString(rule.PolicyID), testBytes, rule.RuleMode(), rule.RuleTrigger(), rule.FireOnInterim}
+	return condBytes, values, nil
+}
+
+const ruleColumns = `id, name, conditions, action, exemplars, policy_id, tests, mode, rule_trigger, fire_on_interim`
+
+func scanRule(row interface{ Scan(...any) error }) (*core.ParsedRule, error) {
+	var rule core.Rule
+	var condBytes, exemplarBytes, testBytes []byte
+	var policyID sql.NullString
+	if err := row.Scan(&rule.ID, &rule.Name, &condBytes, &rule.Action, &exemplarBytes, &policyID, &testBytes, &rule.Mode, &rule.Trigger, &rule.FireOnInterim); err != nil {
+		return nil, err
+	}
+	rule.PolicyID = policyID.String
+	rule.Conditions = json.RawMessage(condBytes)
+
+	var conditions []core.Condition
+	if err := json.Unmarshal(condBytes, &conditions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal conditions for rule %s: %w", rule.ID, err)
+	}
+	if len(exemplarBytes) > 0 {
+		if err := json.Unmarshal(exemplarBytes, &rule.Exemplars); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal exemplars for rule %s: %w", rule.ID, err)
+		}
+	}
+	if len(testBytes) > 0 {
+		if err := json.Unmarshal(testBytes, &rule.Tests); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal tests for rule %s: %w", rule.ID, err)
+		}
+	}
+
+	// Exemplar indexes are built by the callers that evaluate the rules, see
+	// core.RuleCache
+	return &core.ParsedRule{
+		Rule:             rule,
+		ParsedConditions: conditions,
 	}, nil
 }
 
+func (r *Repository) GetRule(id string) (*core.ParsedRule, error) {
+	rule, err := scanRule(r.db.QueryRow(`SELECT `+ruleColumns+` FROM rules WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rule: %w", err)
+	}
+	return rule, nil
+}
+
 func (r *Repository) GetAllRules() ([]core.ParsedRule, error) {
-	query := `SELECT id, name, conditions, action FROM rules`
+	query := `SELECT ` + ruleColumns + ` FROM rules`
 	rows, err := r.db.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query rules: %w", err)
@@ -141,24 +496,25 @@ func (r *Repository) GetAllRules() ([]core.ParsedRule, error) {
 
 	var rules []core.ParsedRule
 	for rows.Next() {
-		var rule core.Rule
-		var condBytes []byte
-		if err := rows.Scan(&rule.ID, &rule.Name, &condBytes, &rule.Action); err != nil {
+		rule, err := scanRule(rows)
+		if err != nil {
 			log.Printf("failed to scan rule: %v", err)
 			continue
 		}
-		rule.Conditions = json.RawMessage(condBytes)
+		rules = append(rules, *rule)
+	}
+	return rules, nil
+}
 
-		var conditions []core.Condition
-		if err := json.Unmarshal(condBytes, &conditions); err != nil {
-			log.Printf("failed to unmarshal conditions for rule %s: %v", rule.ID, err)
-			continue
-		}
+// nullString maps an empty string to SQL NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
 
-		rules = append(rules, core.ParsedRule{
-			Rule:             rule,
-			ParsedConditions: conditions,
-		})
+// marshalNullable returns JSON for v, or nil (SQL NULL) when empty is true
+func marshalNullable(v any, empty bool) ([]byte, error) {
+	if empty {
+		return nil, nil
 	}
-	return rules, nil
+	return json.Marshal(v)
 }
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+)
+
+// ErrNotFound is returned when a requested record does not exist
+var ErrNotFound = errors.New("not found")
+
+// GetSessionState returns the session status with its transition history
+func (r *Repository) GetSessionState(sessionID string) (*core.SessionState, error) {
+	state := &core.SessionState{SessionID: sessionID}
+	query := `SELECT status, created_at, updated_at FROM session_states WHERE session_id = ?`
+	err := r.db.QueryRow(query, sessionID).Scan(&state.Status, &state.CreatedAt, &state.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session state: %w", err)
+	}
+
+	rows, err := r.db.Query(
+		`SELECT from_status, to_status, reason, timestamp FROM session_transitions WHERE session_id = ? ORDER BY timestamp`,
+		sessionID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session transitions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t core.SessionTransition
+		var reason sql.NullString
+		if err := rows.Scan(&t.From, &t.To, &reason, &t.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan session transition: %w", err)
+		}
+		t.Reason = reason.String
+		state.Transitions = append(state.Transitions, t)
+	}
+	return state, rows.Err()
+}
+
+// EnsureSession creates the session in the active status if it is unknown
+func (r *Repository) EnsureSession(sessionID string) error {
+	now := time.Now().UnixMilli()
+	query := `INSERT IGNORE INTO session_states (session_id, status, created_at, updated_at) VALUES (?, ?, ?, ?)`
+	if _, err := r.db.Exec(query, sessionID, core.SessionActive, now, now); err != nil {
+		return fmt.Errorf("failed to create session state: %w", err)
+	}
+	return nil
+}
+
+// TransitionSession moves a session to a new status, recording the transition.
+// Unknown sessions start as active. It returns core.ErrInvalidTransition if the
+// change is not allowed from the current status.
+func (r *Repository) TransitionSession(sessionID string, to core.SessionStatus, reason string) (*core.SessionState, error) {
+	if err := r.EnsureSession(sessionID); err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var from core.SessionStatus
+	if err := tx.QueryRow(`SELECT status FROM session_states WHERE session_id = ? FOR UPDATE`, sessionID).Scan(&from); err != nil {
+		return nil, fmt.Errorf("failed to lock session state: %w", err)
+	}
+	if err := core.CheckTransition(from, to); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	if _, err := tx.Exec(`UPDATE session_states SET status = ?, updated_at = ? WHERE session_id = ?`, to, now, sessionID); err != nil {
+		return nil, fmt.Errorf("failed to update session state: %w", err)
+	}
+	_, err = tx.Exec(
+		`INSERT INTO session_transitions (id, session_id, from_status, to_status, reason, timestamp) VALUES (?, ?, ?, ?, ?, ?)`,
+		uuid.New().String(), sessionID, from, to, reason, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record session transition: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit session transition: %w", err)
+	}
+
+	return r.GetSessionState(sessionID)
+}
+
+// SaveSessionSummary stores the summary of an ended session. A session that
+// ends again (e.g. resumed after an idle timeout) replaces its summary.
+func (r *Repository) SaveSessionSummary(summary core.SessionSummary) error {
+	turnCounts, err := json.Marshal(summary.TurnCounts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal turn counts: %w", err)
+	}
+	topWords, err := json.Marshal(summary.TopWords)
+	if err != nil {
+		return fmt.Errorf("failed to marshal top words: %w", err)
+	}
+	query := `REPLACE INTO session_summaries (session_id, started_at, ended_at, turns, turn_counts, top_words, end_reason, final_status, finalized_at, degraded) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = r.db.Exec(query, summary.SessionID, summary.StartedAt, summary.EndedAt, summary.Turns, turnCounts, topWords,
+		summary.EndReason, nullString(string(summary.FinalStatus)), summary.FinalizedAt, nullString(summary.Degraded))
+	if err != nil {
+		return fmt.Errorf("failed to save session summary: %w", err)
+	}
+	return nil
+}
+
+// GetSessionSummary returns the summary of an ended session
+func (r *Repository) GetSessionSummary(sessionID string) (*core.SessionSummary, error) {
+	summary := &core.SessionSummary{SessionID: sessionID}
+	var turnCounts, topWords []byte
+	var status, degraded sql.NullString
+	query := `SELECT started_at, ended_at, turns, turn_counts, top_words, end_reason, final_status, finalized_at, degraded FROM session_summaries WHERE session_id = ?`
+	err := r.db.QueryRow(query, sessionID).Scan(&summary.StartedAt, &summary.EndedAt, &summary.Turns, &turnCounts, &topWords,
+		&summary.EndReason, &status, &summary.FinalizedAt, &degraded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session summary: %w", err)
+	}
+	summary.FinalStatus = core.SessionStatus(status.String)
+	summary.Degraded = degraded.String
+	if err := json.Unmarshal(turnCounts, &summary.TurnCounts); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal turn counts: %w", err)
+	}
+	if err := json.Unmarshal(topWords, &summary.TopWords); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal top words: %w", err)
+	}
+	return summary, nil
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SaveSnapshot replaces the session snapshot of a server, taken at timestamp at
+func (r *Repository) SaveSnapshot(nodeID string, data []byte, at int64) error {
+	query := `INSERT INTO session_snapshots (node_id, data, taken_at) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE data = VALUES(data), taken_at = VALUES(taken_at)`
+	if _, err := r.db.Exec(query, nodeID, data, at); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot returns the last session snapshot of a server, or ErrNotFound
+func (r *Repository) LoadSnapshot(nodeID string) ([]byte, error) {
+	var data []byte
+	err := r.db.QueryRow(`SELECT data FROM session_snapshots WHERE node_id = ?`, nodeID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+)
+
+// SaveDecisionTrace stores the rule evaluation of a message and returns it with its generated id
+func (r *Repository) SaveDecisionTrace(trace core.DecisionTrace) (*core.DecisionTrace, error) {
+	trace.ID = uuid.New().String()
+	rules, err := json.Marshal(trace.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal trace: %w", err)
+	}
+	query := `INSERT INTO decision_traces (id, session_id, message_id, escalated, trace, timestamp) VALUES (?, ?, ?, ?, ?, ?)`
+	if _, err := r.db.Exec(query, trace.ID, trace.SessionID, trace.MessageID, trace.Escalated, rules, trace.Timestamp); err != nil {
+		return nil, fmt.Errorf("failed to insert trace: %w", err)
+	}
+	return &trace, nil
+}
+
+// GetDecisionTrace returns the trace of a message, or of the latest message of
+// the session when messageID is empty
+func (r *Repository) GetDecisionTrace(sessionID, messageID string) (*core.DecisionTrace, error) {
+	query := `SELECT id, session_id, message_id, escalated, trace, timestamp FROM decision_traces WHERE session_id = ?`
+	args := []any{sessionID}
+	if messageID != "" {
+		query += ` AND message_id = ?`
+		args = append(args, messageID)
+	}
+	query += ` ORDER BY timestamp DESC LIMIT 1`
+
+	var trace core.DecisionTrace
+	var rules []byte
+	err := r.db.QueryRow(query, args...).Scan(&trace.ID, &trace.SessionID, &trace.MessageID, &trace.Escalated, &rules, &trace.Timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trace: %w", err)
+	}
+	if err := json.Unmarshal(rules, &trace.Rules); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal trace %s: %w", trace.ID, err)
+	}
+	return &trace, nil
+}
+
+func (r *Repository) SaveShadowFiring(firing core.ShadowFiring) error {
+	firing.ID = uuid.New().String()
+	trace, err := json.Marshal(firing.Trace)
+	if err != nil {
+		return fmt.Errorf("failed to marshal shadow trace: %w", err)
+	}
+	query := `INSERT INTO shadow_firings (id, session_id, message_id, rule_id, rule_name, action, text, trace, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = r.db.Exec(query, firing.ID, firing.SessionID, firing.MessageID, firing.RuleID, firing.RuleName,
+		firing.Action, firing.Text, trace, firing.Timestamp)
+	if err != nil {
+		return fmt.Errorf("failed to insert shadow firing: %w", err)
+	}
+	return nil
+}
+
+// GetShadowFirings returns shadow firings with from <= timestamp < to (unix millis)
+func (r *Repository) GetShadowFirings(from, to int64) ([]core.ShadowFiring, error) {
+	query := `SELECT id, session_id, message_id, rule_id, rule_name, action, text, trace, timestamp FROM shadow_firings WHERE timestamp >= ? AND timestamp < ? ORDER BY timestamp`
+	rows, err := r.db.Query(query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shadow firings: %w", err)
+	}
+	defer rows.Close()
+
+	var firings []core.ShadowFiring
+	for rows.Next() {
+		var f core.ShadowFiring
+		var text sql.NullString
+		var trace []byte
+		if err := rows.Scan(&f.ID, &f.SessionID, &f.MessageID, &f.RuleID, &f.RuleName, &f.Action, &text, &trace, &f.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan shadow firing: %w", err)
+		}
+		f.Text = text.String
+		if err := json.Unmarshal(trace, &f.Trace); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal shadow trace %s: %w", f.ID, err)
+		}
+		firings = append(firings, f)
+	}
+	return firings, rows.Err()
+}
@@ -2,32 +2,423 @@ package engine
 
 //nice
 import (
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/escalation"
 	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
 )
 
+// Engine is the gRPC processing path. It keeps cumulative per-session
+// analysis state, evaluates the rule set on every chunk and applies the
+// decision through the same pipeline as the Kafka consumer.
+//
+// Submit, ProcessChunk and EndSession must be called from the worker that
+// owns the session (see workers.WorkerPool), so that a session's state is only
+// ever touched by one goroutine; the mutex only guards the session map itself.
 type Engine struct {
 	analyzer *core.Analyzer
+	rules    *core.Engine
+	repo     *db.Repository
+	cache    *core.RuleCache
+	pipeline *escalation.Pipeline
+	endHooks []SessionEndHook
+	reorder  ReorderConfig
+	dispatch Dispatcher
+	dedup    *core.DedupWindow
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	degraded map[string]string // live session -> why it is degraded
+	elevated map[string]bool   // live sessions at risk or escalated
+
+	statsMu      sync.Mutex
+	reorderStats core.ReorderStats
+}
+
+// session is the in-memory state of a live session
+type session struct {
+	agg      *core.SessionAggregate
+	seq      core.SequenceTracker
+	interim  *core.InterimTracker
+	lastSeen time.Time // wall clock of the last chunk, for the idle TTL
+
+	reorder    *core.ReorderBuffer
+	waiting    map[*conversationv1.ConversationChunk]func(Result) // callbacks of submitted chunks
+	timerArmed bool                                               // a flush of held chunks is scheduled
+
+	// degraded is set once processing a chunk of the session failed midway;
+	// its counts may be off, see Engine.Fail
+	degraded string
+	// claimed is the chunk being evaluated, recorded as processed by
+	// duplicate; it is forgotten again if evaluating it panics
+	claimed *conversationv1.ConversationChunk
+	// resumed is set once the session was reopened if it had been closed,
+	// see Engine.reopen
+	resumed bool
+}
+
+// NewEngine creates the engine. repo may be nil when the database is
+// unavailable, in which case chunks are analyzed but no rules are evaluated.
+func NewEngine(repo *db.Repository, scheduler *escalation.Scheduler) *Engine {
+	e := &Engine{
+		analyzer: core.NewAnalyzer(repo),
+		rules:    core.NewEngine(),
+		repo:     repo,
+		sessions: make(map[string]*session),
+		degraded: make(map[string]string),
+		elevated: make(map[string]bool),
+		reorder:  DefaultReorderConfig(),
+		dedup:    core.NewDedupWindow(DefaultDedupWindow),
+	}
+	if repo != nil {
+		e.cache = core.NewRuleCache(repo, core.DefaultRuleTTL)
+		e.pipeline = escalation.NewPipeline(repo, scheduler)
+		e.endHooks = []SessionEndHook{e.evaluateEndRules, e.closeSession, e.saveSummary}
+	}
+	return e
 }
 
-func NewEngine() *Engine {
-	repo, err := db.NewRepository()
+// DefaultDedupWindow is how many recent message ids are remembered in memory;
+// older repeats are caught by the messages table
+const DefaultDedupWindow = 100000
+
+// SetDedupWindow changes how many recent message ids are remembered
+func (e *Engine) SetDedupWindow(size int) {
+	e.dedup = core.NewDedupWindow(size)
+}
+
+// SetClassifier enables intent conditions by classifying every chunk
+func (e *Engine) SetClassifier(classifier *core.Classifier) {
+	e.analyzer.SetClassifier(classifier)
+}
+
+// Result is the outcome of processing a single chunk
+type Result struct {
+	Decision  core.Decision
+	Events    []core.EscalationEvent // escalations started by the chunk
+	Ended     bool                   // the chunk ended the session
+	Duplicate bool                   // the chunk's sequence or message id was already processed and it was skipped
+	Late      bool                   // the chunk arrived after later chunks were processed
+	Dropped   bool                   // the late chunk was dropped
+	Interim   bool                   // the chunk was an interim transcript, evaluated speculatively
+	Stale     bool                   // the interim chunk was superseded by a newer revision or the final one
+	Rejected  bool                   // the chunk was shed by the server under load and never processed
+	Degraded  bool                   // an earlier chunk of the session failed, see Engine.Fail
+	Err       error                  // the chunk could not be fully evaluated
+
+	// Timings has the analysis, decision and dispatch timestamps of a
+	// processed chunk; the caller fills in the earlier ones
+	Timings core.ChunkTimings
+
+	// LastProcessed is the highest contiguously processed sequence of the
+	// session after the chunk
+	LastProcessed uint64
+}
+
+// ProcessChunk processes a chunk immediately, bypassing the reorder buffer
+func (e *Engine) ProcessChunk(chunk *conversationv1.ConversationChunk) Result {
+	s := e.session(chunk.SessionId)
+	if result, ok := resent(s, chunk); ok {
+		return result
+	}
+	return e.process(chunk, false)
+}
+
+// resent returns the result of a chunk whose sequence was already processed
+func resent(s *session, chunk *conversationv1.ConversationChunk) (Result, bool) {
+	if chunk.Sequence == 0 || !s.seq.Seen(chunk.Sequence) {
+		return Result{}, false
+	}
+	log.Printf("[engine] session=%s skipping resent seq=%d", chunk.SessionId, chunk.Sequence)
+	return Result{Duplicate: true, LastProcessed: s.seq.Contiguous}, true
+}
+
+// process folds the chunk into its session and evaluates the rules. With
+// reevaluate, the rules see the conversation so far without the chunk as the
+// latest message, see core.LateReevaluate. Chunks whose sequence was already
+// processed are filtered out by the caller, see resent; late chunks of a
+// skipped gap are below the last processed sequence but not resent.
+func (e *Engine) process(chunk *conversationv1.ConversationChunk, reevaluate bool) Result {
+	s := e.session(chunk.SessionId)
+	if !s.resumed {
+		s.resumed = true
+		e.reopen(chunk.SessionId)
+	}
+	var result Result
+	if core.IsInterim(chunk) {
+		result = e.speculate(s, chunk)
+	} else {
+		if e.duplicate(chunk) {
+			log.Printf("[engine] session=%s skipping duplicate msg_id=%s", chunk.SessionId, chunk.MessageId)
+			// A resent copy still completes its sequence
+			s.seq.Mark(chunk.Sequence)
+			return Result{Duplicate: true, LastProcessed: s.seq.Contiguous}
+		}
+		rules, err := e.messageRules()
+		if err != nil {
+			// Nothing was counted yet; the chunk stays unprocessed so that a
+			// retry evaluates it
+			e.forget(chunk)
+			return Result{Err: err, LastProcessed: s.seq.Contiguous, Degraded: s.degraded != ""}
+		}
+		s.claimed = chunk
+		log.Printf("[engine] session=%s msg_id=%s seq=%d text=%s",
+			chunk.SessionId, chunk.MessageId, chunk.Sequence, chunk.Text)
+
+		current := e.analyzer.AnalyzeChunk(chunk)
+		s.agg.Add(chunk, current.WordCounts)
+		analysis := s.agg.Analysis(current)
+		if reevaluate {
+			analysis = s.agg.Analysis(core.Analysis{MessageID: chunk.MessageId})
+		}
+		analyzed := time.Now()
+		result = e.decide(chunk, analysis, rules, s.interim.Final(chunk.MessageId))
+		result.Timings.Analyzed = analyzed
+		s.claimed = nil
+	}
+	s.seq.Mark(chunk.Sequence)
+	result.LastProcessed = s.seq.Contiguous
+	result.Degraded = s.degraded != ""
+	if chunk.Metadata[MetadataEndOfSession] == "true" {
+		result.Ended = e.EndSession(chunk.SessionId, EndReasonSignal)
+	}
+	return result
+}
+
+// decide evaluates the per-message rules and applies the decision. Rules in
+// fired already fired on the interim text of the message and don't fire again.
+func (e *Engine) decide(chunk *conversationv1.ConversationChunk, analysis core.Analysis, rules []core.ParsedRule, fired map[string]bool) Result {
+	if e.pipeline == nil {
+		return Result{}
+	}
+
+	decision := e.rules.Decide(analysis, rules)
+	var matched []core.ParsedRule
+	for _, rule := range decision.Matched {
+		if !fired[rule.ID] {
+			matched = append(matched, rule)
+		}
+	}
+	decision.Matched = matched
+	atRisk := e.rules.AtRisk(analysis, rules)
+	decided := time.Now()
+
+	e.elevate(chunk.SessionId, decision, atRisk)
+	events := e.pipeline.Apply(message(chunk), decision, atRisk)
+	return Result{Decision: decision, Events: events, Timings: core.ChunkTimings{Decided: decided, Dispatched: time.Now()}}
+}
+
+// speculate evaluates an interim transcript on the session as if it were
+// final, without counting it. Only rules that opt in fire; other matching
+// rules mark the session at risk as an early warning. Each rule fires at
+// most once per utterance, across its revisions and final result.
+func (e *Engine) speculate(s *session, chunk *conversationv1.ConversationChunk) Result {
+	key := core.DedupKey{ClientID: chunk.ClientId, SessionID: chunk.SessionId, MessageID: chunk.MessageId}
+	if !s.interim.Interim(chunk.MessageId, chunk.Revision) || e.dedup.Contains(key) {
+		log.Printf("[engine] session=%s skipping stale interim msg_id=%s rev=%d", chunk.SessionId, chunk.MessageId, chunk.Revision)
+		return Result{Interim: true, Stale: true}
+	}
+	log.Printf("[engine] session=%s msg_id=%s seq=%d rev=%d interim=%s",
+		chunk.SessionId, chunk.MessageId, chunk.Sequence, chunk.Revision, chunk.Text)
+
+	analysis := s.agg.Speculate(chunk, e.analyzer.AnalyzeChunk(chunk))
+	timings := core.ChunkTimings{Analyzed: time.Now()}
+	if e.pipeline == nil {
+		return Result{Interim: true, Timings: timings}
+	}
+	rules, err := e.messageRules()
 	if err != nil {
-		fmt.Printf("err in repo init")
+		return Result{Interim: true, Err: err, Timings: timings}
 	}
 
-	return &Engine{
-		analyzer: core.NewAnalyzer(repo),
+	decision := e.rules.Decide(analysis, rules)
+	atRisk := e.rules.AtRisk(analysis, rules)
+	var matched, shadow []core.ParsedRule
+	for _, rule := range decision.Matched {
+		switch {
+		case !rule.FireOnInterim:
+			atRisk = append(atRisk, rule.Name)
+		case !s.interim.Fire(chunk.MessageId, rule.ID):
+			matched = append(matched, rule)
+		}
+	}
+	for _, rule := range decision.Shadow {
+		if rule.FireOnInterim {
+			shadow = append(shadow, rule)
+		}
 	}
+	decision.Matched, decision.Shadow = matched, shadow
+	timings.Decided = time.Now()
+
+	e.elevate(chunk.SessionId, decision, atRisk)
+	events := e.pipeline.Apply(message(chunk), decision, atRisk)
+	timings.Dispatched = time.Now()
+	return Result{Decision: decision, Events: events, Interim: true, Timings: timings}
+}
+
+// messageRules returns the rules evaluated on every message, none without a
+// database
+func (e *Engine) messageRules() ([]core.ParsedRule, error) {
+	if e.cache == nil {
+		return nil, nil
+	}
+	rules, err := e.cache.GetAllRules()
+	if err != nil {
+		log.Printf("[engine] failed to fetch rules: %v", err)
+		return nil, fmt.Errorf("failed to fetch rules: %w", err)
+	}
+	return core.RulesForTrigger(rules, core.TriggerMessage), nil
+}
+
+func message(chunk *conversationv1.ConversationChunk) escalation.Message {
+	return escalation.Message{
+		SessionID:  chunk.SessionId,
+		ClientID:   chunk.ClientId,
+		MessageID:  chunk.MessageId,
+		Text:       chunk.Text,
+		Interim:    core.IsInterim(chunk),
+		Aggregated: true,
+	}
+}
+
+// duplicate reports whether the chunk's message was already processed, first
+// against the in-memory window and then by storing it in the messages table.
+// Chunks without a message id are never duplicates.
+func (e *Engine) duplicate(chunk *conversationv1.ConversationChunk) bool {
+	if chunk.MessageId != "" {
+		key := core.DedupKey{ClientID: chunk.ClientId, SessionID: chunk.SessionId, MessageID: chunk.MessageId}
+		if e.dedup.Add(key) {
+			return true
+		}
+	}
+	if e.repo == nil {
+		return false
+	}
+	_, err := e.repo.SaveMessage(chunk.ClientId, chunk.SessionId, chunk.MessageId, chunk.Text, chunk.TimestampMs)
+	if errors.Is(err, db.ErrDuplicate) {
+		return true
+	}
+	if err != nil {
+		log.Printf("[engine] failed to save message: %v", err)
+	}
+	return false
 }
 
-func (e *Engine) ProcessChunk(chunk *conversationv1.ConversationChunk) {
-	// Later: evaluate rules, trigger escalation, save event to MySQL.
-	e.analyzer.Analyze(chunk)
-	log.Printf("[engine] session=%s msg_id=%s text=%s",
-		chunk.SessionId, chunk.MessageId, chunk.Text)
+// forget undoes duplicate for a chunk that could not be processed, so that
+// a retry of its message is not skipped as a duplicate
+func (e *Engine) forget(chunk *conversationv1.ConversationChunk) {
+	if chunk.MessageId == "" {
+		return
+	}
+	e.dedup.Remove(core.DedupKey{ClientID: chunk.ClientId, SessionID: chunk.SessionId, MessageID: chunk.MessageId})
+	if e.repo == nil {
+		return
+	}
+	if err := e.repo.DeleteMessage(chunk.ClientId, chunk.SessionId, chunk.MessageId); err != nil {
+		log.Printf("[engine] session=%s failed to forget msg_id=%s: %v", chunk.SessionId, chunk.MessageId, err)
+	}
+}
+
+// Fail marks a session degraded after one of its tasks failed midway, e.g.
+// panicked: its state is kept, but its acks report it and its summary records
+// why. The chunk being evaluated is not recorded as processed, so that a
+// retry evaluates it, and the callbacks of the chunks that were being
+// processed get err; chunks still held for reordering are processed later as
+// usual. It must be called from the worker that owns the session.
+func (e *Engine) Fail(sessionID string, err error) {
+	e.mu.Lock()
+	s, ok := e.sessions[sessionID]
+	if ok {
+		e.degraded[sessionID] = err.Error()
+	}
+	e.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	log.Printf("[engine] session=%s degraded: %v", sessionID, err)
+	if s.degraded == "" {
+		s.degraded = err.Error()
+	}
+	if s.claimed != nil {
+		e.forget(s.claimed)
+		s.claimed = nil
+	}
+	for chunk := range s.waiting {
+		if s.reorder.Holds(chunk) {
+			continue
+		}
+		e.finish(s, chunk, Result{Err: err, Degraded: true, LastProcessed: s.seq.Contiguous})
+	}
+}
+
+// elevate marks the session elevated once a rule fired or partially matched
+// on it
+func (e *Engine) elevate(sessionID string, decision core.Decision, atRisk []string) {
+	if len(decision.Matched) == 0 && len(atRisk) == 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.sessions[sessionID]; ok {
+		e.elevated[sessionID] = true
+	}
+}
+
+// Elevated reports whether the live session is at risk or escalated, e.g. to
+// process its chunks ahead of others. It is safe for concurrent use.
+func (e *Engine) Elevated(sessionID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.elevated[sessionID]
+}
+
+// DegradedSessions returns the live sessions marked degraded with the reason
+func (e *Engine) DegradedSessions() map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	degraded := make(map[string]string, len(e.degraded))
+	for id, reason := range e.degraded {
+		degraded[id] = reason
+	}
+	return degraded
+}
+
+// LastProcessed returns the highest contiguously processed sequence of a
+// session and whether the session is live. It must be called from the worker
+// that owns the session.
+func (e *Engine) LastProcessed(sessionID string) (uint64, bool) {
+	e.mu.Lock()
+	s, ok := e.sessions[sessionID]
+	e.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	return s.seq.Contiguous, true
+}
+
+// session returns the state of a session, creating it on first use, and
+// records the activity for the idle TTL
+func (e *Engine) session(sessionID string) *session {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, ok := e.sessions[sessionID]
+	if !ok {
+		s = &session{
+			agg:     core.NewSessionAggregate(sessionID),
+			interim: core.NewInterimTracker(),
+			reorder: core.NewReorderBuffer(1, e.reorder.MaxWait, e.reorder.MaxPending),
+			waiting: make(map[*conversationv1.ConversationChunk]func(Result)),
+		}
+		e.sessions[sessionID] = s
+	}
+	s.lastSeen = time.Now()
+	return s
 }
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+)
+
+// flakyRules fails to load the rules while failing is set
+type flakyRules struct {
+	failing bool
+}
+
+func (f *flakyRules) GetAllRules() ([]core.ParsedRule, error) {
+	if f.failing {
+		return nil, errors.New("database unavailable")
+	}
+	return nil, nil
+}
+
+func TestRuleFetchFailureIsRetried(t *testing.T) {
+	e := NewEngine(nil, nil)
+	store := &flakyRules{failing: true}
+	e.cache = core.NewRuleCache(store, 0)
+
+	var result Result
+	done := func(r Result) { result = r }
+	c := chunk("s", 1)
+	c.MessageId = "m1"
+	e.Submit(c, done)
+	if result.Err == nil || result.LastProcessed != 0 {
+		t.Fatalf("Expected the chunk to fail unprocessed, got %+v", result)
+	}
+
+	store.failing = false
+	e.Submit(c, done)
+	if result.Err != nil || result.Duplicate || result.LastProcessed != 1 {
+		t.Fatalf("Expected the retry to be processed, got %+v", result)
+	}
+	e.Submit(c, done)
+	if !result.Duplicate {
+		t.Errorf("Expected a copy after the retry to be a duplicate, got %+v", result)
+	}
+}
+
+func TestFailForgetsClaimedChunk(t *testing.T) {
+	e := NewEngine(nil, nil)
+	c := chunk("s", 1)
+	c.MessageId = "m1"
+	s := e.session("s")
+	if e.duplicate(c) {
+		t.Fatal("Expected the first copy not to be a duplicate")
+	}
+	// As if evaluating the chunk panicked
+	s.claimed = c
+	e.Fail("s", errors.New("panic"))
+
+	var result Result
+	e.Submit(c, func(r Result) { result = r })
+	if result.Duplicate || result.LastProcessed != 1 {
+		t.Errorf("Expected the retry after a panic to be processed, got %+v", result)
+	}
+}
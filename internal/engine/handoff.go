@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+// SessionStateVersion is the version of the SessionState written by Export;
+// Import accepts this version and older ones. Version 2 added last_seen_ms.
+const SessionStateVersion = 2
+
+// ErrHandedOff is the error of chunks still waiting when their session was
+// handed over to another replica
+var ErrHandedOff = errors.New("session handed off to another replica")
+
+// Export returns the state of a live session so that another replica can
+// carry on with it. Chunks still held for reordering are not part of it, so
+// call FlushHeld first. It must be called from the worker that owns the
+// session.
+func (e *Engine) Export(sessionID string) (*conversationv1.SessionState, bool) {
+	e.mu.Lock()
+	s, ok := e.sessions[sessionID]
+	elevated := e.elevated[sessionID]
+	var lastSeen time.Time
+	if ok {
+		lastSeen = s.lastSeen
+	}
+	e.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	agg := s.agg
+	state := &conversationv1.SessionState{
+		Version:               SessionStateVersion,
+		SessionId:             sessionID,
+		WordCounts:            int64Counts(agg.WordCounts),
+		SenderWordCounts:      make(map[string]*conversationv1.WordCounts, len(agg.SenderWordCounts)),
+		TurnCounts:            int64Counts(agg.TurnCounts),
+		Turns:                 int64(agg.Turns),
+		FirstTimestampMs:      agg.FirstTimestampMs,
+		LastTimestampMs:       agg.LastTimestampMs,
+		WordTurns:             make(map[string]*conversationv1.MessageIds, len(agg.WordTurns)),
+		LastProcessedSequence: s.seq.Contiguous,
+		Degraded:              s.degraded,
+		Elevated:              elevated,
+		LastSeenMs:            lastSeen.UnixMilli(),
+	}
+	for sender, counts := range agg.SenderWordCounts {
+		state.SenderWordCounts[sender] = &conversationv1.WordCounts{Counts: int64Counts(counts)}
+	}
+	for word, ids := range agg.WordTurns {
+		state.WordTurns[word] = &conversationv1.MessageIds{Ids: append([]string(nil), ids...)}
+	}
+	return state, true
+}
+
+// Release forgets a live session without ending it, once another replica
+// took it over; chunks still waiting for their result get ErrHandedOff. It
+// must be called from the worker that owns the session.
+func (e *Engine) Release(sessionID string) bool {
+	e.mu.Lock()
+	s, ok := e.sessions[sessionID]
+	if ok {
+		delete(e.sessions, sessionID)
+		delete(e.degraded, sessionID)
+		delete(e.elevated, sessionID)
+	}
+	e.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	for chunk := range s.waiting {
+		e.finish(s, chunk, Result{Err: ErrHandedOff, LastProcessed: s.seq.Contiguous})
+	}
+	log.Printf("[engine] session=%s released at seq=%d", sessionID, s.seq.Contiguous)
+	return true
+}
+
+// Import takes over a session exported by another replica or restored from a
+// snapshot. If the session is already live here, because chunks arrived
+// before its state, the state is merged in as the earlier part of the
+// conversation. It must be called from the worker that owns the session.
+func (e *Engine) Import(state *conversationv1.SessionState) error {
+	if state.Version > SessionStateVersion {
+		return fmt.Errorf("unsupported session state version %d", state.Version)
+	}
+	if state.SessionId == "" {
+		return fmt.Errorf("session state without session id")
+	}
+
+	agg := core.NewSessionAggregate(state.SessionId)
+	agg.WordCounts = intCounts(state.WordCounts)
+	for sender, counts := range state.SenderWordCounts {
+		agg.SenderWordCounts[sender] = intCounts(counts.GetCounts())
+	}
+	agg.TurnCounts = intCounts(state.TurnCounts)
+	agg.Turns = int(state.Turns)
+	agg.FirstTimestampMs = state.FirstTimestampMs
+	agg.LastTimestampMs = state.LastTimestampMs
+	for word, ids := range state.WordTurns {
+		agg.WordTurns[word] = ids.GetIds()
+	}
+
+	e.mu.Lock()
+	s, live := e.sessions[state.SessionId]
+	if state.Elevated {
+		e.elevated[state.SessionId] = true
+	}
+	if state.Degraded != "" {
+		e.degraded[state.SessionId] = state.Degraded
+	}
+	e.mu.Unlock()
+
+	if !live {
+		s = e.session(state.SessionId)
+		if state.LastSeenMs > 0 {
+			// The idle TTL keeps running from the last chunk
+			e.mu.Lock()
+			s.lastSeen = time.UnixMilli(state.LastSeenMs)
+			e.mu.Unlock()
+		}
+		s.agg = agg
+		s.seq.Advance(state.LastProcessedSequence)
+		s.reorder = core.NewReorderBuffer(state.LastProcessedSequence+1, e.reorder.MaxWait, e.reorder.MaxPending)
+	} else {
+		s.agg.Merge(agg)
+		s.seq.Advance(state.LastProcessedSequence)
+	}
+	if s.degraded == "" {
+		s.degraded = state.Degraded
+	}
+	log.Printf("[engine] session=%s imported at seq=%d (merged=%v)", state.SessionId, state.LastProcessedSequence, live)
+
+	if !live {
+		return nil
+	}
+	// Held chunks waiting for the sequences processed elsewhere are released
+	before := s.reorder.Stats
+	ready, duplicates := s.reorder.Advance(state.LastProcessedSequence + 1)
+	e.countReorder(before, s.reorder.Stats)
+	for _, chunk := range duplicates {
+		e.finish(s, chunk, Result{Duplicate: true, LastProcessed: s.seq.Contiguous})
+	}
+	return e.processReady(s, ready)
+}
+
+func int64Counts(counts map[string]int) map[string]int64 {
+	out := make(map[string]int64, len(counts))
+	for k, n := range counts {
+		out[k] = int64(n)
+	}
+	return out
+}
+
+func intCounts(counts map[string]int64) map[string]int {
+	out := make(map[string]int, len(counts))
+	for k, n := range counts {
+		out[k] = int(n)
+	}
+	return out
+}
@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/escalation"
+)
+
+// MetadataEndOfSession is the chunk metadata key that ends the session once
+// the chunk has been processed, e.g. {"end_of_session": "true"}
+const MetadataEndOfSession = "end_of_session"
+
+// MetadataKeepOpen is the chunk metadata key that keeps the session alive when
+// the stream that carried it closes, for producers that open a stream per
+// message
+const MetadataKeepOpen = "keep_open"
+
+// Reasons a session ended, recorded in its summary
+const (
+	EndReasonSignal       = "end_of_session"
+	EndReasonStreamClosed = "stream_closed"
+	EndReasonIdle         = "idle"
+)
+
+// summaryTopWords is how many of the most frequent words a summary keeps
+const summaryTopWords = 10
+
+// SessionEndHook runs when a session ends, before its state is released.
+// Hooks may fill in the summary, which the built-in hooks persist.
+type SessionEndHook func(agg *core.SessionAggregate, summary *core.SessionSummary)
+
+// OnSessionEnd registers a hook run after the built-in ones (end-of-conversation
+// rules, closing the session and persisting its summary)
+func (e *Engine) OnSessionEnd(hook SessionEndHook) {
+	e.endHooks = append(e.endHooks, hook)
+}
+
+// EndSession finalizes a session: it processes the chunks still held for
+// reordering, runs the end hooks and releases the session state. It returns
+// false if the session was not live. It must be called from the worker that
+// owns the session.
+func (e *Engine) EndSession(sessionID, reason string) bool {
+	e.mu.Lock()
+	s, ok := e.sessions[sessionID]
+	e.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	// A held chunk may itself end the session
+	e.flushAll(s)
+	e.mu.Lock()
+	live := e.sessions[sessionID] == s
+	if live {
+		delete(e.sessions, sessionID)
+		delete(e.degraded, sessionID)
+		delete(e.elevated, sessionID)
+	}
+	e.mu.Unlock()
+	if !live {
+		return true
+	}
+
+	log.Printf("[engine] session=%s ended: %s (reorder %+v)", sessionID, reason, s.reorder.Stats)
+	summary := s.agg.Summary(reason, summaryTopWords)
+	summary.FinalizedAt = time.Now().UnixMilli()
+	summary.Degraded = s.degraded
+	for _, hook := range e.endHooks {
+		hook(s.agg, &summary)
+	}
+	return true
+}
+
+// EndIfIdle ends the session if it has not seen a chunk for ttl. The idle
+// sweep calls it through the session's worker, so a chunk queued after the
+// sweep looked at the session keeps it alive.
+func (e *Engine) EndIfIdle(sessionID string, ttl time.Duration) bool {
+	e.mu.Lock()
+	s, ok := e.sessions[sessionID]
+	idle := ok && time.Since(s.lastSeen) >= ttl
+	e.mu.Unlock()
+	if !idle {
+		return false
+	}
+	return e.EndSession(sessionID, EndReasonIdle)
+}
+
+// IdleSessions returns the live sessions that have not seen a chunk for ttl
+func (e *Engine) IdleSessions(ttl time.Duration) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var idle []string
+	for id, s := range e.sessions {
+		if time.Since(s.lastSeen) >= ttl {
+			idle = append(idle, id)
+		}
+	}
+	return idle
+}
+
+// SessionIDs returns the ids of the sessions held in memory
+func (e *Engine) SessionIDs() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ids := make([]string, 0, len(e.sessions))
+	for id := range e.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// LiveSessions returns the number of sessions held in memory
+func (e *Engine) LiveSessions() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.sessions)
+}
+
+// reopen moves a closed session back to active when its conversation resumes
+// after it ended, e.g. a producer reconnecting after its stream closed, so
+// that its rules escalate it again
+func (e *Engine) reopen(sessionID string) {
+	if e.repo == nil {
+		return
+	}
+	state, err := e.repo.GetSessionState(sessionID)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("[engine] failed to load session %s: %v", sessionID, err)
+		}
+		return
+	}
+	if state.Status != core.SessionClosed {
+		return
+	}
+	if _, err := e.repo.TransitionSession(sessionID, core.SessionActive, "conversation resumed"); err != nil {
+		log.Printf("[engine] failed to reopen session %s: %v", sessionID, err)
+	}
+}
+
+// evaluateEndRules runs the session_end rules once on the whole conversation,
+// e.g. "ended without a resolution phrase"
+func (e *Engine) evaluateEndRules(agg *core.SessionAggregate, summary *core.SessionSummary) {
+	rules, err := e.cache.GetAllRules()
+	if err != nil {
+		log.Printf("[engine] failed to fetch rules: %v", err)
+		return
+	}
+	rules = core.RulesForTrigger(rules, core.TriggerSessionEnd)
+	if len(rules) == 0 {
+		return
+	}
+
+	analysis := agg.Analysis(core.Analysis{})
+	decision := e.rules.Decide(analysis, rules)
+	e.pipeline.Apply(escalation.Message{
+		SessionID:  agg.SessionID,
+		MessageID:  "session-end",
+		Text:       "session ended: " + summary.EndReason,
+		Aggregated: true,
+	}, decision, nil)
+}
+
+// closeSession closes sessions that ended without an open escalation.
+// Escalated and acknowledged sessions stay open for the agent handling them.
+func (e *Engine) closeSession(agg *core.SessionAggregate, summary *core.SessionSummary) {
+	state, err := e.repo.GetSessionState(agg.SessionID)
+	if err != nil {
+		log.Printf("[engine] failed to load session %s: %v", agg.SessionID, err)
+		return
+	}
+	summary.FinalStatus = state.Status
+	switch state.Status {
+	case core.SessionActive, core.SessionAtRisk, core.SessionResolved:
+	default:
+		return
+	}
+
+	closed, err := e.repo.TransitionSession(agg.SessionID, core.SessionClosed, "session ended: "+summary.EndReason)
+	if err != nil {
+		log.Printf("[engine] failed to close session %s: %v", agg.SessionID, err)
+		return
+	}
+	summary.FinalStatus = closed.Status
+}
+
+func (e *Engine) saveSummary(agg *core.SessionAggregate, summary *core.SessionSummary) {
+	if err := e.repo.SaveSessionSummary(*summary); err != nil {
+		log.Printf("[engine] failed to save summary of session %s: %v", agg.SessionID, err)
+	}
+}
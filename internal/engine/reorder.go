@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+// Dispatcher runs fn on the worker that owns the session
+type Dispatcher func(sessionID string, fn func() error)
+
+// ReorderConfig tunes how out-of-order chunks are handled
+type ReorderConfig struct {
+	// MaxWait is how long a chunk is held waiting for the chunks before it
+	MaxWait time.Duration
+	// MaxPending is how many chunks a session may hold
+	MaxPending int
+	// Late is what happens to chunks that arrive after their wait expired
+	Late core.LatePolicy
+}
+
+// DefaultReorderConfig holds chunks for up to 200ms and processes late ones
+func DefaultReorderConfig() ReorderConfig {
+	return ReorderConfig{
+		MaxWait:    200 * time.Millisecond,
+		MaxPending: 64,
+		Late:       core.LateProcess,
+	}
+}
+
+// SetReorder configures the reorder buffers of sessions created afterwards
+func (e *Engine) SetReorder(cfg ReorderConfig) {
+	e.reorder = cfg
+}
+
+// SetDispatcher lets the engine flush held chunks from the session's worker
+// once their wait expires. Without it, held chunks are only released when
+// later chunks of the session arrive or the session ends.
+func (e *Engine) SetDispatcher(dispatch Dispatcher) {
+	e.dispatch = dispatch
+}
+
+// ReorderStats returns the reorder counters of every session so far
+func (e *Engine) ReorderStats() core.ReorderStats {
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	return e.reorderStats
+}
+
+// Submit processes a chunk in sequence order. Chunks that arrive ahead of a
+// gap are held for the configured wait; done is called with the result of the
+// chunk once it is processed, dropped or recognised as a duplicate, possibly
+// from a later call on the same worker. It returns the errors of the chunks
+// it processed, which are also in their results.
+func (e *Engine) Submit(chunk *conversationv1.ConversationChunk, done func(Result)) error {
+	s := e.session(chunk.SessionId)
+	if done != nil {
+		s.waiting[chunk] = done
+	}
+
+	before := s.reorder.Stats
+	ready, arrival := s.reorder.Push(chunk, time.Now())
+	e.countReorder(before, s.reorder.Stats)
+	skipGaps(s)
+
+	var err error
+	switch arrival {
+	case core.ArrivalDuplicate:
+		if s.reorder.Released(chunk.Sequence) && !s.seq.Seen(chunk.Sequence) {
+			// Processing it failed, so this is a retry rather than a copy
+			ready = append(ready, chunk)
+			break
+		}
+		log.Printf("[engine] session=%s skipping resent seq=%d", chunk.SessionId, chunk.Sequence)
+		e.finish(s, chunk, Result{Duplicate: true, LastProcessed: s.seq.Contiguous})
+	case core.ArrivalLate:
+		err = e.processLate(s, chunk)
+	}
+	err = errors.Join(err, e.processReady(s, ready))
+	e.armFlush(chunk.SessionId, s)
+	return err
+}
+
+func (e *Engine) processLate(s *session, chunk *conversationv1.ConversationChunk) error {
+	log.Printf("[engine] session=%s late chunk seq=%d ts=%d, policy=%s",
+		chunk.SessionId, chunk.Sequence, chunk.TimestampMs, e.reorder.Late)
+
+	var result Result
+	switch e.reorder.Late {
+	case core.LateDrop:
+		// Count it as processed so that resumes don't resend it
+		s.seq.Mark(chunk.Sequence)
+		result = Result{Dropped: true, LastProcessed: s.seq.Contiguous}
+	case core.LateReevaluate:
+		result = e.process(chunk, true)
+	default:
+		result = e.process(chunk, false)
+	}
+	result.Late = true
+	e.finish(s, chunk, result)
+	return result.Err
+}
+
+func (e *Engine) processReady(s *session, ready []*conversationv1.ConversationChunk) error {
+	var errs []error
+	for _, chunk := range ready {
+		result, ok := resent(s, chunk)
+		if !ok {
+			result = e.process(chunk, false)
+		}
+		e.finish(s, chunk, result)
+		errs = append(errs, result.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// finish hands the result to the callback of the chunk, if any
+func (e *Engine) finish(s *session, chunk *conversationv1.ConversationChunk, result Result) {
+	done, ok := s.waiting[chunk]
+	if !ok {
+		return
+	}
+	delete(s.waiting, chunk)
+	done(result)
+}
+
+// armFlush schedules the release of the session's held chunks when the oldest
+// one's wait expires
+func (e *Engine) armFlush(sessionID string, s *session) {
+	if e.dispatch == nil || s.timerArmed {
+		return
+	}
+	deadline, ok := s.reorder.Deadline()
+	if !ok {
+		return
+	}
+	s.timerArmed = true
+	time.AfterFunc(time.Until(deadline), func() {
+		e.dispatch(sessionID, func() error { return e.flushExpired(sessionID, s) })
+	})
+}
+
+// flushExpired releases the held chunks whose wait expired
+func (e *Engine) flushExpired(sessionID string, s *session) error {
+	s.timerArmed = false
+	e.mu.Lock()
+	live := e.sessions[sessionID] == s
+	e.mu.Unlock()
+	if !live {
+		return nil
+	}
+
+	before := s.reorder.Stats
+	ready := s.reorder.Expire(time.Now())
+	e.countReorder(before, s.reorder.Stats)
+	skipGaps(s)
+	err := e.processReady(s, ready)
+	e.armFlush(sessionID, s)
+	return err
+}
+
+// FlushHeld processes the chunks the session holds for reordering without
+// waiting for the chunks before them, e.g. before the server stops. It must
+// be called from the worker that owns the session.
+func (e *Engine) FlushHeld(sessionID string) error {
+	e.mu.Lock()
+	s, ok := e.sessions[sessionID]
+	e.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return e.flushAll(s)
+}
+
+// flushAll processes every held chunk, skipping the gaps, before the session ends
+func (e *Engine) flushAll(s *session) error {
+	before := s.reorder.Stats
+	ready := s.reorder.Flush()
+	e.countReorder(before, s.reorder.Stats)
+	skipGaps(s)
+	return e.processReady(s, ready)
+}
+
+// skipGaps stops waiting for the sequences the reorder buffer gave up on, so
+// that the last processed sequence moves past them
+func skipGaps(s *session) {
+	for _, gap := range s.reorder.Gaps() {
+		s.seq.Skip(gap.From, gap.To)
+	}
+}
+
+func (e *Engine) countReorder(before, after core.ReorderStats) {
+	delta := core.ReorderStats{
+		InOrder:   after.InOrder - before.InOrder,
+		Reordered: after.Reordered - before.Reordered,
+		Skipped:   after.Skipped - before.Skipped,
+		Late:      after.Late - before.Late,
+	}
+	if delta == (core.ReorderStats{}) {
+		return
+	}
+	e.statsMu.Lock()
+	e.reorderStats.Add(delta)
+	e.statsMu.Unlock()
+}
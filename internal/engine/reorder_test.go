@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+func chunk(sessionID string, seq uint64) *conversationv1.ConversationChunk {
+	return &conversationv1.ConversationChunk{
+		SessionId: sessionID,
+		Sequence:  seq,
+		Text:      "hello",
+		Metadata:  map[string]string{MetadataKeepOpen: "true"},
+	}
+}
+
+func TestSkippedGapAdvancesLastProcessed(t *testing.T) {
+	e := NewEngine(nil, nil)
+	e.SetReorder(ReorderConfig{MaxWait: time.Hour, MaxPending: 2, Late: core.LateProcess})
+
+	var results []Result
+	done := func(r Result) { results = append(results, r) }
+	e.Submit(chunk("s", 1), done)
+	// 2 never arrives, e.g. it was shed; the buffer gives up on it once full
+	for _, seq := range []uint64{3, 4, 5} {
+		e.Submit(chunk("s", seq), done)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(results))
+	}
+	if last := results[len(results)-1].LastProcessed; last != 5 {
+		t.Errorf("Expected the last processed sequence to move past the gap to 5, got %d", last)
+	}
+	if seq, _ := e.LastProcessed("s"); seq != 5 {
+		t.Errorf("Expected resume from 5, got %d", seq)
+	}
+
+	// The skipped chunk arriving late is still processed, not a duplicate
+	e.Submit(chunk("s", 2), done)
+	late := results[len(results)-1]
+	if !late.Late || late.Duplicate || late.LastProcessed != 5 {
+		t.Errorf("Expected the skipped chunk to be processed late, got %+v", late)
+	}
+	e.Submit(chunk("s", 2), done)
+	if !results[len(results)-1].Duplicate {
+		t.Errorf("Expected a second copy to be a duplicate, got %+v", results[len(results)-1])
+	}
+}
+
+func TestFlushHeldAdvancesLastProcessed(t *testing.T) {
+	e := NewEngine(nil, nil)
+	e.SetReorder(ReorderConfig{MaxWait: time.Hour, MaxPending: 64, Late: core.LateProcess})
+
+	e.Submit(chunk("s", 1), nil)
+	e.Submit(chunk("s", 4), nil)
+	if seq, _ := e.LastProcessed("s"); seq != 1 {
+		t.Fatalf("Expected 4 to be held, got last processed %d", seq)
+	}
+	if err := e.FlushHeld("s"); err != nil {
+		t.Fatal(err)
+	}
+	if seq, _ := e.LastProcessed("s"); seq != 4 {
+		t.Errorf("Expected the flushed gap to be skipped up to 4, got %d", seq)
+	}
+}
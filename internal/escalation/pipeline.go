@@ -0,0 +1,155 @@
+package escalation
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+)
+
+// Message identifies the message a decision was made for
+type Message struct {
+	SessionID string
+	ClientID  string
+	MessageID string
+	Text      string
+	Interim   bool // an interim transcript, evaluated on every revision
+	// Aggregated decisions were made on the cumulative counts of the session
+	// rather than on this message alone
+	Aggregated bool
+}
+
+// Pipeline applies rule decisions the same way for every ingestion path
+// (Kafka and gRPC): it records the decision trace and shadow firings, moves
+// the session through its escalation states and starts escalation ladders.
+type Pipeline struct {
+	repo      *db.Repository
+	scheduler *Scheduler
+}
+
+func NewPipeline(repo *db.Repository, scheduler *Scheduler) *Pipeline {
+	return &Pipeline{
+		repo:      repo,
+		scheduler: scheduler,
+	}
+}
+
+// Apply acts on a decision and returns the events of the escalations it
+// started. Rules that already fired for the session are not announced again,
+// see Scheduler.Escalate.
+func (p *Pipeline) Apply(msg Message, decision core.Decision, atRisk []string) []core.EscalationEvent {
+	var actions []string
+	for _, rule := range decision.Matched {
+		actions = append(actions, rule.Action)
+	}
+
+	// Keep a trace of every condition for the explain API. Interim revisions
+	// that matched nothing are not traced: there can be many per message and
+	// the final transcript is traced anyway.
+	var traceID string
+	if !msg.Interim || len(decision.Matched) > 0 || len(decision.Shadow) > 0 {
+		trace, err := p.repo.SaveDecisionTrace(core.DecisionTrace{
+			SessionID: msg.SessionID,
+			MessageID: msg.MessageID,
+			Escalated: len(decision.Matched) > 0,
+			Timestamp: time.Now().UnixMilli(),
+			Rules:     decision.Trace,
+		})
+		if err != nil {
+			log.Printf("Failed to save decision trace: %v", err)
+		} else {
+			traceID = trace.ID
+		}
+	}
+
+	// Shadow rules are only recorded, never acted on
+	for _, rule := range decision.Shadow {
+		p.recordShadow(msg, rule, decision.Trace)
+	}
+
+	// Move the session through its escalation states
+	p.updateSession(msg.SessionID, actions, atRisk)
+
+	// Trigger Actions (first level of each rule's escalation ladder)
+	var events []core.EscalationEvent
+	for _, rule := range decision.Matched {
+		score := 1.0
+		for _, rt := range decision.Trace {
+			if rt.RuleID == rule.ID {
+				score = rt.Score()
+			}
+		}
+		event, err := p.scheduler.Escalate(msg, rule, traceID, score)
+		if err != nil {
+			log.Printf("Failed to escalate rule %s: %v", rule.Name, err)
+			continue
+		}
+		if event != nil {
+			events = append(events, *event)
+		}
+	}
+	return events
+}
+
+func (p *Pipeline) recordShadow(msg Message, rule core.ParsedRule, trace []core.RuleTrace) {
+	firing := core.ShadowFiring{
+		SessionID: msg.SessionID,
+		MessageID: msg.MessageID,
+		RuleID:    rule.ID,
+		RuleName:  rule.Name,
+		Action:    rule.Action,
+		Text:      msg.Text,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	for _, rt := range trace {
+		if rt.RuleID == rule.ID {
+			firing.Trace = rt
+		}
+	}
+	if err := p.repo.SaveShadowFiring(firing); err != nil {
+		log.Printf("Failed to record shadow firing of rule %s: %v", rule.Name, err)
+	}
+}
+
+// updateSession escalates the session when a rule fired, or marks it at risk
+// when a rule partially matched. A resolved session is reopened when a rule
+// fires again. Sessions already escalated are left alone; later steps are
+// driven by the session API.
+func (p *Pipeline) updateSession(sessionID string, actions []string, atRisk []string) {
+	if err := p.repo.EnsureSession(sessionID); err != nil {
+		log.Printf("Failed to ensure session %s: %v", sessionID, err)
+		return
+	}
+	state, err := p.repo.GetSessionState(sessionID)
+	if err != nil {
+		log.Printf("Failed to load session %s: %v", sessionID, err)
+		return
+	}
+
+	var to core.SessionStatus
+	var reason string
+	switch {
+	case len(actions) > 0:
+		to, reason = core.SessionEscalated, "rule fired: "+strings.Join(actions, ",")
+	case len(atRisk) > 0 && state.Status == core.SessionActive:
+		to, reason = core.SessionAtRisk, "partial match: "+strings.Join(atRisk, ",")
+	default:
+		return
+	}
+	if state.Status == core.SessionResolved && to == core.SessionEscalated {
+		if _, err := p.repo.TransitionSession(sessionID, core.SessionActive, "reopened: "+strings.Join(actions, ",")); err != nil {
+			log.Printf("Failed to reopen session %s: %v", sessionID, err)
+			return
+		}
+		state.Status = core.SessionActive
+	}
+	if state.Status != core.SessionActive && state.Status != core.SessionAtRisk {
+		return
+	}
+
+	if _, err := p.repo.TransitionSession(sessionID, to, reason); err != nil {
+		log.Printf("Failed to move session %s to %s: %v", sessionID, to, err)
+	}
+}
@@ -0,0 +1,276 @@
+package escalation
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/events"
+)
+
+// Dispatcher performs the action of an escalation level
+type Dispatcher func(action string, esc core.Escalation, context string)
+
+// LogDispatcher logs the action; in a real system this would call an
+// external service or workflow engine
+func LogDispatcher(action string, esc core.Escalation, context string) {
+	log.Printf("!!! ESCALATION TRIGGERED !!! Action: %s | Level: %d | Session: %s | Context: %s",
+		strings.ToUpper(action), esc.Level, esc.SessionID, context)
+}
+
+// Store is the part of the repository the scheduler uses
+type Store interface {
+	GetPolicy(id string) (*core.EscalationPolicy, error)
+	CreateEscalation(esc core.Escalation) (*core.Escalation, error)
+	GetActiveEscalation(sessionID, ruleID string) (*core.Escalation, error)
+	GetLatestEscalation(sessionID, ruleID string) (*core.Escalation, error)
+	GetDueEscalations(now int64) ([]core.Escalation, error)
+	AdvanceEscalation(id string, fromLevel, toLevel int, nextAt int64, status core.EscalationStatus) (bool, error)
+	AcknowledgeEscalation(id, by string) (*core.Escalation, error)
+	GetSessionState(sessionID string) (*core.SessionState, error)
+}
+
+// Scheduler starts escalation ladders when rules fire and advances open
+// ladders to their next level when a level times out without acknowledgement
+type Scheduler struct {
+	repo     Store
+	dispatch Dispatcher
+	hub      *events.Hub
+	Interval time.Duration
+}
+
+func NewScheduler(repo Store, dispatch Dispatcher) *Scheduler {
+	if dispatch == nil {
+		dispatch = LogDispatcher
+	}
+	return &Scheduler{
+		repo:     repo,
+		dispatch: dispatch,
+		Interval: 5 * time.Second,
+	}
+}
+
+// SetEventHub publishes an event for every dispatched level to hub
+func (s *Scheduler) SetEventHub(hub *events.Hub) {
+	s.hub = hub
+}
+
+// publish sends the event to the hub, if any, and returns it with its id
+func (s *Scheduler) publish(event core.EscalationEvent) core.EscalationEvent {
+	if s.hub == nil {
+		return event
+	}
+	return s.hub.Publish(event)
+}
+
+// Escalate starts the ladder of a fired rule for a session, dispatches its
+// first level and returns the published event. A rule whose ladder is still
+// unacknowledged is not restarted, in which case the event is nil; rules
+// without a policy dispatch on every match of a single message. Aggregated
+// sessions fire each rule once until the session is resolved: their counts
+// are cumulative, so a rule that matched keeps matching on later messages.
+// traceID links the escalation to the decision trace that fired it and score
+// is the confidence of the decision.
+func (s *Scheduler) Escalate(msg Message, rule core.ParsedRule, traceID string, score float64) (*core.EscalationEvent, error) {
+	fired, err := s.fired(msg, rule)
+	if err != nil || fired {
+		return nil, err
+	}
+
+	policy, err := s.policyFor(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	esc := core.Escalation{
+		SessionID: msg.SessionID,
+		ClientID:  msg.ClientID,
+		RuleID:    rule.ID,
+		PolicyID:  rule.PolicyID,
+		Level:     0,
+		Status:    core.EscalationOpen,
+		TraceID:   traceID,
+	}
+	esc.NextAt, esc.Status = schedule(policy, 0, time.Now())
+
+	created, err := s.repo.CreateEscalation(esc)
+	if err != nil {
+		return nil, err
+	}
+	s.dispatch(policy.Levels[0].Action, *created, msg.Text)
+	event := s.publish(core.EscalationEvent{
+		SessionID:    msg.SessionID,
+		ClientID:     msg.ClientID,
+		MessageID:    msg.MessageID,
+		EscalationID: created.ID,
+		RuleID:       rule.ID,
+		RuleName:     rule.Name,
+		Action:       policy.Levels[0].Action,
+		Level:        0,
+		Score:        score,
+		TraceID:      traceID,
+		Timestamp:    created.CreatedAt,
+	})
+	return &event, nil
+}
+
+// fired reports whether the rule already escalated the session and must not
+// fire again for msg
+func (s *Scheduler) fired(msg Message, rule core.ParsedRule) (bool, error) {
+	if !msg.Aggregated {
+		if rule.PolicyID == "" {
+			return false, nil
+		}
+		_, err := s.repo.GetActiveEscalation(msg.SessionID, rule.ID)
+		if errors.Is(err, db.ErrNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	esc, err := s.repo.GetLatestEscalation(msg.SessionID, rule.ID)
+	if errors.Is(err, db.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// Acknowledged or not, the ladder counts until the session is resolved
+	state, err := s.repo.GetSessionState(msg.SessionID)
+	if errors.Is(err, db.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return resolvedAt(state) < esc.CreatedAt, nil
+}
+
+// resolvedAt returns when the session was last resolved or closed (unix
+// millis), 0 if never
+func resolvedAt(state *core.SessionState) int64 {
+	var at int64
+	for _, t := range state.Transitions {
+		if t.To == core.SessionResolved || t.To == core.SessionClosed {
+			at = max(at, t.Timestamp)
+		}
+	}
+	return at
+}
+
+// Start advances due escalations every Interval until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	log.Println("Escalation scheduler started...")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.Tick(now)
+		}
+	}
+}
+
+// Tick advances every escalation whose current level timed out at now
+func (s *Scheduler) Tick(now time.Time) {
+	due, err := s.repo.GetDueEscalations(now.UnixMilli())
+	if err != nil {
+		log.Printf("Failed to load due escalations: %v", err)
+		return
+	}
+	for _, esc := range due {
+		s.advance(esc, now)
+	}
+}
+
+func (s *Scheduler) advance(esc core.Escalation, now time.Time) {
+	// A session acknowledged, resolved or closed through the session API stops
+	// its ladders too
+	if state, err := s.repo.GetSessionState(esc.SessionID); err == nil && stopsLadders(state.Status) {
+		if _, err := s.repo.AcknowledgeEscalation(esc.ID, "session:"+string(state.Status)); err != nil {
+			log.Printf("Failed to stop escalation %s: %v", esc.ID, err)
+		}
+		return
+	}
+
+	policy, err := s.policyFor(core.ParsedRule{Rule: core.Rule{ID: esc.RuleID, PolicyID: esc.PolicyID}})
+	if err != nil {
+		log.Printf("Failed to load policy for escalation %s: %v", esc.ID, err)
+		return
+	}
+	next := esc.Level + 1
+	if next >= len(policy.Levels) {
+		// Policy shrank since the ladder started
+		if _, err := s.repo.AdvanceEscalation(esc.ID, esc.Level, esc.Level, 0, core.EscalationExhausted); err != nil {
+			log.Printf("Failed to exhaust escalation %s: %v", esc.ID, err)
+		}
+		return
+	}
+
+	nextAt, status := schedule(policy, next, now)
+	ok, err := s.repo.AdvanceEscalation(esc.ID, esc.Level, next, nextAt, status)
+	if err != nil {
+		log.Printf("Failed to advance escalation %s: %v", esc.ID, err)
+		return
+	}
+	if !ok {
+		// Acknowledged or advanced concurrently
+		return
+	}
+
+	esc.Level, esc.NextAt, esc.Status = next, nextAt, status
+	s.dispatch(policy.Levels[next].Action, esc, "not acknowledged in time")
+	s.publish(core.EscalationEvent{
+		SessionID:    esc.SessionID,
+		ClientID:     esc.ClientID,
+		EscalationID: esc.ID,
+		RuleID:       esc.RuleID,
+		Action:       policy.Levels[next].Action,
+		Level:        next,
+		Score:        1,
+		TraceID:      esc.TraceID,
+		Timestamp:    now.UnixMilli(),
+	})
+}
+
+// stopsLadders reports whether a session in status was handled by a person,
+// so that its escalation ladders stop; a session still escalated or merely at
+// risk keeps escalating
+func stopsLadders(status core.SessionStatus) bool {
+	switch status {
+	case core.SessionAcknowledged, core.SessionResolved, core.SessionClosed:
+		return true
+	}
+	return false
+}
+
+// policyFor returns the rule's policy, or a single-level policy for its action
+func (s *Scheduler) policyFor(rule core.ParsedRule) (core.EscalationPolicy, error) {
+	if rule.PolicyID == "" {
+		if rule.Action == "" {
+			return core.EscalationPolicy{}, errors.New("rule has neither policy nor action")
+		}
+		return core.SingleLevelPolicy(rule.Action), nil
+	}
+	policy, err := s.repo.GetPolicy(rule.PolicyID)
+	if err != nil {
+		return core.EscalationPolicy{}, err
+	}
+	return *policy, nil
+}
+
+// schedule returns when the level after level is due and the ladder status
+// once level has been dispatched
+func schedule(policy core.EscalationPolicy, level int, now time.Time) (int64, core.EscalationStatus) {
+	if level >= len(policy.Levels)-1 {
+		return 0, core.EscalationExhausted
+	}
+	return now.Add(policy.Levels[level].Timeout()).UnixMilli(), core.EscalationOpen
+}
@@ -0,0 +1,148 @@
+package escalation
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+)
+
+// memStore keeps escalations and session states in memory; its clock only
+// moves when an escalation is created or the session transitions
+type memStore struct {
+	now         int64
+	escalations []core.Escalation
+	sessions    map[string]*core.SessionState
+}
+
+func newMemStore() *memStore {
+	return &memStore{sessions: make(map[string]*core.SessionState)}
+}
+
+func (m *memStore) tick() int64 {
+	m.now++
+	return m.now
+}
+
+func (m *memStore) GetPolicy(id string) (*core.EscalationPolicy, error) {
+	return nil, db.ErrNotFound
+}
+
+func (m *memStore) CreateEscalation(esc core.Escalation) (*core.Escalation, error) {
+	esc.ID = fmt.Sprintf("esc-%d", len(m.escalations)+1)
+	esc.CreatedAt = m.tick()
+	m.escalations = append(m.escalations, esc)
+	return &esc, nil
+}
+
+func (m *memStore) GetActiveEscalation(sessionID, ruleID string) (*core.Escalation, error) {
+	for _, esc := range m.escalations {
+		if esc.SessionID == sessionID && esc.RuleID == ruleID && esc.Status != core.EscalationAcknowledged {
+			return &esc, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (m *memStore) GetLatestEscalation(sessionID, ruleID string) (*core.Escalation, error) {
+	for i := len(m.escalations) - 1; i >= 0; i-- {
+		if esc := m.escalations[i]; esc.SessionID == sessionID && esc.RuleID == ruleID {
+			return &esc, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (m *memStore) GetDueEscalations(now int64) ([]core.Escalation, error) {
+	return nil, nil
+}
+
+func (m *memStore) AdvanceEscalation(id string, fromLevel, toLevel int, nextAt int64, status core.EscalationStatus) (bool, error) {
+	return false, nil
+}
+
+func (m *memStore) AcknowledgeEscalation(id, by string) (*core.Escalation, error) {
+	for i := range m.escalations {
+		if m.escalations[i].ID == id {
+			m.escalations[i].Status = core.EscalationAcknowledged
+			m.escalations[i].AcknowledgedBy = by
+			return &m.escalations[i], nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (m *memStore) GetSessionState(sessionID string) (*core.SessionState, error) {
+	state, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return state, nil
+}
+
+func (m *memStore) transition(sessionID string, to core.SessionStatus) {
+	state, ok := m.sessions[sessionID]
+	if !ok {
+		state = &core.SessionState{SessionID: sessionID, Status: core.SessionActive}
+		m.sessions[sessionID] = state
+	}
+	state.Transitions = append(state.Transitions, core.SessionTransition{From: state.Status, To: to, Timestamp: m.tick()})
+	state.Status = to
+}
+
+var angryRule = core.ParsedRule{Rule: core.Rule{ID: "r1", Name: "angry", Action: "page"}}
+
+func TestAggregatedRuleFiresOncePerSessionUntilResolved(t *testing.T) {
+	store := newMemStore()
+	s := NewScheduler(store, func(string, core.Escalation, string) {})
+	msg := Message{SessionID: "s", Aggregated: true}
+
+	escalate := func() *core.EscalationEvent {
+		t.Helper()
+		event, err := s.Escalate(msg, angryRule, "", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+
+	first := escalate()
+	if first == nil {
+		t.Fatal("Expected the rule to fire")
+	}
+	store.transition("s", core.SessionEscalated)
+	if event := escalate(); event != nil {
+		t.Fatalf("Expected the rule not to fire again, got %+v", event)
+	}
+
+	store.AcknowledgeEscalation(first.EscalationID, "agent")
+	store.transition("s", core.SessionAcknowledged)
+	if event := escalate(); event != nil {
+		t.Fatalf("Expected an acknowledged session not to fire again, got %+v", event)
+	}
+
+	store.transition("s", core.SessionResolved)
+	if event := escalate(); event == nil {
+		t.Fatal("Expected the rule to fire again after the session was resolved")
+	}
+	if event := escalate(); event != nil {
+		t.Errorf("Expected the rule to fire once after the resolve, got %+v", event)
+	}
+}
+
+func TestPerMessageRuleFiresOnEveryMatch(t *testing.T) {
+	store := newMemStore()
+	s := NewScheduler(store, func(string, core.Escalation, string) {})
+	msg := Message{SessionID: "default_conversation"}
+
+	for i := 0; i < 3; i++ {
+		event, err := s.Escalate(msg, angryRule, "", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event == nil {
+			t.Fatalf("Expected match %d to fire a rule without a policy", i+1)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package events
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+)
+
+// SlowPolicy decides what happens when a subscriber's buffer is full
+type SlowPolicy int
+
+const (
+	// DropSlow drops events for the subscriber and counts them
+	DropSlow SlowPolicy = iota
+	// DisconnectSlow ends the subscription with ErrSlowSubscriber; the
+	// subscriber can resume after the last event id it received
+	DisconnectSlow
+)
+
+// ErrSlowSubscriber ends subscriptions that fell behind with DisconnectSlow
+var ErrSlowSubscriber = errors.New("subscriber fell behind")
+
+// subscriberBuffer is how many events a subscriber may lag behind
+const subscriberBuffer = 256
+
+// replayBatch is how many persisted events are read per query on resume
+const replayBatch = 500
+
+// Hub persists escalation events and fans them out to in-process subscribers.
+// Without a repository events are only delivered live.
+type Hub struct {
+	repo *db.Repository
+
+	// publishMu serializes publishing, so that events are assigned their ids
+	// and delivered in the same order
+	publishMu sync.Mutex
+	nextID    int64 // event ids when there is no repository
+
+	// mu guards subs
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewHub(repo *db.Repository) *Hub {
+	return &Hub{
+		repo: repo,
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the events matching its filter
+type Subscription struct {
+	hub    *Hub
+	filter core.EscalationFilter
+	policy SlowPolicy
+	events chan core.EscalationEvent
+	done   chan struct{}
+	once   sync.Once
+	err    error
+
+	dropped atomic.Int64
+}
+
+// Events returns the channel events are delivered on
+func (s *Subscription) Events() <-chan core.EscalationEvent { return s.events }
+
+// Done is closed when the hub ended the subscription, see Err
+func (s *Subscription) Done() <-chan struct{} { return s.done }
+
+// Err returns why the hub ended the subscription
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Dropped returns how many events were dropped because the subscriber was slow
+func (s *Subscription) Dropped() int64 { return s.dropped.Load() }
+
+// Close unsubscribes
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subs, s)
+	s.hub.mu.Unlock()
+	s.end(nil)
+}
+
+func (s *Subscription) end(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// Subscribe registers a subscriber for live events
+func (h *Hub) Subscribe(filter core.EscalationFilter, policy SlowPolicy) *Subscription {
+	s := &Subscription{
+		hub:    h,
+		filter: filter,
+		policy: policy,
+		events: make(chan core.EscalationEvent, subscriberBuffer),
+		done:   make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Publish persists the event, assigning its id, and delivers it to every
+// matching subscriber without blocking. Publishers are serialized, so that
+// subscribers receive the events of this process in id order.
+func (h *Hub) Publish(event core.EscalationEvent) core.EscalationEvent {
+	h.publishMu.Lock()
+	defer h.publishMu.Unlock()
+	if h.repo != nil {
+		saved, err := h.repo.SaveEscalationEvent(event)
+		if err != nil {
+			// Still deliver live; the event just can't be replayed
+			log.Printf("Failed to persist escalation event: %v", err)
+		} else {
+			event = *saved
+		}
+	} else {
+		h.nextID++
+		event.ID = h.nextID
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if !s.filter.Matches(event) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			if s.policy == DisconnectSlow {
+				delete(h.subs, s)
+				s.end(ErrSlowSubscriber)
+				continue
+			}
+			s.dropped.Add(1)
+		}
+	}
+	return event
+}
+
+// Replay calls fn for every persisted event after afterID matching filter,
+// oldest first, and returns the id of the last event read
+func (h *Hub) Replay(filter core.EscalationFilter, afterID int64, fn func(core.EscalationEvent) error) (int64, error) {
+	if h.repo == nil {
+		return afterID, nil
+	}
+	for {
+		batch, err := h.repo.GetEscalationEventsAfter(afterID, replayBatch)
+		if err != nil {
+			return afterID, err
+		}
+		for _, event := range batch {
+			afterID = event.ID
+			if !filter.Matches(event) {
+				continue
+			}
+			if err := fn(event); err != nil {
+				return afterID, err
+			}
+		}
+		if len(batch) < replayBatch {
+			return afterID, nil
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package events
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+)
+
+func TestConcurrentEventsDeliveredInIDOrder(t *testing.T) {
+	h := NewHub(nil)
+	sub := h.Subscribe(core.EscalationFilter{}, DisconnectSlow)
+	defer sub.Close()
+
+	const publishers, each = 8, subscriberBuffer / 8
+	var wg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				h.Publish(core.EscalationEvent{SessionID: "s"})
+			}
+		}()
+	}
+	wg.Wait()
+
+	var last int64
+	for i := 0; i < publishers*each; i++ {
+		event := <-sub.Events()
+		if event.ID <= last {
+			t.Fatalf("Expected events in id order, got %d after %d", event.ID, last)
+		}
+		last = event.ID
+	}
+}
@@ -0,0 +1,261 @@
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/cluster"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/engine"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/workers"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testNode is a replica served in memory
+type testNode struct {
+	member  cluster.Member
+	cluster *cluster.Cluster
+	server  *ConversationServer
+	client  conversationv1.ConversationStreamClient
+}
+
+// testCluster starts replicas with the given ids, each seeing only itself
+// until updated
+func testCluster(t *testing.T, ids ...string) map[string]*testNode {
+	t.Helper()
+	listeners := make(map[string]*bufconn.Listener)
+	dialer := grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		lis, ok := listeners[addr]
+		if !ok {
+			return nil, fmt.Errorf("unknown node %s", addr)
+		}
+		return lis.DialContext(ctx)
+	})
+
+	nodes := make(map[string]*testNode)
+	for _, id := range ids {
+		lis := bufconn.Listen(1 << 20)
+		listeners[id] = lis
+
+		eng := engine.NewEngine(nil, nil)
+		reorder := engine.DefaultReorderConfig()
+		// A chunk held for sequences processed elsewhere fails the test
+		reorder.MaxWait = time.Minute
+		eng.SetReorder(reorder)
+		server := NewConversationServer(eng, nil, workers.DefaultConfig())
+		member := cluster.Member{ID: id, Addr: "passthrough:///" + id}
+		c := cluster.New(member, cluster.Static(nil))
+		server.SetCluster(c, dialer)
+
+		grpcServer := grpc.NewServer()
+		conversationv1.RegisterConversationStreamServer(grpcServer, server)
+		go grpcServer.Serve(lis)
+		t.Cleanup(grpcServer.Stop)
+
+		conn, err := grpc.NewClient(member.Addr, dialer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatalf("Failed to connect to %s: %v", id, err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		nodes[id] = &testNode{
+			member:  member,
+			cluster: c,
+			server:  server,
+			client:  conversationv1.NewConversationStreamClient(conn),
+		}
+	}
+	return nodes
+}
+
+// join makes the given replicas see each other
+func join(nodes map[string]*testNode, ids ...string) {
+	var members []cluster.Member
+	for _, id := range ids {
+		members = append(members, nodes[id].member)
+	}
+	for _, id := range ids {
+		nodes[id].cluster.Update(members)
+	}
+}
+
+func ingest(t *testing.T, node *testNode, sessionID string, seq uint64) *conversationv1.ChunkAck {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ir, err := node.client.IngestChunk(ctx, &conversationv1.IngestRequest{
+		Chunk: &conversationv1.ConversationChunk{
+			SessionId: sessionID,
+			MessageId: fmt.Sprintf("%s-%d", sessionID, seq),
+			Sequence:  seq,
+			Text:      "hello there",
+			Metadata:  map[string]string{engine.MetadataKeepOpen: "true"},
+		},
+		Wait: true,
+	})
+	if err != nil {
+		t.Fatalf("IngestChunk(%s, %d) on %s: %v", sessionID, seq, node.member.ID, err)
+	}
+	return ir.Ack
+}
+
+// holders returns the replicas with the session in memory
+func holders(nodes map[string]*testNode, sessionID string) []string {
+	var ids []string
+	for id, node := range nodes {
+		if slices.Contains(node.server.engine.SessionIDs(), sessionID) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestClusterForwardsToOwner(t *testing.T) {
+	nodes := testCluster(t, "a", "b", "c")
+	join(nodes, "a", "b", "c")
+
+	for i := range 20 {
+		sessionID := fmt.Sprintf("session-%d", i)
+		owner := nodes["a"].cluster.Owner(sessionID).ID
+		// Every chunk lands on another replica
+		for seq := uint64(1); seq <= 3; seq++ {
+			entry := []string{"a", "b", "c"}[(i+int(seq))%3]
+			ack := ingest(t, nodes[entry], sessionID, seq)
+			if !ack.Success || ack.Duplicate || ack.LastProcessedSequence != seq {
+				t.Fatalf("%s seq %d through %s: unexpected ack %+v", sessionID, seq, entry, ack)
+			}
+		}
+		if got := holders(nodes, sessionID); !slices.Equal(got, []string{owner}) {
+			t.Errorf("%s: expected only on its owner %s, found on %v", sessionID, owner, got)
+		}
+
+		resp, err := nodes["a"].client.ResumeFrom(context.Background(), &conversationv1.ResumeRequest{SessionId: sessionID})
+		if err != nil || !resp.Live || resp.LastProcessedSequence != 3 {
+			t.Errorf("%s: unexpected resume %+v, %v", sessionID, resp, err)
+		}
+	}
+}
+
+func TestClusterHandsOffOnMembershipChange(t *testing.T) {
+	nodes := testCluster(t, "a", "b", "c")
+	join(nodes, "a", "b")
+
+	var sessions []string
+	for i := range 30 {
+		sessionID := fmt.Sprintf("session-%d", i)
+		sessions = append(sessions, sessionID)
+		for seq := uint64(1); seq <= 2; seq++ {
+			ingest(t, nodes["a"], sessionID, seq)
+		}
+	}
+
+	// c joins and takes over its share of the sessions
+	join(nodes, "a", "b", "c")
+	moved := 0
+	for _, sessionID := range sessions {
+		owner := nodes["a"].cluster.Owner(sessionID).ID
+		if owner == "c" {
+			moved++
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for !slices.Equal(holders(nodes, sessionID), []string{owner}) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: expected only on its owner %s, found on %v", sessionID, owner, holders(nodes, sessionID))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		// The owner carries on from the sequences processed before
+		ack := ingest(t, nodes["b"], sessionID, 3)
+		if !ack.Success || ack.Duplicate || ack.LastProcessedSequence != 3 {
+			t.Fatalf("%s: unexpected ack after the handoff %+v", sessionID, ack)
+		}
+		if ack := ingest(t, nodes["c"], sessionID, 2); !ack.Duplicate {
+			t.Errorf("%s: expected a replayed chunk to be a duplicate, got %+v", sessionID, ack)
+		}
+	}
+	if moved == 0 {
+		t.Fatal("Expected some sessions to move to the new replica")
+	}
+
+	// c leaves and its sessions go back to a and b
+	nodes["c"].cluster.Leave(context.Background())
+	join(nodes, "a", "b")
+	for _, sessionID := range sessions {
+		owner := nodes["a"].cluster.Owner(sessionID).ID
+		deadline := time.Now().Add(5 * time.Second)
+		for !slices.Equal(holders(nodes, sessionID), []string{owner}) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: expected only on its owner %s, found on %v", sessionID, owner, holders(nodes, sessionID))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		resp, err := nodes["c"].client.ResumeFrom(context.Background(), &conversationv1.ResumeRequest{SessionId: sessionID})
+		if err != nil || resp.LastProcessedSequence != 3 {
+			t.Errorf("%s: unexpected resume %+v, %v", sessionID, resp, err)
+		}
+	}
+}
+
+func TestSlowReplicaOnlyHoldsUpItsSessions(t *testing.T) {
+	nodes := testCluster(t, "a", "b", "c")
+	join(nodes, "a", "b", "c")
+
+	// b takes no chunk until the end of the test
+	if err := nodes["b"].server.workerPool.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+	gate := make(chan struct{})
+	nodes["b"].server.workerPool.Dispatch("blocker", func() error {
+		<-gate
+		return nil
+	})
+	t.Cleanup(func() { close(gate) })
+
+	owned := func(owner string, n int) []string {
+		var sessions []string
+		for i := 0; len(sessions) < n; i++ {
+			sessionID := fmt.Sprintf("session-%d", i)
+			if nodes["a"].cluster.Owner(sessionID).ID == owner {
+				sessions = append(sessions, sessionID)
+			}
+		}
+		return sessions
+	}
+
+	acks := make(chan *conversationv1.ChunkAck, forwardWorkers)
+	for _, sessionID := range owned("b", forwardWorkers) {
+		go func() {
+			ir, err := nodes["a"].client.IngestChunk(context.Background(), &conversationv1.IngestRequest{
+				Chunk: &conversationv1.ConversationChunk{SessionId: sessionID, Sequence: 1, Text: "hello there"},
+				Wait:  true,
+			})
+			if err != nil {
+				t.Errorf("IngestChunk(%s): %v", sessionID, err)
+			}
+			acks <- ir.GetAck()
+		}()
+	}
+
+	start := time.Now()
+	for _, sessionID := range owned("c", 3) {
+		if ack := ingest(t, nodes["a"], sessionID, 1); !ack.Success {
+			t.Errorf("%s: expected the chunk to be processed by c, got %+v", sessionID, ack)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= forwardChunkTimeout {
+		t.Errorf("Expected the sessions of c not to wait for b, took %v", elapsed)
+	}
+
+	for range forwardWorkers {
+		if ack := <-acks; ack.Success {
+			t.Errorf("Expected the chunks for b to fail after the forward deadline, got %+v", ack)
+		}
+	}
+}
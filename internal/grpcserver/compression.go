@@ -0,0 +1,24 @@
+package grpcserver
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip" // accept gzip compressed requests
+)
+
+// SendCompression compresses the responses and stream messages of every RPC
+// with the named compressor, e.g. "gzip", for the clients that accept it;
+// the others get them uncompressed
+func SendCompression(name string) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			grpc.SetSendCompressor(ctx, name)
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			grpc.SetSendCompressor(ss.Context(), name)
+			return handler(srv, ss)
+		}),
+	}
+}
@@ -0,0 +1,194 @@
+package grpcserver
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/engine"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// eventBuffer bounds the events waiting to be sent on a Converse stream; a
+// client that lets it fill up is disconnected rather than blocking the
+// workers, which serve other sessions too
+const eventBuffer = 64
+
+// Converse processes chunks like StreamConversation and streams back an ack
+// for every chunk and an EscalationEvent for every escalation it started.
+// Chunks are processed by the session workers, so events of different sessions
+// may interleave; events of one session are in chunk order. A client that
+// doesn't read its events fast enough is disconnected with ResourceExhausted
+// and resumes like a StreamConversation producer.
+func (s *ConversationServer) Converse(stream conversationv1.ConversationStream_ConverseServer) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+	log.Println("Converse stream started")
+
+	// grpc streams don't support concurrent Send, so workers hand their events
+	// to a single sender
+	events := make(chan *conversationv1.ConversationEvent, eventBuffer)
+	sendErr := make(chan error, 1)
+	go func() {
+		var err error
+		for event := range events {
+			if err == nil {
+				err = stream.Send(event)
+			}
+			// Keep draining after a failure so that workers never block
+		}
+		sendErr <- err
+	}()
+
+	// slow is closed once an event didn't fit in the buffer; the events of
+	// the stream are dropped from then on
+	slow := make(chan struct{})
+	var slowOnce sync.Once
+	emit := func(event *conversationv1.ConversationEvent) {
+		select {
+		case events <- event:
+		default:
+			slowOnce.Do(func() { close(slow) })
+		}
+	}
+
+	var pending sync.WaitGroup
+	open := make(map[string]bool)
+	finish := func() error {
+		pending.Wait()
+		close(events)
+		return <-sendErr
+	}
+
+	chunks := receive(stream.Context(), stream.Recv)
+	for {
+		var r received[*conversationv1.ConversationChunk]
+		select {
+		case <-s.draining:
+			// The acks of the chunks received so far are still sent
+			if err := finish(); err != nil {
+				return err
+			}
+			return s.cut()
+		case <-slow:
+			log.Println("Converse client fell behind reading events")
+			finish()
+			return status.Error(codes.ResourceExhausted, "client fell behind reading events")
+		case r = <-chunks:
+		}
+		chunk, err := r.msg, r.err
+		receivedAt := time.Now()
+		if err == io.EOF {
+			s.endSessions(open, engine.EndReasonStreamClosed)
+			return finish()
+		}
+		if err != nil {
+			log.Println("stream recv error:", err)
+			finish()
+			return err
+		}
+
+		if reason := rejectInterim(chunk); reason != "" {
+			// Acked as failed like an invalid chunk of an ingest batch
+			emit(&conversationv1.ConversationEvent{Event: &conversationv1.ConversationEvent_Ack{Ack: &conversationv1.ChunkAck{
+				SessionId: chunk.SessionId,
+				ClientId:  chunk.ClientId,
+				Sequence:  chunk.Sequence,
+				Message:   reason,
+				Invalid:   true,
+			}}})
+			continue
+		}
+
+		open[chunk.SessionId] = chunk.Metadata[engine.MetadataEndOfSession] != "true" &&
+			chunk.Metadata[engine.MetadataKeepOpen] != "true"
+
+		pending.Add(1)
+		done := func(result engine.Result) {
+			defer pending.Done()
+			for _, event := range s.resultEvents(chunk, result) {
+				emit(event)
+			}
+		}
+		if err := s.submit(chunk, receivedAt, done); err != nil {
+			// Rejected chunks are acked as such and the stream goes on
+			done(engine.Result{Rejected: true})
+		}
+	}
+}
+
+// resultEvents converts the outcome of a chunk into stream events: one event
+// per started escalation, followed by the ack of the chunk
+func (s *ConversationServer) resultEvents(chunk *conversationv1.ConversationChunk, result engine.Result) []*conversationv1.ConversationEvent {
+	var events []*conversationv1.ConversationEvent
+	for _, event := range result.Events {
+		events = append(events, &conversationv1.ConversationEvent{
+			Event: &conversationv1.ConversationEvent_Escalation{Escalation: toProtoEvent(event)},
+		})
+	}
+
+	ack := &conversationv1.ChunkAck{
+		SessionId:             chunk.SessionId,
+		MessageId:             chunk.MessageId,
+		Success:               !result.Rejected && result.Err == nil,
+		Message:               ackMessage(result),
+		ClientId:              chunk.ClientId,
+		Sequence:              chunk.Sequence,
+		LastProcessedSequence: result.LastProcessed,
+		Duplicate:             result.Duplicate,
+		Degraded:              result.Degraded,
+		Rejected:              result.Rejected,
+	}
+	s.setLatency(ack, result.Timings)
+	return append(events, &conversationv1.ConversationEvent{
+		Event: &conversationv1.ConversationEvent_Ack{Ack: ack},
+	})
+}
+
+// ackMessage describes what happened to a chunk
+func ackMessage(result engine.Result) string {
+	switch {
+	case result.Rejected:
+		return "Rejected, server overloaded"
+	case result.Err != nil:
+		return "Failed: " + result.Err.Error()
+	case result.Duplicate:
+		return "Already processed"
+	case result.Stale:
+		return "Interim superseded"
+	case result.Dropped:
+		return "Late, dropped"
+	case result.Late:
+		return "Processed late"
+	case result.Ended:
+		return "Processed, session ended"
+	case result.Interim:
+		return "Evaluated interim"
+	case result.Degraded:
+		return "Processed, session degraded"
+	}
+	return "Processed"
+}
+
+func toProtoEvent(event core.EscalationEvent) *conversationv1.EscalationEvent {
+	return &conversationv1.EscalationEvent{
+		SessionId:    event.SessionID,
+		MessageId:    event.MessageID,
+		RuleId:       event.RuleID,
+		RuleName:     event.RuleName,
+		Action:       event.Action,
+		Score:        event.Score,
+		TraceId:      event.TraceID,
+		EscalationId: event.EscalationID,
+		TimestampMs:  event.Timestamp,
+		ClientId:     event.ClientID,
+		EventId:      event.ID,
+		Level:        int32(event.Level),
+	}
+}
@@ -0,0 +1,153 @@
+package grpcserver
+
+import (
+	"context"
+	"log"
+	"time"
+
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errDraining is returned to RPCs started after the shutdown began and to
+// streams still open when their grace period ends; producers resume with
+// ResumeFrom on another instance
+var errDraining = status.Error(codes.Unavailable, "server draining")
+
+// ShutdownSummary reports what a shutdown could not finish
+type ShutdownSummary struct {
+	StreamsCut    int // streams ended with errDraining
+	TasksDropped  int // queued tasks not run before the deadline
+	LiveSessions  int // sessions still in memory, left open for their producers
+	SessionsSaved int // sessions in the shutdown snapshot
+}
+
+// enter registers an RPC with the drain; it fails once the shutdown began.
+// Every successful enter must be followed by leave.
+func (s *ConversationServer) enter() error {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	if s.closing {
+		return errDraining
+	}
+	s.rpcs.Add(1)
+	return nil
+}
+
+func (s *ConversationServer) leave() {
+	s.rpcs.Done()
+}
+
+// isClosing reports whether the shutdown began
+func (s *ConversationServer) isClosing() bool {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	return s.closing
+}
+
+// cut ends a stream because the server is draining
+func (s *ConversationServer) cut() error {
+	s.drainMu.Lock()
+	s.streamsCut++
+	s.drainMu.Unlock()
+	return errDraining
+}
+
+// Shutdown drains the server. RPCs started from now on fail with UNAVAILABLE;
+// open streams get streamGrace to finish, after which they stop reading and
+// end with a "server draining" status once the chunks they received are
+// processed. Chunks held for reordering are then flushed and the worker
+// queues drained until ctx is done. Sessions are not ended, so that their
+// producers can resume them; in a cluster they are handed over to the other
+// replicas first, and with a snapshot store the others are saved once the
+// queues are drained.
+func (s *ConversationServer) Shutdown(ctx context.Context, streamGrace time.Duration) ShutdownSummary {
+	s.drainMu.Lock()
+	s.closing = true
+	s.drainMu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.rpcs.Wait()
+		close(finished)
+	}()
+	grace := time.NewTimer(streamGrace)
+	defer grace.Stop()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		close(s.draining)
+	case <-grace.C:
+		log.Println("Stream grace period over, ending open streams")
+		close(s.draining)
+		select {
+		case <-finished:
+		case <-ctx.Done():
+		}
+	}
+
+	if s.cluster != nil {
+		// The other replicas take over the sessions of this one from now on
+		if err := s.cluster.Leave(ctx); err != nil {
+			log.Printf("[cluster] failed to leave: %v", err)
+		}
+	}
+
+	for _, sessionID := range s.engine.SessionIDs() {
+		s.workerPool.Dispatch(sessionID, func() error {
+			return s.engine.FlushHeld(sessionID)
+		})
+	}
+	var exported <-chan *conversationv1.SessionState
+	if s.snapshots != nil {
+		exported, _ = s.exportSessions()
+	}
+
+	var summary ShutdownSummary
+	if s.peers != nil {
+		summary.TasksDropped += s.peers.shutdown(ctx)
+		defer s.peers.close()
+	}
+	summary.TasksDropped += s.workerPool.Shutdown(ctx)
+	if exported != nil {
+		saved, err := s.shutdownSnapshot(ctx, exported)
+		if err != nil {
+			log.Printf("failed to save snapshot: %v", err)
+		}
+		summary.SessionsSaved = saved
+	}
+	summary.LiveSessions = s.engine.LiveSessions()
+	s.drainMu.Lock()
+	summary.StreamsCut = s.streamsCut
+	s.drainMu.Unlock()
+	return summary
+}
+
+// received is a message read by receive
+type received[T any] struct {
+	msg T
+	err error
+}
+
+// receive reads a stream on its own goroutine so that a handler can stop
+// waiting for the client when the server drains. It stops after the first
+// error or once ctx is done.
+func receive[T any](ctx context.Context, recv func() (T, error)) <-chan received[T] {
+	ch := make(chan received[T])
+	go func() {
+		for {
+			msg, err := recv()
+			select {
+			case ch <- received[T]{msg, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
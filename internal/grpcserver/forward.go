@@ -0,0 +1,408 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/cluster"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/engine"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/workers"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// MetadataForwardedBy is the chunk metadata key with the id of the replica
+// that forwarded the chunk to its session owner. A forwarded chunk is
+// processed where it lands, so that replicas briefly disagreeing on the
+// members don't forward it back and forth.
+const MetadataForwardedBy = "forwarded_by"
+
+// forwardedHeader marks the session RPCs a replica forwards, for the same
+// reason
+const forwardedHeader = "x-forwarded-by"
+
+const (
+	// forwardWorkers is how many forwarding calls run at once per replica
+	forwardWorkers = 8
+	// forwardTimeout bounds a call to another replica
+	forwardTimeout = 10 * time.Second
+	// forwardChunkTimeout bounds forwarding a chunk, so that a slow owner
+	// fails its chunks quickly instead of queueing them up
+	forwardChunkTimeout = 2 * time.Second
+)
+
+// SetCluster spreads the sessions over the replicas of c: a chunk for a
+// session owned by another replica is forwarded to it, in order, and the
+// local sessions whose owner changes are handed over when the members change.
+// opts are added to the connections to the other replicas, which are
+// plaintext by default. It must be called before serving.
+//
+// Escalation subscribers only see the live events of the sessions owned by
+// the replica they are connected to; the others are replayed on resume.
+func (s *ConversationServer) SetCluster(c *cluster.Cluster, opts ...grpc.DialOption) {
+	s.cluster = c
+	s.peers = &peers{
+		opts:  opts,
+		conns: make(map[string]*grpc.ClientConn),
+		pools: make(map[string]*workers.WorkerPool),
+	}
+	c.OnChange(s.handOff)
+}
+
+// remoteOwner returns the owner of a session if it is another replica
+func (s *ConversationServer) remoteOwner(sessionID string) (cluster.Member, bool) {
+	if s.cluster == nil {
+		return cluster.Member{}, false
+	}
+	owner := s.cluster.Owner(sessionID)
+	return owner, owner.ID != "" && owner.ID != s.cluster.Self().ID
+}
+
+// route returns the replica to send a session RPC to, unless the call was
+// itself forwarded or the session is local
+func (s *ConversationServer) route(ctx context.Context, sessionID string) (cluster.Member, bool) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(forwardedHeader)) > 0 {
+		return cluster.Member{}, false
+	}
+	return s.remoteOwner(sessionID)
+}
+
+// forwarding returns a context for a call to another replica
+func (s *ConversationServer) forwarding(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, forwardTimeout)
+	return metadata.AppendToOutgoingContext(ctx, forwardedHeader, s.cluster.Self().ID), cancel
+}
+
+// forward sends a chunk to the replica owning its session. Chunks of a
+// session are forwarded one at a time and in order; done receives the result
+// of the owner. Each replica has its own forwarding workers, so a slow one
+// only holds up the sessions it owns; once its queues are full its chunks are
+// rejected.
+func (s *ConversationServer) forward(owner cluster.Member, chunk *conversationv1.ConversationChunk, priority workers.Priority, done func(engine.Result)) error {
+	fwd := proto.Clone(chunk).(*conversationv1.ConversationChunk)
+	fwd.Metadata = maps.Clone(chunk.Metadata)
+	if fwd.Metadata == nil {
+		fwd.Metadata = make(map[string]string)
+	}
+	fwd.Metadata[MetadataForwardedBy] = s.cluster.Self().ID
+
+	return s.peers.pool(owner.ID).Submit(chunk.SessionId, priority, func() error {
+		client, err := s.peers.client(owner.Addr)
+		if err != nil {
+			err = fmt.Errorf("failed to forward to %s: %w", owner.ID, err)
+			done(engine.Result{Err: err})
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), forwardChunkTimeout)
+		defer cancel()
+		ctx, cancel = s.forwarding(ctx)
+		defer cancel()
+		ir, err := client.IngestChunk(ctx, &conversationv1.IngestRequest{Chunk: fwd, Wait: true})
+		if status.Code(err) == codes.ResourceExhausted {
+			// The owner is overloaded
+			done(engine.Result{Rejected: true})
+			return nil
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to forward to %s: %w", owner.ID, err)
+			done(engine.Result{Err: err})
+			return err
+		}
+		done(remoteResult(ir))
+		return nil
+	}, func(error) {
+		done(engine.Result{Rejected: true})
+	})
+}
+
+// remoteResult converts the outcome of a forwarded chunk. Matched rules only
+// have their id.
+func remoteResult(ir *conversationv1.IngestResult) engine.Result {
+	ack := ir.GetAck()
+	result := engine.Result{
+		Duplicate:     ack.GetDuplicate(),
+		Rejected:      ack.GetRejected(),
+		Degraded:      ack.GetDegraded(),
+		LastProcessed: ack.GetLastProcessedSequence(),
+	}
+	if !ack.GetSuccess() && !ack.GetRejected() {
+		result.Err = errors.New(ack.GetMessage())
+	}
+	for _, id := range ir.MatchedRuleIds {
+		result.Decision.Matched = append(result.Decision.Matched, core.ParsedRule{Rule: core.Rule{ID: id}})
+	}
+	for _, id := range ir.ShadowRuleIds {
+		result.Decision.Shadow = append(result.Decision.Shadow, core.ParsedRule{Rule: core.Rule{ID: id}})
+	}
+	for _, event := range ir.Escalations {
+		result.Events = append(result.Events, fromProtoEvent(event))
+	}
+	return result
+}
+
+func fromProtoEvent(event *conversationv1.EscalationEvent) core.EscalationEvent {
+	return core.EscalationEvent{
+		ID:           event.EventId,
+		SessionID:    event.SessionId,
+		ClientID:     event.ClientId,
+		MessageID:    event.MessageId,
+		RuleID:       event.RuleId,
+		RuleName:     event.RuleName,
+		Action:       event.Action,
+		Score:        event.Score,
+		TraceID:      event.TraceId,
+		EscalationID: event.EscalationId,
+		Level:        int(event.Level),
+		Timestamp:    event.TimestampMs,
+	}
+}
+
+// callOwner runs call against the replica owning a session on the forwarding
+// worker of the session, after the chunks forwarded for it so far. The
+// returned channel receives the error of the call.
+func (s *ConversationServer) callOwner(ctx context.Context, owner cluster.Member, sessionID string, call func(context.Context, conversationv1.ConversationStreamClient) error) <-chan error {
+	errc := make(chan error, 1)
+	s.peers.pool(owner.ID).Dispatch(sessionID, func() error {
+		client, err := s.peers.client(owner.Addr)
+		if err == nil {
+			fctx, cancel := s.forwarding(ctx)
+			err = call(fctx, client)
+			cancel()
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to call %s: %w", owner.ID, err)
+		}
+		errc <- err
+		return err
+	})
+	return errc
+}
+
+// remoteLastProcessed asks the owner of a session for its progress, once the
+// chunks forwarded to it so far have been processed
+func (s *ConversationServer) remoteLastProcessed(ctx context.Context, owner cluster.Member, sessionID string) (uint64, bool, error) {
+	var resp *conversationv1.ResumeResponse
+	errc := s.callOwner(ctx, owner, sessionID, func(ctx context.Context, client conversationv1.ConversationStreamClient) (err error) {
+		resp, err = client.ResumeFrom(ctx, &conversationv1.ResumeRequest{SessionId: sessionID})
+		return err
+	})
+	select {
+	case <-ctx.Done():
+		return 0, false, ctx.Err()
+	case err := <-errc:
+		if err != nil {
+			return 0, false, err
+		}
+		return resp.LastProcessedSequence, resp.Live, nil
+	}
+}
+
+// endRemote ends a session on the replica owning it, after the chunks
+// forwarded for it so far
+func (s *ConversationServer) endRemote(owner cluster.Member, sessionID, reason string) {
+	s.callOwner(context.Background(), owner, sessionID, func(ctx context.Context, client conversationv1.ConversationStreamClient) error {
+		_, err := client.EndSession(ctx, &conversationv1.EndSessionRequest{SessionId: sessionID, Reason: reason})
+		return err
+	})
+}
+
+// EndSession ends a session on its owner, after the chunks already received
+// for it
+func (s *ConversationServer) EndSession(ctx context.Context, req *conversationv1.EndSessionRequest) (*conversationv1.EndSessionResponse, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = engine.EndReasonSignal
+	}
+
+	var ended bool
+	var errc <-chan error
+	if owner, ok := s.route(ctx, req.SessionId); ok {
+		errc = s.callOwner(ctx, owner, req.SessionId, func(ctx context.Context, client conversationv1.ConversationStreamClient) error {
+			resp, err := client.EndSession(ctx, &conversationv1.EndSessionRequest{SessionId: req.SessionId, Reason: reason})
+			if err == nil {
+				ended = resp.Ended
+			}
+			return err
+		})
+	} else {
+		ch := make(chan error, 1)
+		s.workerPool.Dispatch(req.SessionId, func() error {
+			ended = s.engine.EndSession(req.SessionId, reason)
+			ch <- nil
+			return nil
+		})
+		errc = ch
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case err := <-errc:
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to end session on its owner: %v", err)
+		}
+		return &conversationv1.EndSessionResponse{Ended: ended}, nil
+	}
+}
+
+// HandOff takes over a session from the replica that owned it before the
+// members changed
+func (s *ConversationServer) HandOff(ctx context.Context, req *conversationv1.HandOffRequest) (*conversationv1.HandOffResponse, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	state := req.GetSession()
+	if state.GetSessionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "session.session_id is required")
+	}
+
+	errc := make(chan error, 1)
+	s.workerPool.Dispatch(state.SessionId, func() error {
+		err := s.engine.Import(state)
+		errc <- err
+		return err
+	})
+	select {
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case err := <-errc:
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to import session: %v", err)
+		}
+	}
+	log.Printf("Took over session=%s from %s", state.SessionId, req.From)
+	return &conversationv1.HandOffResponse{}, nil
+}
+
+// handOff hands the local sessions now owned by other replicas over to them,
+// each through its worker after the chunks already queued for it
+func (s *ConversationServer) handOff() {
+	for _, sessionID := range s.engine.SessionIDs() {
+		if _, ok := s.remoteOwner(sessionID); ok {
+			s.workerPool.Dispatch(sessionID, func() error {
+				return s.handOffSession(sessionID)
+			})
+		}
+	}
+}
+
+// handOffSession sends a session to its owner and releases it. If the owner
+// can't take it, the session is kept and handed over on the next change.
+func (s *ConversationServer) handOffSession(sessionID string) error {
+	// The members may have changed again since the task was queued
+	owner, ok := s.remoteOwner(sessionID)
+	if !ok {
+		return nil
+	}
+	if err := s.engine.FlushHeld(sessionID); err != nil {
+		log.Printf("session=%s: held chunks failed before the handoff: %v", sessionID, err)
+	}
+	state, ok := s.engine.Export(sessionID)
+	if !ok {
+		return nil
+	}
+
+	client, err := s.peers.client(owner.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to hand off session %s to %s: %w", sessionID, owner.ID, err)
+	}
+	ctx, cancel := s.forwarding(context.Background())
+	defer cancel()
+	_, err = client.HandOff(ctx, &conversationv1.HandOffRequest{Session: state, From: s.cluster.Self().ID})
+	if err != nil {
+		return fmt.Errorf("failed to hand off session %s to %s: %w", sessionID, owner.ID, err)
+	}
+	s.engine.Release(sessionID)
+	log.Printf("Handed off session=%s to %s", sessionID, owner.ID)
+	return nil
+}
+
+// peers holds a connection per replica address and the forwarding workers
+// of each replica
+type peers struct {
+	opts []grpc.DialOption
+
+	mu     sync.Mutex
+	conns  map[string]*grpc.ClientConn
+	pools  map[string]*workers.WorkerPool // by member id
+	closed bool
+}
+
+// pool returns the forwarding workers of a replica
+func (p *peers) pool(id string) *workers.WorkerPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pool, ok := p.pools[id]
+	if !ok {
+		cfg := workers.DefaultConfig()
+		cfg.NumWorkers = forwardWorkers
+		cfg.Overflow = workers.OverflowReject
+		pool = workers.NewWorkerPool(cfg)
+		if p.closed {
+			// Refuses the tasks of a replica first seen while shutting down
+			pool.Stop()
+		}
+		p.pools[id] = pool
+	}
+	return pool
+}
+
+// shutdown stops the forwarding workers of every replica, see
+// workers.WorkerPool.Shutdown, and returns how many tasks were dropped
+func (p *peers) shutdown(ctx context.Context) int {
+	p.mu.Lock()
+	p.closed = true
+	pools := slices.Collect(maps.Values(p.pools))
+	p.mu.Unlock()
+
+	dropped := 0
+	for _, pool := range pools {
+		dropped += pool.Shutdown(ctx)
+	}
+	return dropped
+}
+
+func (p *peers) client(addr string) (conversationv1.ConversationStreamClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn, ok := p.conns[addr]
+	if !ok {
+		opts := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, p.opts...)
+		var err error
+		conn, err = grpc.NewClient(addr, opts...)
+		if err != nil {
+			return nil, err
+		}
+		p.conns[addr] = conn
+	}
+	return conversationv1.NewConversationStreamClient(conn), nil
+}
+
+func (p *peers) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, addr)
+	}
+}
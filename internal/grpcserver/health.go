@@ -0,0 +1,86 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthService is the service the server reports its health for, besides
+// the overall "" service
+var HealthService = conversationv1.ConversationStream_ServiceDesc.ServiceName
+
+// HealthCheck reports why a dependency is not ready, e.g. the database
+type HealthCheck func(ctx context.Context) error
+
+// ready returns why the server can't take chunks, or nil: it is draining, it
+// has no workers, every worker queue is full or a check fails
+func (s *ConversationServer) ready(ctx context.Context, checks map[string]HealthCheck) error {
+	if s.isClosing() {
+		return errors.New("draining")
+	}
+	stats := s.workerPool.Stats()
+	if len(stats) == 0 {
+		return errors.New("no workers")
+	}
+	full := true
+	for _, w := range stats {
+		if w.Depth < w.Capacity {
+			full = false
+			break
+		}
+	}
+	if full {
+		return errors.New("worker queues full")
+	}
+	for name, check := range checks {
+		if err := check(ctx); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ReportHealth sets the status of hs, overall and for HealthService, every
+// interval until ctx is done: SERVING while the server is ready to take
+// chunks, see ready, and NOT_SERVING otherwise. Each check gets up to
+// interval to answer.
+func (s *ConversationServer) ReportHealth(ctx context.Context, hs *health.Server, checks map[string]HealthCheck, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last error
+	first := true
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		err := s.ready(checkCtx, checks)
+		cancel()
+
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		hs.SetServingStatus("", status)
+		hs.SetServingStatus(HealthService, status)
+		if first || (err == nil) != (last == nil) {
+			if err != nil {
+				log.Printf("[health] not serving: %v", err)
+			} else {
+				log.Println("[health] serving")
+			}
+		}
+		first, last = false, err
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
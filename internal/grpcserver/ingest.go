@@ -0,0 +1,154 @@
+package grpcserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/engine"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxBatchChunks bounds the size of an IngestBatch request
+const maxBatchChunks = 500
+
+// IngestChunk queues a single chunk on its session worker. With wait it
+// returns the evaluation of the chunk. It fails with RESOURCE_EXHAUSTED when
+// the server is overloaded.
+func (s *ConversationServer) IngestChunk(ctx context.Context, req *conversationv1.IngestRequest) (*conversationv1.IngestResult, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	results, err := s.ingest(ctx, []*conversationv1.ConversationChunk{req.Chunk}, req.Wait, true)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// IngestBatch queues chunks in order; chunks of the same session are
+// processed in that order. Invalid chunks, and chunks the overloaded server
+// can't queue, are rejected individually.
+func (s *ConversationServer) IngestBatch(ctx context.Context, req *conversationv1.IngestBatchRequest) (*conversationv1.IngestBatchResponse, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	if len(req.Chunks) > maxBatchChunks {
+		return nil, status.Errorf(codes.InvalidArgument, "batch has %d chunks, at most %d are allowed", len(req.Chunks), maxBatchChunks)
+	}
+	results, err := s.ingest(ctx, req.Chunks, req.Wait, false)
+	if err != nil {
+		return nil, err
+	}
+	return &conversationv1.IngestBatchResponse{Results: results}, nil
+}
+
+// ingest queues the chunks and returns their results. A chunk the overloaded
+// server can't queue fails the call with failFast and is rejected in its
+// result otherwise.
+func (s *ConversationServer) ingest(ctx context.Context, chunks []*conversationv1.ConversationChunk, wait, failFast bool) ([]*conversationv1.IngestResult, error) {
+	receivedAt := time.Now()
+	results := make([]*conversationv1.IngestResult, len(chunks))
+	done := make([]chan engine.Result, len(chunks))
+	for i, chunk := range chunks {
+		if reason := rejectChunk(chunk); reason != "" {
+			results[i] = &conversationv1.IngestResult{Ack: &conversationv1.ChunkAck{
+				SessionId: chunk.GetSessionId(),
+				MessageId: chunk.GetMessageId(),
+				Message:   reason,
+				ClientId:  chunk.GetClientId(),
+				Invalid:   true,
+			}}
+			continue
+		}
+
+		ch := make(chan engine.Result, 1)
+		if err := s.submit(chunk, receivedAt, func(result engine.Result) { ch <- result }); err != nil {
+			if failFast {
+				return nil, overloaded(err)
+			}
+			results[i] = &conversationv1.IngestResult{Ack: &conversationv1.ChunkAck{
+				SessionId: chunk.SessionId,
+				MessageId: chunk.MessageId,
+				Message:   ackMessage(engine.Result{Rejected: true}),
+				ClientId:  chunk.ClientId,
+				Sequence:  chunk.Sequence,
+				Rejected:  true,
+			}}
+			continue
+		}
+		done[i] = ch
+		results[i] = &conversationv1.IngestResult{Ack: &conversationv1.ChunkAck{
+			SessionId: chunk.SessionId,
+			MessageId: chunk.MessageId,
+			Success:   true,
+			Message:   "Accepted",
+			ClientId:  chunk.ClientId,
+			Sequence:  chunk.Sequence,
+		}}
+	}
+	if !wait {
+		return results, nil
+	}
+
+	for i, ch := range done {
+		if ch == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			// The chunks stay queued and are still processed
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case result := <-ch:
+			fillResult(results[i], result)
+			s.setLatency(results[i].Ack, result.Timings)
+		}
+	}
+	return results, nil
+}
+
+// rejectChunk returns why a chunk can't be ingested, or "" if it can
+func rejectChunk(chunk *conversationv1.ConversationChunk) string {
+	switch {
+	case chunk == nil:
+		return "chunk is required"
+	case chunk.SessionId == "":
+		return "session_id is required"
+	case chunk.Text == "" && chunk.Metadata[engine.MetadataEndOfSession] != "true":
+		return "text is required"
+	}
+	return rejectInterim(chunk)
+}
+
+// rejectInterim returns why an interim chunk can't be evaluated, or "" if it
+// can: its revisions are tied together by its message id. Every entry point
+// checks it.
+func rejectInterim(chunk *conversationv1.ConversationChunk) string {
+	if core.IsInterim(chunk) && chunk.MessageId == "" {
+		return "message_id is required for interim chunks"
+	}
+	return ""
+}
+
+func fillResult(ir *conversationv1.IngestResult, result engine.Result) {
+	ir.Evaluated = !result.Rejected && result.Err == nil
+	ir.Ack.Success = !result.Rejected && result.Err == nil
+	ir.Ack.Degraded = result.Degraded
+	ir.Ack.LastProcessedSequence = result.LastProcessed
+	ir.Ack.Message = ackMessage(result)
+	ir.Ack.Duplicate = result.Duplicate
+	ir.Ack.Rejected = result.Rejected
+	for _, rule := range result.Decision.Matched {
+		ir.MatchedRuleIds = append(ir.MatchedRuleIds, rule.ID)
+	}
+	for _, rule := range result.Decision.Shadow {
+		ir.ShadowRuleIds = append(ir.ShadowRuleIds, rule.ID)
+	}
+	for _, event := range result.Events {
+		ir.Escalations = append(ir.Escalations, toProtoEvent(event))
+	}
+}
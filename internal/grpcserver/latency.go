@@ -0,0 +1,75 @@
+package grpcserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+// DefaultLatencyBudget is the time from a chunk's timestamp to its actions
+// beyond which the chunk is flagged
+const DefaultLatencyBudget = 300 * time.Millisecond
+
+// SetLatencyBudget changes the latency budget; 0 flags no chunk
+func (s *ConversationServer) SetLatencyBudget(budget time.Duration) {
+	s.latency.SetBudget(budget)
+}
+
+// SetLatencyWindow changes how long chunks count towards the latency stats
+func (s *ConversationServer) SetLatencyWindow(window time.Duration) {
+	s.latency.SetWindow(window)
+}
+
+// observe records the timings of an evaluated chunk and logs it with its
+// stages when it is over the budget
+func (s *ConversationServer) observe(chunk *conversationv1.ConversationChunk, timings core.ChunkTimings) {
+	if !s.latency.Observe(chunk.ClientId, timings) {
+		return
+	}
+	total, _ := timings.Total()
+	log.Printf("[latency] session=%s msg_id=%s client=%s took %v, over budget: %v",
+		chunk.SessionId, chunk.MessageId, chunk.ClientId, total, timings.Stages())
+}
+
+// overBudget reports whether an evaluated chunk exceeded the budget
+func (s *ConversationServer) overBudget(timings core.ChunkTimings) bool {
+	return !timings.Analyzed.IsZero() && s.latency.Over(timings)
+}
+
+// setLatency reports the latency of an evaluated chunk on its ack
+func (s *ConversationServer) setLatency(ack *conversationv1.ChunkAck, timings core.ChunkTimings) {
+	total, ok := timings.Total()
+	if !ok || timings.Analyzed.IsZero() {
+		return
+	}
+	ack.LatencyMs = uint32(total.Milliseconds())
+	ack.OverBudget = s.latency.Over(timings)
+}
+
+// LatencyHandler serves the latency percentiles per stage, overall and per
+// client, as JSON, e.g. on GET /latency, over the recent chunks (see
+// SetLatencyWindow). With ?client= only that client's latency is served.
+func (s *ConversationServer) LatencyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		stats := s.latency.Stats()
+		w.Header().Set("Content-Type", "application/json")
+		if clientID := r.URL.Query().Get("client"); clientID != "" {
+			client, ok := stats.Clients[clientID]
+			if !ok {
+				http.Error(w, "Client not found", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(client)
+			return
+		}
+		json.NewEncoder(w).Encode(stats)
+	})
+}
@@ -0,0 +1,75 @@
+package grpcserver
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/workers"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+// PriorityRules assign chunks the lane they queue in on their session worker
+type PriorityRules struct {
+	// Clients is the priority of the chunks of a client, e.g. high for
+	// premium tenants and low for bulk traffic; other clients are normal
+	Clients map[string]workers.Priority
+	// Elevated is the least priority of the chunks of sessions at risk or
+	// escalated
+	Elevated workers.Priority
+}
+
+func DefaultPriorityRules() PriorityRules {
+	return PriorityRules{Elevated: workers.PriorityHigh}
+}
+
+// ParseClientPriorities parses comma separated client=priority pairs, e.g.
+// "acme=high,bulkco=low"
+func ParseClientPriorities(s string) (map[string]workers.Priority, error) {
+	clients := make(map[string]workers.Priority)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		clientID, name, ok := strings.Cut(pair, "=")
+		if !ok || clientID == "" {
+			return nil, fmt.Errorf("expected client=priority, got %q", pair)
+		}
+		priority, err := workers.ParsePriority(name)
+		if err != nil {
+			return nil, fmt.Errorf("client %s: %w", clientID, err)
+		}
+		clients[clientID] = priority
+	}
+	return clients, nil
+}
+
+// SetPriorityRules changes how chunks are prioritized; it must be called
+// before serving
+func (s *ConversationServer) SetPriorityRules(rules PriorityRules) {
+	s.priorities = rules
+}
+
+// priority returns the lane of a chunk: its client's priority, lowered by the
+// priority in its metadata if any, raised to the elevated priority once its
+// session is at risk or escalated. Metadata is set by the sender, so it can
+// only yield to other traffic, never jump ahead of the client's lane.
+func (s *ConversationServer) priority(chunk *conversationv1.ConversationChunk) workers.Priority {
+	priority, ok := s.priorities.Clients[chunk.ClientId]
+	if !ok {
+		priority = workers.PriorityNormal
+	}
+	if v := chunk.Metadata[MetadataPriority]; v != "" {
+		p, err := workers.ParsePriority(v)
+		if err != nil {
+			log.Printf("session=%s: %v, using %s", chunk.SessionId, err, priority)
+		} else if p < priority {
+			priority = p
+		}
+	}
+	if priority < s.priorities.Elevated && s.engine.Elevated(chunk.SessionId) {
+		priority = s.priorities.Elevated
+	}
+	return priority
+}
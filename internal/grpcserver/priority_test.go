@@ -0,0 +1,46 @@
+package grpcserver
+
+import (
+	"testing"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/engine"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/workers"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+func TestChunkPriority(t *testing.T) {
+	clients, err := ParseClientPriorities("acme=high, bulkco=low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseClientPriorities("acme=urgent"); err == nil {
+		t.Error("Expected an unknown priority to be rejected")
+	}
+
+	s := NewConversationServer(engine.NewEngine(nil, nil), nil, workers.DefaultConfig())
+	defer s.workerPool.Stop()
+	s.SetPriorityRules(PriorityRules{Clients: clients, Elevated: workers.PriorityHigh})
+
+	tests := []struct {
+		client, metadata string
+		want             workers.Priority
+	}{
+		{"acme", "", workers.PriorityHigh},
+		{"bulkco", "", workers.PriorityLow},
+		{"other", "", workers.PriorityNormal},
+		{"acme", "low", workers.PriorityLow},
+		// Metadata can't raise the client's lane
+		{"bulkco", "high", workers.PriorityLow},
+		{"other", "high", workers.PriorityNormal},
+		{"other", "urgent", workers.PriorityNormal},
+	}
+	for _, tt := range tests {
+		chunk := &conversationv1.ConversationChunk{SessionId: "s", ClientId: tt.client}
+		if tt.metadata != "" {
+			chunk.Metadata = map[string]string{MetadataPriority: tt.metadata}
+		}
+		if got := s.priority(chunk); got != tt.want {
+			t.Errorf("client=%s metadata=%q: expected %v, got %v", tt.client, tt.metadata, tt.want, got)
+		}
+	}
+}
@@ -2,55 +2,307 @@ package grpcserver
 
 //nice
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/cluster"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/engine"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/events"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/snapshot"
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/workers"
 	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// MetadataPriority is the chunk metadata key with its priority, "low",
+// "normal" or "high"; it may only lower its client's (see PriorityRules). Higher
+// priority chunks are processed first and lower priority ones are shed first
+// when the server is overloaded.
+const MetadataPriority = "priority"
+
 type ConversationServer struct {
 	conversationv1.UnimplementedConversationStreamServer
 	workerPool *workers.WorkerPool
 	engine     *engine.Engine
+	hub        *events.Hub
+	latency    *core.LatencyRecorder
+	priorities PriorityRules
+
+	// Replicas, see SetCluster
+	cluster *cluster.Cluster
+	peers   *peers
+
+	// Snapshots, see SetSnapshotStore
+	snapshots  snapshot.Store
+	snapshotMu sync.Mutex // one snapshot is saved at a time
+
+	// Shutdown state, see Shutdown
+	drainMu    sync.Mutex
+	closing    bool           // new RPCs are refused
+	rpcs       sync.WaitGroup // RPCs in progress
+	draining   chan struct{}  // closed when open streams must end
+	streamsCut int
 }
 
-func NewConversationServer() *ConversationServer {
-	return &ConversationServer{
-		workerPool: workers.NewWorkerPool(8),
-		engine:     engine.NewEngine(),
+// NewConversationServer creates the server; hub serves SubscribeEscalations
+// and may be nil
+func NewConversationServer(eng *engine.Engine, hub *events.Hub, pool workers.Config) *ConversationServer {
+	s := &ConversationServer{
+		engine:     eng,
+		hub:        hub,
+		latency:    core.NewLatencyRecorder(DefaultLatencyBudget),
+		priorities: DefaultPriorityRules(),
+		draining:   make(chan struct{}),
 	}
+	pool.OnError = s.taskFailed
+	s.workerPool = workers.NewWorkerPool(pool)
+	// Held out-of-order chunks are flushed on their session's worker
+	eng.SetDispatcher(s.workerPool.Dispatch)
+	return s
 }
 
 func (s *ConversationServer) StreamConversation(stream conversationv1.ConversationStream_StreamConversationServer) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
 	log.Println("Stream started")
 
 	var lastSessionID string
 	var lastMsgID string
+	var lastClientID string
+	// Sessions to end when the stream closes
+	open := make(map[string]bool)
+	// The ack is sent once every received chunk has been processed
+	var pending sync.WaitGroup
+	// Chunks skipped as already processed, shed under load, that failed or
+	// that were over the latency budget; counted from the session workers
+	var duplicates, rejected, failed, overBudget atomic.Uint32
 
+	chunks := receive(stream.Context(), stream.Recv)
 	for {
-		chunk, err := stream.Recv()
+		var r received[*conversationv1.ConversationChunk]
+		select {
+		case <-s.draining:
+			// The producer resumes elsewhere from the last processed sequence
+			pending.Wait()
+			return s.cut()
+		case r = <-chunks:
+		}
+		chunk, err := r.msg, r.err
+		receivedAt := time.Now()
 		if err == io.EOF {
+			pending.Wait()
+			var processed uint64
+			if lastSessionID != "" {
+				processed, _, _ = s.lastProcessed(stream.Context(), lastSessionID)
+			}
+			s.endSessions(open, engine.EndReasonStreamClosed)
+
 			// Send ACK
-			return stream.SendAndClose(&conversationv1.AnalyticsAck{
-				SessionId:     lastSessionID,
-				LastMessageId: lastMsgID,
-				Success:       true,
-				Message:       "Processed all chunks",
-			})
+			ack := &conversationv1.AnalyticsAck{
+				SessionId:             lastSessionID,
+				LastMessageId:         lastMsgID,
+				Success:               true,
+				Message:               "Processed all chunks",
+				ClientId:              lastClientID,
+				LastProcessedSequence: processed,
+				Duplicates:            duplicates.Load(),
+				Rejected:              rejected.Load(),
+				Failed:                failed.Load(),
+				OverBudget:            overBudget.Load(),
+			}
+			switch {
+			case ack.Failed > 0:
+				ack.Success = false
+				ack.Message = fmt.Sprintf("%d chunks failed", ack.Failed)
+			case ack.Rejected > 0:
+				ack.Success = false
+				ack.Message = fmt.Sprintf("%d chunks rejected, server overloaded", ack.Rejected)
+			}
+			return stream.SendAndClose(ack)
 		}
 		if err != nil {
 			log.Println("stream recv error:", err)
 			return err
 		}
+		if reason := rejectInterim(chunk); reason != "" {
+			// The producer fixes the chunk and resumes; its open sessions are kept
+			pending.Wait()
+			return status.Errorf(codes.InvalidArgument, "session %s seq %d: %s", chunk.SessionId, chunk.Sequence, reason)
+		}
 
 		lastSessionID = chunk.SessionId
 		lastMsgID = chunk.MessageId
+		lastClientID = chunk.ClientId
+		open[chunk.SessionId] = chunk.Metadata[engine.MetadataEndOfSession] != "true" &&
+			chunk.Metadata[engine.MetadataKeepOpen] != "true"
 
 		// Dispatch to worker pool
-		s.workerPool.Dispatch(chunk.SessionId, func() {
-			s.engine.ProcessChunk(chunk)
+		pending.Add(1)
+		err = s.submit(chunk, receivedAt, func(result engine.Result) {
+			if s.overBudget(result.Timings) {
+				overBudget.Add(1)
+			}
+			switch {
+			case result.Duplicate:
+				duplicates.Add(1)
+			case result.Rejected:
+				rejected.Add(1)
+			case result.Err != nil:
+				failed.Add(1)
+			}
+			pending.Done()
+		})
+		if err != nil {
+			// The producer resumes once the server has caught up; its open
+			// sessions are kept
+			pending.Done()
+			return overloaded(err)
+		}
+	}
+}
+
+// submit queues a chunk received at received on its session worker with its
+// priority, see priority, or forwards it to the replica owning its session.
+// done receives the result, with the timings of the chunk, or a Rejected one
+// if the chunk is shed from the queue; it is not called when submit fails.
+func (s *ConversationServer) submit(chunk *conversationv1.ConversationChunk, received time.Time, done func(engine.Result)) error {
+	priority := s.priority(chunk)
+	if chunk.Metadata[MetadataForwardedBy] == "" {
+		if owner, ok := s.remoteOwner(chunk.SessionId); ok {
+			return s.forward(owner, chunk, priority, done)
+		}
+	}
+	timings := core.ChunkTimings{Received: received}
+	if chunk.TimestampMs > 0 {
+		timings.Produced = time.UnixMilli(chunk.TimestampMs)
+	}
+	timings.Enqueued = time.Now()
+	return s.workerPool.Submit(chunk.SessionId, priority, func() error {
+		started := time.Now()
+		return s.engine.Submit(chunk, func(result engine.Result) {
+			// Only evaluated chunks have a latency; skipped ones have no analysis
+			if !result.Timings.Analyzed.IsZero() {
+				result.Timings.Produced, result.Timings.Received = timings.Produced, timings.Received
+				result.Timings.Enqueued, result.Timings.Started = timings.Enqueued, started
+				s.observe(chunk, result.Timings)
+			}
+			done(result)
+		})
+	}, func(error) {
+		done(engine.Result{Rejected: true})
+	})
+}
+
+// taskFailed runs on the worker of a failed task. A panic may have left the
+// session half updated, so it is marked degraded and the chunks it was
+// processing are failed; errors returned by the engine are already in the
+// results of their chunks.
+func (s *ConversationServer) taskFailed(err *workers.TaskError) {
+	if err.Panicked() {
+		s.engine.Fail(err.SessionID, err.Err)
+	}
+}
+
+// overloaded converts a worker pool rejection into a gRPC status
+func overloaded(err error) error {
+	if errors.Is(err, workers.ErrStopped) {
+		return errDraining
+	}
+	return status.Errorf(codes.ResourceExhausted, "server overloaded: %v", err)
+}
+
+// ResumeFrom tells a reconnecting producer which sequences of the session were
+// processed, once the chunks already queued for it have been
+func (s *ConversationServer) ResumeFrom(ctx context.Context, req *conversationv1.ResumeRequest) (*conversationv1.ResumeResponse, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	seq, live, err := s.lastProcessed(ctx, req.SessionId)
+	if err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	log.Printf("Resume session=%s last_processed=%d live=%v", req.SessionId, seq, live)
+	return &conversationv1.ResumeResponse{
+		SessionId:             req.SessionId,
+		LastProcessedSequence: seq,
+		Live:                  live,
+	}, nil
+}
+
+// lastProcessed reads the session progress through its worker, once the
+// chunks already queued for it have been processed. The progress of a session
+// owned by another replica is asked to it.
+func (s *ConversationServer) lastProcessed(ctx context.Context, sessionID string) (uint64, bool, error) {
+	if owner, ok := s.route(ctx, sessionID); ok {
+		return s.remoteLastProcessed(ctx, owner, sessionID)
+	}
+	type progress struct {
+		seq  uint64
+		live bool
+	}
+	ch := make(chan progress, 1)
+	s.workerPool.Dispatch(sessionID, func() error {
+		seq, live := s.engine.LastProcessed(sessionID)
+		ch <- progress{seq, live}
+		return nil
+	})
+	select {
+	case <-ctx.Done():
+		return 0, false, ctx.Err()
+	case p := <-ch:
+		return p.seq, p.live, nil
+	}
+}
+
+// endSessions ends the sessions flagged in open through their workers, after
+// the chunks already queued for them
+func (s *ConversationServer) endSessions(open map[string]bool, reason string) {
+	for sessionID, end := range open {
+		if !end {
+			continue
+		}
+		if owner, ok := s.remoteOwner(sessionID); ok {
+			s.endRemote(owner, sessionID, reason)
+			continue
+		}
+		s.workerPool.Dispatch(sessionID, func() error {
+			s.engine.EndSession(sessionID, reason)
+			return nil
 		})
 	}
 }
+
+// SweepIdleSessions ends sessions that have not seen a chunk for ttl, checking
+// every ttl/4 until ctx is cancelled
+func (s *ConversationServer) SweepIdleSessions(ctx context.Context, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, sessionID := range s.engine.IdleSessions(ttl) {
+				s.workerPool.Dispatch(sessionID, func() error {
+					s.engine.EndIfIdle(sessionID, ttl)
+					return nil
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/snapshot"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+)
+
+// SetSnapshotStore saves the live sessions to store on shutdown, see
+// SnapshotSessions and Restore; it must be called before serving
+func (s *ConversationServer) SetSnapshotStore(store snapshot.Store) {
+	s.snapshots = store
+}
+
+// exportSessions dispatches the export of every live session on its worker,
+// after the chunks already queued for it. The states are sent on the
+// returned channel, nil for sessions that ended in the meantime, one per
+// session unless the task is dropped.
+func (s *ConversationServer) exportSessions() (<-chan *conversationv1.SessionState, int) {
+	sessionIDs := s.engine.SessionIDs()
+	ch := make(chan *conversationv1.SessionState, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		s.workerPool.Dispatch(sessionID, func() error {
+			state, _ := s.engine.Export(sessionID)
+			ch <- state
+			return nil
+		})
+	}
+	return ch, len(sessionIDs)
+}
+
+// Snapshot returns the state of the live sessions. Chunks held for reordering
+// are not part of it; their producers resend them on resume. The sessions
+// not exported by the time ctx is done are left out.
+func (s *ConversationServer) Snapshot(ctx context.Context) *conversationv1.SessionSnapshot {
+	snap := &conversationv1.SessionSnapshot{Version: snapshot.Version, TakenAtMs: time.Now().UnixMilli()}
+	ch, n := s.exportSessions()
+	for range n {
+		select {
+		case <-ctx.Done():
+			return snap
+		case state := <-ch:
+			if state != nil {
+				snap.Sessions = append(snap.Sessions, state)
+			}
+		}
+	}
+	return snap
+}
+
+// saveSnapshot encodes and saves a snapshot. Once the shutdown began, only
+// the shutdown snapshot is saved, so that a periodic one never replaces it.
+func (s *ConversationServer) saveSnapshot(ctx context.Context, snap *conversationv1.SessionSnapshot, final bool) error {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	if !final && s.isClosing() {
+		return nil
+	}
+
+	data, err := snapshot.Encode(snap)
+	if err != nil {
+		return err
+	}
+	return s.snapshots.Save(ctx, data)
+}
+
+// SnapshotSessions saves the live sessions to the snapshot store every
+// interval until ctx is cancelled
+func (s *ConversationServer) SnapshotSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.isClosing() {
+				// Shutdown saves the last snapshot
+				return
+			}
+			snap := s.Snapshot(ctx)
+			if err := s.saveSnapshot(ctx, snap, false); err != nil {
+				log.Printf("failed to save snapshot: %v", err)
+			}
+		}
+	}
+}
+
+// Restore loads the last snapshot from the snapshot store and takes over its
+// sessions, skipping those without a chunk for idleTTL, which would have
+// been ended. Sessions that fail to import are logged and left out. It must
+// be called before serving.
+func (s *ConversationServer) Restore(ctx context.Context, idleTTL time.Duration) (restored, skipped int, err error) {
+	data, err := s.snapshots.Load(ctx)
+	if errors.Is(err, snapshot.ErrNotFound) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	snap, err := snapshot.Decode(data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	errs := make(chan error, len(snap.Sessions))
+	pending := 0
+	for _, state := range snap.Sessions {
+		lastSeen := state.LastSeenMs
+		if lastSeen == 0 {
+			lastSeen = snap.TakenAtMs
+		}
+		if time.Since(time.UnixMilli(lastSeen)) >= idleTTL {
+			skipped++
+			continue
+		}
+		pending++
+		s.workerPool.Dispatch(state.SessionId, func() error {
+			err := s.engine.Import(state)
+			if err != nil {
+				log.Printf("session=%s: failed to restore: %v", state.SessionId, err)
+			}
+			errs <- err
+			return err
+		})
+	}
+	for range pending {
+		select {
+		case <-ctx.Done():
+			return restored, skipped, ctx.Err()
+		case err := <-errs:
+			if err == nil {
+				restored++
+			}
+		}
+	}
+	return restored, skipped, nil
+}
+
+// shutdownSnapshot saves the sessions exported by the drained worker queues
+func (s *ConversationServer) shutdownSnapshot(ctx context.Context, ch <-chan *conversationv1.SessionState) (int, error) {
+	snap := &conversationv1.SessionSnapshot{Version: snapshot.Version, TakenAtMs: time.Now().UnixMilli()}
+	// The queues are drained, so the exports that ran are all buffered
+	for len(ch) > 0 {
+		if state := <-ch; state != nil {
+			snap.Sessions = append(snap.Sessions, state)
+		}
+	}
+	if err := s.saveSnapshot(ctx, snap, true); err != nil {
+		return 0, err
+	}
+	return len(snap.Sessions), nil
+}
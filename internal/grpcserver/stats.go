@@ -0,0 +1,75 @@
+package grpcserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/cluster"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/workers"
+)
+
+// Stats is a snapshot of the processing metrics of the server
+type Stats struct {
+	Workers []workers.WorkerStats `json:"workers"`
+	Reorder core.ReorderStats     `json:"reorder"`
+
+	// DegradedSessions maps live sessions whose processing failed to why
+	DegradedSessions map[string]string `json:"degraded_sessions"`
+
+	// Cluster lists the replicas sharing the sessions, if any
+	Cluster []cluster.Member `json:"cluster,omitempty"`
+}
+
+func (s *ConversationServer) Stats() Stats {
+	stats := Stats{
+		Workers:          s.workerPool.Stats(),
+		Reorder:          s.engine.ReorderStats(),
+		DegradedSessions: s.engine.DegradedSessions(),
+	}
+	if s.cluster != nil {
+		stats.Cluster = s.cluster.Members()
+	}
+	return stats
+}
+
+// StatsHandler serves Stats as JSON, e.g. on GET /metrics
+func (s *ConversationServer) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Stats())
+	})
+}
+
+type workersRequest struct {
+	Workers int `json:"workers"`
+}
+
+// WorkersHandler reports the worker pool size on GET and resizes the pool on
+// PUT with {"workers": n}, without interrupting the sessions
+func (s *ConversationServer) WorkersHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req workersRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if err := s.workerPool.Resize(req.Workers); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(workersRequest{Workers: s.workerPool.Size()})
+	})
+}
@@ -0,0 +1,79 @@
+package grpcserver
+
+import (
+	"errors"
+	"log"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/events"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SubscribeEscalations streams the escalation events matching the request.
+// With after_event_id it first replays the persisted events after that id, so
+// a subscriber that disconnected can resume without gaps. Events published by
+// other processes (e.g. the Kafka consumer) are only seen through the replay.
+func (s *ConversationServer) SubscribeEscalations(req *conversationv1.SubscribeRequest, stream conversationv1.ConversationStream_SubscribeEscalationsServer) error {
+	if s.hub == nil {
+		return status.Error(codes.Unavailable, "escalation events are not available")
+	}
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+	filter := core.EscalationFilter{
+		ClientID:  req.ClientId,
+		SessionID: req.SessionId,
+		RuleID:    req.RuleId,
+		Action:    req.Action,
+	}
+	policy := events.DropSlow
+	if req.SlowPolicy == conversationv1.SlowSubscriberPolicy_SLOW_SUBSCRIBER_DISCONNECT {
+		policy = events.DisconnectSlow
+	}
+
+	// Subscribe before replaying so that nothing published in between is missed
+	sub := s.hub.Subscribe(filter, policy)
+	defer sub.Close()
+	log.Printf("Escalation subscriber started: %+v after=%d", filter, req.AfterEventId)
+
+	last := req.AfterEventId
+	if last > 0 {
+		var err error
+		last, err = s.hub.Replay(filter, last, func(event core.EscalationEvent) error {
+			return stream.Send(toProtoEvent(event))
+		})
+		if err != nil {
+			log.Printf("Failed to replay escalation events: %v", err)
+			return status.Error(codes.Internal, "failed to replay escalation events")
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.draining:
+			s.cut()
+			return status.Errorf(codes.Unavailable, "server draining, resume after event %d", last)
+		case <-sub.Done():
+			if errors.Is(sub.Err(), events.ErrSlowSubscriber) {
+				return status.Errorf(codes.ResourceExhausted, "subscriber fell behind, resume after event %d", last)
+			}
+			return nil
+		case event := <-sub.Events():
+			// Already sent by the replay; live events arrive in id order
+			if event.ID != 0 && event.ID <= last {
+				continue
+			}
+			if err := stream.Send(toProtoEvent(event)); err != nil {
+				return err
+			}
+			if event.ID != 0 {
+				last = event.ID
+			}
+		}
+	}
+}
@@ -10,10 +10,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 // ProducerAPI holds the gRPC client and DB connection.
@@ -75,48 +78,74 @@ func NewRouter() *gin.Engine {
 	})
 
 	r.POST("/api/v1/produce", api.ProduceHandler)
+	r.POST("/api/v1/produce/batch", api.ProduceBatchHandler)
 
 	return r
 }
 
 type ProduceRequest struct {
-	SessionID string `json:"session_id"`
-	Sender    string `json:"sender"`
-	Text      string `json:"text"`
+	SessionID    string `json:"session_id"`
+	ClientID     string `json:"client_id"`
+	MessageID    string `json:"message_id"` // optional; retries with the same id are processed once
+	Sender       string `json:"sender"`
+	Text         string `json:"text"`
+	EndOfSession bool   `json:"end_of_session"` // last message of the conversation
+	IsFinal      *bool  `json:"is_final"`       // false for interim transcripts, which need a message_id
+	Revision     uint32 `json:"revision"`       // revision of an interim transcript
+	Wait         bool   `json:"wait"`           // respond with the evaluation of the message
 }
 
-func (p *ProducerAPI) ProduceHandler(c *gin.Context) {
-	var req ProduceRequest
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
-		return
-	}
+type ProduceBatchRequest struct {
+	Messages []ProduceRequest `json:"messages"`
+	Wait     bool             `json:"wait"`
+}
 
-	// Open client‑streaming RPC (use HTTP request context)
-	ctx := c.Request.Context()
-	stream, err := p.grpcClient.StreamConversation(ctx)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open gRPC stream"})
-		return
+// toChunk builds the chunk of a REST message, generating a unique message ID
+// when the client didn't provide one
+func (req ProduceRequest) toChunk() *conversationv1.ConversationChunk {
+	now := time.Now()
+	messageID := req.MessageID
+	if messageID == "" {
+		messageID = uuid.NewString()
 	}
-
-	// Generate message ID
-	msgID := time.Now().Format("20060102150405.000000")
-
 	chunk := &conversationv1.ConversationChunk{
 		SessionId:   req.SessionID,
-		MessageId:   msgID,
+		MessageId:   messageID,
 		Sender:      req.Sender,
 		Text:        req.Text,
-		TimestampMs: time.Now().UnixMilli(),
+		TimestampMs: now.UnixMilli(),
+		ClientId:    req.ClientID,
+		IsFinal:     req.IsFinal,
+		Revision:    req.Revision,
 		Metadata: map[string]string{
 			"source": "rest-api",
 		},
 	}
+	if req.EndOfSession {
+		chunk.Metadata["end_of_session"] = "true"
+	}
+	return chunk
+}
+
+// ingestResponse is the JSON form of an IngestResult
+func ingestResponse(result *conversationv1.IngestResult) gin.H {
+	return gin.H{
+		"ack_session":   result.Ack.GetSessionId(),
+		"last_msg":      result.Ack.GetMessageId(),
+		"success":       result.Ack.GetSuccess(),
+		"message":       result.Ack.GetMessage(),
+		"duplicate":     result.Ack.GetDuplicate(),
+		"evaluated":     result.Evaluated,
+		"matched_rules": result.MatchedRuleIds,
+		"shadow_rules":  result.ShadowRuleIds,
+		"escalations":   result.Escalations,
+	}
+}
 
-	// Send to gRPC stream
-	if err := stream.Send(chunk); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send chunk to gRPC"})
+func (p *ProducerAPI) ProduceHandler(c *gin.Context) {
+	var req ProduceRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
 		return
 	}
 
@@ -131,16 +160,70 @@ func (p *ProducerAPI) ProduceHandler(c *gin.Context) {
 	//	// don’t block the response for DB failure, just log
 	//}
 
-	// Close stream and wait for ACK
-	ack, err := stream.CloseAndRecv()
+	result, err := p.grpcClient.IngestChunk(c.Request.Context(), &conversationv1.IngestRequest{
+		Chunk: req.toChunk(),
+		Wait:  req.Wait,
+	})
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.ResourceExhausted:
+		// The engine is overloaded; the client should retry with the same message_id
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "server overloaded, retry later"})
+		return
+	case codes.Unavailable:
+		// The server is draining or down; another replica may take the retry
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server unavailable, retry later"})
+		return
+	default:
+		log.Printf("failed to ingest chunk: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to ingest chunk"})
+		return
+	}
+	if result.Ack.GetRejected() {
+		c.Header("Retry-After", "1")
+	}
+	c.JSON(ackStatus(result.Ack), ingestResponse(result))
+}
+
+// ackStatus returns the HTTP status for the ack of an ingested chunk
+func ackStatus(ack *conversationv1.ChunkAck) int {
+	switch {
+	case ack.GetSuccess():
+		return http.StatusOK
+	case ack.GetInvalid():
+		return http.StatusBadRequest
+	case ack.GetRejected():
+		return http.StatusServiceUnavailable
+	}
+	// The engine failed to process the chunk, e.g. the database is down
+	return http.StatusInternalServerError
+}
+
+// ProduceBatchHandler ingests several messages in one call; each message is
+// accepted or rejected individually
+func (p *ProducerAPI) ProduceBatchHandler(c *gin.Context) {
+	var req ProduceBatchRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+
+	batch := &conversationv1.IngestBatchRequest{Wait: req.Wait}
+	for _, msg := range req.Messages {
+		batch.Chunks = append(batch.Chunks, msg.toChunk())
+	}
+	resp, err := p.grpcClient.IngestBatch(c.Request.Context(), batch)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to receive ack"})
+		log.Printf("failed to ingest batch: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to ingest batch"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"ack_session": ack.SessionId,
-		"last_msg":    ack.LastMessageId,
-		"success":     ack.Success,
-	})
+	results := make([]gin.H, 0, len(resp.Results))
+	for _, result := range resp.Results {
+		results = append(results, ingestResponse(result))
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
 }
@@ -2,12 +2,14 @@ package kafka
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/core"
 	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/escalation"
 	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
 	"github.com/segmentio/kafka-go"
 )
@@ -17,9 +19,11 @@ type Consumer struct {
 	analyzer *core.Analyzer
 	engine   *core.Engine
 	repo     *db.Repository
+	rules    *core.RuleCache
+	pipeline *escalation.Pipeline
 }
 
-func NewConsumer(brokers []string, topic string, groupID string, repo *db.Repository) *Consumer {
+func NewConsumer(brokers []string, topic string, groupID string, repo *db.Repository, escalations *escalation.Scheduler) *Consumer {
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:  brokers,
 		Topic:    topic,
@@ -33,66 +37,139 @@ func NewConsumer(brokers []string, topic string, groupID string, repo *db.Reposi
 		analyzer: core.NewAnalyzer(repo),
 		engine:   core.NewEngine(),
 		repo:     repo,
+		rules:    core.NewRuleCache(repo, core.DefaultRuleTTL),
+		pipeline: escalation.NewPipeline(repo, escalations),
 	}
 }
 
+// SetClassifier enables intent conditions by classifying every message
+func (c *Consumer) SetClassifier(classifier *core.Classifier) {
+	c.analyzer.SetClassifier(classifier)
+}
+
+// Start consumes messages until ctx is cancelled. A message is committed once
+// it has been processed; a message that fails is retried, without committing,
+// until it succeeds or ctx is cancelled, so cancelling lets the current message
+// finish and a message interrupted by a crash or a failure is redelivered.
+// Redeliveries of processed messages are recognised by their offset.
 func (c *Consumer) Start(ctx context.Context) error {
 	defer c.reader.Close()
 
 	log.Println("Kafka consumer started...")
 
 	for {
-		m, err := c.reader.ReadMessage(ctx)
+		m, err := c.reader.FetchMessage(ctx)
+		if errors.Is(err, context.Canceled) {
+			log.Println("Kafka consumer stopped")
+			return nil
+		}
 		if err != nil {
 			return err
 		}
 
-		text := string(m.Value)
-		log.Printf("Received message: %s", text)
-
-		// Save message to DB
-		// Assuming conversation_id is part of the key or we use a default for now.
-		// In a real system, the message value would likely be a JSON struct containing conversation_id.
-		conversationID := "default_conversation"
-		if len(m.Key) > 0 {
-			conversationID = string(m.Key)
-		}
-		if err := c.repo.SaveMessage(conversationID, text, m.Time.UnixMilli()); err != nil {
-			log.Printf("Failed to save message: %v", err)
+		// Offsets are committed in order, so a failed message blocks its
+		// partition instead of being committed past
+		for attempt := 1; ; attempt++ {
+			err := c.handle(m)
+			if err == nil {
+				break
+			}
+			log.Printf("Failed to process message at offset %d (attempt %d): %v", m.Offset, attempt, err)
+			select {
+			case <-ctx.Done():
+				log.Println("Kafka consumer stopped")
+				return nil
+			case <-time.After(retryDelay(attempt)):
+			}
 		}
 
-		// 1. Analyze
-		chunk := &conversationv1.ConversationChunk{
-			SessionId:   "",
-			MessageId:   "",
-			Sender:      "",
-			Text:        text,
-			TimestampMs: time.Now().UnixMilli(),
-			Metadata: map[string]string{
-				"source": "rest-api",
-			},
-		}
-
-		analysis := c.analyzer.Analyze(chunk)
-
-		// 2. Fetch Rules (In a real system, cache this!)
-		rules, err := c.repo.GetAllRules()
+		// The commit outlives ctx so that the last message isn't redelivered
+		commitCtx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+		err = c.reader.CommitMessages(commitCtx, m)
+		cancel()
 		if err != nil {
-			log.Printf("Failed to fetch rules: %v", err)
-			continue
+			log.Printf("Failed to commit message at offset %d: %v", m.Offset, err)
 		}
+	}
+}
 
-		// 3. Evaluate
-		actions := c.engine.Evaluate(analysis, rules)
+// commitTimeout bounds committing the offset of a processed message
+const commitTimeout = 5 * time.Second
 
-		// 4. Trigger Actions
-		for _, action := range actions {
-			c.trigger(action, text)
-		}
+// maxRetryDelay bounds the wait between attempts at a failed message
+const maxRetryDelay = 30 * time.Second
+
+// retryDelay backs off exponentially from 500ms up to maxRetryDelay
+func retryDelay(attempt int) time.Duration {
+	d := 500 * time.Millisecond
+	for i := 1; i < attempt && d < maxRetryDelay; i++ {
+		d *= 2
 	}
+	return min(d, maxRetryDelay)
 }
 
-func (c *Consumer) trigger(action string, context string) {
-	// In a real system, this would call an external service or workflow engine
-	log.Printf("!!! ESCALATION TRIGGERED !!! Action: %s | Context: %s", strings.ToUpper(action), context)
+// handle evaluates a message and records it as processed once its actions
+// were applied, so that a message interrupted before then is evaluated again
+// when it is redelivered
+func (c *Consumer) handle(m kafka.Message) error {
+	text := string(m.Value)
+	log.Printf("Received message: %s", text)
+
+	// Assuming conversation_id is part of the key or we use a default for now.
+	// In a real system, the message value would likely be a JSON struct containing conversation_id.
+	conversationID := "default_conversation"
+	if len(m.Key) > 0 {
+		conversationID = string(m.Key)
+	}
+	// The partition offset identifies the message across redeliveries
+	messageID := fmt.Sprintf("kafka:%s:%d:%d", m.Topic, m.Partition, m.Offset)
+	processed, err := c.repo.MessageExists("", conversationID, messageID)
+	if err != nil {
+		return err
+	}
+	if processed {
+		log.Printf("Skipping redelivered message %s", messageID)
+		return nil
+	}
+
+	// 1. Analyze
+	chunk := &conversationv1.ConversationChunk{
+		SessionId:   conversationID,
+		MessageId:   messageID,
+		Sender:      "",
+		Text:        text,
+		TimestampMs: time.Now().UnixMilli(),
+		Metadata: map[string]string{
+			"source": "rest-api",
+		},
+	}
+
+	analysis := c.analyzer.AnalyzeChunk(chunk)
+
+	// 2. Fetch Rules
+	rules, err := c.rules.GetAllRules()
+	if err != nil {
+		return fmt.Errorf("failed to fetch rules: %w", err)
+	}
+	// Kafka messages carry no end-of-session signal, so only per-message rules apply
+	rules = core.RulesForTrigger(rules, core.TriggerMessage)
+
+	// 3. Evaluate. Unlike gRPC sessions and rule tests, the message is
+	// evaluated on its own, without the counts of earlier messages.
+	decision := c.engine.Decide(analysis, rules)
+
+	// 4. Record the decision, update the session and trigger actions
+	c.pipeline.Apply(escalation.Message{
+		SessionID: conversationID,
+		MessageID: messageID,
+		Text:      text,
+	}, decision, c.engine.AtRisk(analysis, rules))
+
+	// 5. Record the message as processed. The actions were already applied,
+	// so a failure here is not retried; the committed offset still keeps the
+	// message from being redelivered.
+	if _, err := c.repo.SaveMessage("", conversationID, messageID, text, m.Time.UnixMilli()); err != nil && !errors.Is(err, db.ErrDuplicate) {
+		log.Printf("Failed to save message: %v", err)
+	}
+	return nil
 }
@@ -0,0 +1,131 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/internal/db"
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Version is the version of the snapshot format written by Encode; Decode
+// accepts this version and older ones
+const Version = 1
+
+// magic starts every snapshot
+var magic = []byte("CSNP")
+
+// headerSize is the magic, the uint16 version and the crc32 of the payload
+const headerSize = 4 + 2 + 4
+
+// ErrNotFound is returned by Store.Load when no snapshot was saved yet
+var ErrNotFound = errors.New("no snapshot")
+
+// Encode serializes a snapshot: a header with the format version and a
+// checksum, followed by the SessionSnapshot protobuf
+func Encode(snap *conversationv1.SessionSnapshot) ([]byte, error) {
+	payload, err := proto.Marshal(snap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	data := make([]byte, headerSize, headerSize+len(payload))
+	copy(data, magic)
+	binary.BigEndian.PutUint16(data[4:], Version)
+	binary.BigEndian.PutUint32(data[6:], crc32.ChecksumIEEE(payload))
+	return append(data, payload...), nil
+}
+
+// Decode parses a snapshot written by Encode
+func Decode(data []byte) (*conversationv1.SessionSnapshot, error) {
+	if len(data) < headerSize || !bytes.Equal(data[:4], magic) {
+		return nil, errors.New("not a session snapshot")
+	}
+	if version := binary.BigEndian.Uint16(data[4:]); version > Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	payload := data[headerSize:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[6:]) {
+		return nil, errors.New("snapshot checksum mismatch")
+	}
+	snap := &conversationv1.SessionSnapshot{}
+	if err := proto.Unmarshal(payload, snap); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// Store keeps the last snapshot of a server
+type Store interface {
+	Save(ctx context.Context, data []byte) error
+	// Load returns the last saved snapshot, or ErrNotFound
+	Load(ctx context.Context) ([]byte, error)
+}
+
+// File stores the snapshot in a local file, replaced atomically
+type File string
+
+func (f File) Save(_ context.Context, data []byte) error {
+	path := string(f)
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+	// The rename must not expose a file whose content isn't on disk yet
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync snapshot file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close snapshot file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace snapshot file: %w", err)
+	}
+	return nil
+}
+
+func (f File) Load(context.Context) ([]byte, error) {
+	data, err := os.ReadFile(string(f))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot file: %w", err)
+	}
+	return data, nil
+}
+
+// Database stores the snapshot of a server in the database, keyed by its id
+type Database struct {
+	repo   *db.Repository
+	nodeID string
+}
+
+func NewDatabase(repo *db.Repository, nodeID string) *Database {
+	return &Database{repo: repo, nodeID: nodeID}
+}
+
+func (d *Database) Save(_ context.Context, data []byte) error {
+	return d.repo.SaveSnapshot(d.nodeID, data, time.Now().UnixMilli())
+}
+
+func (d *Database) Load(context.Context) ([]byte, error) {
+	data, err := d.repo.LoadSnapshot(d.nodeID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
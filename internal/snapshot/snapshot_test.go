@@ -0,0 +1,72 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	conversationv1 "github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	snap := &conversationv1.SessionSnapshot{
+		Version:   Version,
+		TakenAtMs: 1_700_000_000_000,
+		Sessions: []*conversationv1.SessionState{{
+			Version:               2,
+			SessionId:             "s1",
+			WordCounts:            map[string]int64{"refund": 2},
+			LastProcessedSequence: 7,
+			LastSeenMs:            1_699_999_999_000,
+		}},
+	}
+	data, err := Encode(snap)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !proto.Equal(got, snap) {
+		t.Errorf("Expected %v, got %v", snap, got)
+	}
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if _, err := Decode(corrupt); err == nil {
+		t.Error("Expected a corrupt snapshot to be refused")
+	}
+	newer := append([]byte(nil), data...)
+	newer[5] = Version + 1
+	if _, err := Decode(newer); err == nil {
+		t.Error("Expected a newer version to be refused")
+	}
+	if _, err := Decode([]byte("not a snapshot")); err == nil {
+		t.Error("Expected garbage to be refused")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store := File(filepath.Join(t.TempDir(), "sessions.snapshot"))
+	if _, err := store.Load(ctx); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound before the first save, got %v", err)
+	}
+
+	for _, data := range []string{"first", "second"} {
+		if err := store.Save(ctx, []byte(data)); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		got, err := store.Load(ctx)
+		if err != nil || string(got) != data {
+			t.Errorf("Expected %q, got %q, %v", data, got, err)
+		}
+	}
+	matches, _ := filepath.Glob(string(store) + ".*")
+	if len(matches) != 0 {
+		t.Errorf("Expected no temporary files left, got %v", matches)
+	}
+}
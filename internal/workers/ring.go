@@ -0,0 +1,71 @@
+package workers
+
+import (
+	"sort"
+	"strconv"
+)
+
+// ringReplicas is the number of points each worker has on the ring; more
+// points spread sessions more evenly
+const ringReplicas = 64
+
+// ring is a consistent-hash ring of worker ids. Adding or removing a worker
+// only moves the sessions hashing next to its points.
+type ring struct {
+	points []uint32 // sorted
+	owners []int    // worker id of each point
+}
+
+func newRing(ids []int) *ring {
+	type point struct {
+		hash  uint32
+		owner int
+	}
+	points := make([]point, 0, len(ids)*ringReplicas)
+	for _, id := range ids {
+		for i := 0; i < ringReplicas; i++ {
+			points = append(points, point{
+				hash:  ringHash("worker-" + strconv.Itoa(id) + "-" + strconv.Itoa(i)),
+				owner: id,
+			})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner < points[j].owner
+	})
+
+	r := &ring{
+		points: make([]uint32, len(points)),
+		owners: make([]int, len(points)),
+	}
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+// owner returns the worker of the first point at or after the key's hash
+func (r *ring) owner(key string) int {
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// ringHash is HashString with a final avalanche step, so that similar keys
+// such as the point names of a worker land far apart
+func ringHash(s string) uint32 {
+	h := HashString(s)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
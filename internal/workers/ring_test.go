@@ -0,0 +1,45 @@
+package workers
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingGrowOnlyMovesSessionsToNewWorker(t *testing.T) {
+	before, after := newRing([]int{0, 1, 2, 3}), newRing([]int{0, 1, 2, 3, 4})
+
+	const sessions = 10000
+	moved := 0
+	counts := make(map[int]int)
+	for i := 0; i < sessions; i++ {
+		sessionID := fmt.Sprintf("session-%d", i)
+		from, to := before.owner(sessionID), after.owner(sessionID)
+		counts[to]++
+		if from == to {
+			continue
+		}
+		moved++
+		if to != 4 {
+			t.Fatalf("Expected %s to stay on %d or move to the new worker, moved to %d", sessionID, from, to)
+		}
+	}
+	// About a fifth of the sessions should move
+	if moved < sessions/10 || moved > sessions*3/10 {
+		t.Errorf("Expected about %d sessions to move, %d did", sessions/5, moved)
+	}
+	for id, n := range counts {
+		if n < sessions/10 || n > sessions*3/10 {
+			t.Errorf("Expected worker %d to get about %d sessions, got %d", id, sessions/5, n)
+		}
+	}
+}
+
+func TestRingShrinkOnlyMovesSessionsOfRemovedWorker(t *testing.T) {
+	before, after := newRing([]int{0, 1, 2}), newRing([]int{0, 1})
+	for i := 0; i < 1000; i++ {
+		sessionID := fmt.Sprintf("session-%d", i)
+		if from, to := before.owner(sessionID), after.owner(sessionID); from != 2 && from != to {
+			t.Fatalf("Expected %s to stay on %d, moved to %d", sessionID, from, to)
+		}
+	}
+}
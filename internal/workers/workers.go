@@ -1,60 +1,851 @@
 package workers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"runtime/debug"
+	"sort"
 	"sync"
+	"time"
+)
+
+// Priority is the lane a task queues in: workers run higher lanes first, and
+// higher priorities survive when the pool sheds load
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+
+	// priorityControl tasks are queued with Dispatch; they are never
+	// rejected or shed
+	priorityControl
+)
+
+// numPriorities is the number of lanes, including the control lane
+const numPriorities = int(priorityControl) + 1
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	case priorityControl:
+		return "control"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// ParsePriority parses "low", "normal" or "high"; "" is normal
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "low":
+		return PriorityLow, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority %q", s)
+}
+
+// OverflowPolicy decides what Submit does when the worker's queue is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits up to Config.BlockTimeout for space
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowReject fails immediately
+	OverflowReject OverflowPolicy = "reject"
+	// OverflowShed evicts the newest queued task of the lowest priority if it
+	// is lower than the submitted one, and fails otherwise
+	OverflowShed OverflowPolicy = "shed"
+)
+
+// ParseOverflowPolicy validates an overflow policy name
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case OverflowBlock, OverflowReject, OverflowShed:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q", s)
+}
+
+var (
+	// ErrQueueFull is returned by Submit when the worker's queue has no space
+	ErrQueueFull = errors.New("worker queue is full")
+	// ErrShed is passed to the reject callback of a task evicted for a
+	// higher priority one
+	ErrShed = errors.New("task shed for higher priority work")
+	// ErrStopped is returned by Submit after Stop
+	ErrStopped = errors.New("worker pool is stopped")
 )
 
 type Task struct {
 	SessionID string
-	Payload   any
+	Priority  Priority
+	Run       func() error
+
+	// Reject is called instead of running the task if it is shed
+	Reject func(error)
+
+	queuedAt time.Time
 }
 
+// TaskError is a task that returned an error or panicked
+type TaskError struct {
+	SessionID string
+	Worker    int
+	Err       error
+	Stack     []byte // goroutine stack of a panic
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("task of session %s failed on worker %d: %v", e.SessionID, e.Worker, e.Err)
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// Panicked reports whether the task panicked
+func (e *TaskError) Panicked() bool {
+	return e.Stack != nil
+}
+
+// Config sizes the pool and sets its overload behaviour
+type Config struct {
+	NumWorkers    int
+	QueueCapacity int // tasks queued per worker
+	Overflow      OverflowPolicy
+	BlockTimeout  time.Duration // how long OverflowBlock waits for space
+
+	// MaxStarvation is how long a session may wait in a lane while higher
+	// lanes are served; it then runs next. 0 serves lanes strictly by priority.
+	MaxStarvation time.Duration
+
+	// OnError is called on the worker of a failed task, before the worker
+	// runs the session's next task; panics are recovered and reported too
+	OnError func(*TaskError)
+}
+
+func DefaultConfig() Config {
+	return Config{
+		NumWorkers:    8,
+		QueueCapacity: 100,
+		Overflow:      OverflowBlock,
+		BlockTimeout:  time.Second,
+		MaxStarvation: 500 * time.Millisecond,
+	}
+}
+
+// WorkerStats are the queue metrics of a worker
+type WorkerStats struct {
+	Worker    int            `json:"worker"`
+	Depth     int            `json:"depth"` // tasks currently queued
+	Lanes     map[string]int `json:"lanes"` // queued tasks per priority
+	Capacity  int            `json:"capacity"`
+	Processed int64          `json:"processed"`
+	Starved   int64          `json:"starved"`  // tasks run ahead of higher lanes after waiting MaxStarvation
+	Rejected  int64          `json:"rejected"` // submits that failed, including timed out ones
+	Shed      int64          `json:"shed"`
+	Failed    int64          `json:"failed"` // tasks that returned an error or panicked
+	Panics    int64          `json:"panics"`
+	AvgWaitMs float64        `json:"avg_wait_ms"` // time tasks spent queued
+	MaxWaitMs float64        `json:"max_wait_ms"`
+}
+
+// WorkerPool runs the tasks of a session in order on the worker the session
+// hashes to on a consistent-hash ring, so the pool can be resized while only
+// moving a share of the sessions. Each worker has a bounded queue; Submit
+// applies the pool's overflow policy when it is full.
+//
+// A worker queues the tasks of each session apart and serves its sessions
+// from one lane per priority, highest first and round robin within a lane. A
+// session sits in the lane of its highest priority queued task, so that a
+// high priority task isn't stuck behind the earlier tasks of its session,
+// which keep their order. A session that waited Config.MaxStarvation in a
+// lower lane is served next.
+//
+// Session state lives with its owner (the engine), not the workers; a
+// session's tasks just never run on two workers at once.
 type WorkerPool struct {
-	NumWorkers int
-	queues     map[int]chan Task
-	wg         sync.WaitGroup
+	cfg Config
+	wg  sync.WaitGroup
+
+	resizeMu sync.Mutex // serializes Resize
+
+	mu      sync.RWMutex // guards ring and workers; held for writing while sessions move
+	ring    *ring
+	workers map[int]*worker
+	nextID  int
 }
 
-func NewWorkerPool(n int) *WorkerPool {
+type worker struct {
+	id       int
+	capacity int
+
+	mu      sync.Mutex
+	queues  map[string]*sessionQueue // queued tasks by session
+	lanes   [numPriorities][]string  // sessions with queued tasks by lane, in serving order
+	depth   int                      // queued tasks
+	wake    chan struct{}            // signals the worker that tasks were queued
+	space   chan struct{}            // signals a submitter waiting for space that a task left the queue
+	idle    *sync.Cond               // signalled when a task finishes
+	busy    bool                     // a task is running
+	running string                   // session of the running task
+	paused  bool                     // don't start tasks, see Resize
+	stopped bool
+
+	onError       func(*TaskError)
+	maxStarvation time.Duration
+
+	processed, rejected, shed, failed, panics, starved int64
+	waitTotal, waitMax                                 time.Duration
+}
+
+// sessionQueue holds the queued tasks of a session on its worker
+type sessionQueue struct {
+	tasks  []Task
+	counts [numPriorities]int // queued tasks per priority
+	lane   Priority
+	since  time.Time // when the session last started waiting for its turn
+}
+
+// top returns the highest priority among the queued tasks
+func (q *sessionQueue) top() Priority {
+	for p := numPriorities - 1; p > 0; p-- {
+		if q.counts[p] > 0 {
+			return Priority(p)
+		}
+	}
+	return PriorityLow
+}
+
+func NewWorkerPool(cfg Config) *WorkerPool {
+	if cfg.NumWorkers < 1 {
+		cfg.NumWorkers = 1
+	}
+	if cfg.QueueCapacity < 1 {
+		cfg.QueueCapacity = 1
+	}
 	wp := &WorkerPool{
-		NumWorkers: n,
-		queues:     make(map[int]chan Task),
+		cfg:     cfg,
+		workers: make(map[int]*worker),
+	}
+	for i := 0; i < cfg.NumWorkers; i++ {
+		wp.startWorker()
 	}
+	wp.ring = newRing(wp.ids())
+	return wp
+}
 
-	for i := 0; i < n; i++ {
-		ch := make(chan Task, 100)
-		wp.queues[i] = ch
+// Size returns the number of workers
+func (wp *WorkerPool) Size() int {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	return len(wp.workers)
+}
+
+// Resize grows or shrinks the pool to n workers. The sessions whose ring
+// position changes owner move with their queued tasks, in order, to their
+// new worker. A session can only move between two of its tasks, so a worker
+// running a task of a moving session is paused until it finishes; the other
+// workers keep running. Tasks keep being accepted meanwhile.
+func (wp *WorkerPool) Resize(n int) error {
+	if n < 1 {
+		return fmt.Errorf("a worker pool needs at least one worker, got %d", n)
+	}
+	wp.resizeMu.Lock()
+	defer wp.resizeMu.Unlock()
 
-		wp.wg.Add(1)
-		go func(id int, q chan Task) {
-			defer wp.wg.Done()
-			for task := range q {
-				log.Printf("[worker-%d] Processing session=%s", id, task.SessionID)
+	wp.mu.Lock()
+	from := len(wp.workers)
+	if from == n {
+		wp.mu.Unlock()
+		return nil
+	}
+	for len(wp.workers) < n {
+		wp.startWorker()
+	}
+	ids := wp.ids()
+	ring := newRing(ids[:n])
+	removed := ids[n:]
 
-				if handler, ok := task.Payload.(func()); ok {
-					handler()
-				}
+	var paused []*worker
+	var moving map[string]*sessionQueue
+	for {
+		var running []*worker
+		detached := make(map[*worker]map[string]*sessionQueue)
+		moving = make(map[string]*sessionQueue)
+		for _, w := range wp.workers {
+			queues, busy := w.detach(func(sessionID string) bool { return ring.owner(sessionID) != w.id })
+			if busy {
+				running = append(running, w)
+				continue
 			}
-		}(i, ch)
+			detached[w] = queues
+			maps.Copy(moving, queues)
+		}
+		if len(running) == 0 {
+			break
+		}
+		// Wait for the running tasks of moving sessions without the lock, as
+		// they may themselves dispatch. Until then the sessions stay where
+		// they are, so that tasks submitted meanwhile queue behind theirs.
+		for w, queues := range detached {
+			w.attach(queues)
+		}
+		wp.mu.Unlock()
+		for _, w := range running {
+			w.pause()
+		}
+		paused = append(paused, running...)
+		wp.mu.Lock()
 	}
 
-	return wp
+	wp.ring = ring
+	moved := 0
+	for sessionID, q := range moving {
+		moved += len(q.tasks)
+		wp.worker(sessionID).attach(map[string]*sessionQueue{sessionID: q})
+	}
+	for _, id := range removed {
+		w := wp.workers[id]
+		delete(wp.workers, id)
+		w.stop()
+	}
+	wp.mu.Unlock()
+
+	for _, w := range paused {
+		w.resume()
+	}
+	log.Printf("[workers] resized from %d to %d workers, moved %d queued tasks", from, n, moved)
+	return nil
+}
+
+// Dispatch queues an internal task, such as ending a session, on the
+// session's worker. It is never rejected, even when the queue is full.
+func (wp *WorkerPool) Dispatch(sessionID string, fn func() error) {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	w := wp.worker(sessionID)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		log.Printf("[worker-%d] pool stopped, dropping task for session=%s", w.id, sessionID)
+		return
+	}
+	w.push(Task{SessionID: sessionID, Priority: priorityControl, Run: fn})
+}
+
+// Submit queues fn on the session's worker. When the queue is full it applies
+// the pool's overflow policy and returns ErrQueueFull if the task was not
+// queued. reject may be nil; it is called if the task is shed later on.
+func (wp *WorkerPool) Submit(sessionID string, priority Priority, fn func() error, reject func(error)) error {
+	task := Task{SessionID: sessionID, Priority: priority, Run: fn, Reject: reject}
+
+	var timeout <-chan time.Time
+	var woken *worker // the worker whose space signal this submit took
+	for {
+		// The session's worker is looked up on every attempt, as a Resize
+		// may have moved it while waiting for space
+		wp.mu.RLock()
+		w := wp.worker(sessionID)
+		w.mu.Lock()
+		wp.mu.RUnlock()
+		if woken != nil && woken != w {
+			// Let the next submitter waiting on the old worker look again
+			woken.signalSpace()
+		}
+		if w.stopped {
+			w.mu.Unlock()
+			return ErrStopped
+		}
+		if w.depth < w.capacity {
+			w.push(task)
+			if woken == w && w.depth < w.capacity {
+				// More than one task may have left; pass the signal on
+				w.signalSpace()
+			}
+			w.mu.Unlock()
+			return nil
+		}
+
+		switch wp.cfg.Overflow {
+		case OverflowShed:
+			shed, ok := w.evict(priority)
+			if ok {
+				w.push(task)
+			} else {
+				w.rejected++
+			}
+			w.mu.Unlock()
+			if !ok {
+				return ErrQueueFull
+			}
+			if shed.Reject != nil {
+				shed.Reject(ErrShed)
+			}
+			return nil
+
+		case OverflowBlock:
+			space := w.space
+			w.mu.Unlock()
+			if timeout == nil {
+				timer := time.NewTimer(wp.cfg.BlockTimeout)
+				defer timer.Stop()
+				timeout = timer.C
+			}
+			select {
+			case <-space:
+				woken = w
+				continue
+			case <-timeout:
+			}
+			w.mu.Lock()
+
+		default:
+		}
+		w.rejected++
+		w.mu.Unlock()
+		return ErrQueueFull
+	}
 }
 
-func (wp *WorkerPool) Dispatch(sessionID string, fn func()) {
-	workerID := int(HashString(sessionID)) % wp.NumWorkers
-	wp.queues[workerID] <- Task{
-		SessionID: sessionID,
-		Payload:   fn,
+// Stats returns the queue metrics of every worker
+func (wp *WorkerPool) Stats() []WorkerStats {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+	stats := make([]WorkerStats, 0, len(wp.workers))
+	for _, id := range wp.ids() {
+		stats = append(stats, wp.workers[id].stats())
 	}
+	return stats
 }
 
+// Stop runs the tasks already queued and stops the workers
 func (wp *WorkerPool) Stop() {
-	for _, q := range wp.queues {
-		close(q)
+	wp.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting tasks and runs the queued ones until ctx is done.
+// The tasks still queued then are dropped, with their Reject called with
+// ErrStopped, and their number returned; tasks already running finish in the
+// background.
+func (wp *WorkerPool) Shutdown(ctx context.Context) int {
+	wp.mu.RLock()
+	workers := make([]*worker, 0, len(wp.workers))
+	for _, w := range wp.workers {
+		workers = append(workers, w)
+	}
+	wp.mu.RUnlock()
+	for _, w := range workers {
+		w.stop()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wp.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return 0
+	case <-ctx.Done():
+	}
+
+	dropped := 0
+	for _, w := range workers {
+		dropped += w.discard()
+	}
+	return dropped
+}
+
+// worker returns the session's worker; wp.mu must be held
+func (wp *WorkerPool) worker(sessionID string) *worker {
+	return wp.workers[wp.ring.owner(sessionID)]
+}
+
+// ids returns the worker ids in ascending order; wp.mu must be held
+func (wp *WorkerPool) ids() []int {
+	ids := make([]int, 0, len(wp.workers))
+	for id := range wp.workers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// startWorker adds a worker that is not on the ring yet; wp.mu must be held
+// for writing
+func (wp *WorkerPool) startWorker() {
+	w := &worker{
+		id:            wp.nextID,
+		capacity:      wp.cfg.QueueCapacity,
+		queues:        make(map[string]*sessionQueue),
+		wake:          make(chan struct{}, 1),
+		space:         make(chan struct{}, 1),
+		onError:       wp.cfg.OnError,
+		maxStarvation: wp.cfg.MaxStarvation,
+	}
+	w.idle = sync.NewCond(&w.mu)
+	wp.nextID++
+	wp.workers[w.id] = w
+
+	wp.wg.Add(1)
+	go func() {
+		defer wp.wg.Done()
+		w.run()
+	}()
+}
+
+// detach takes the queued tasks of the sessions that move off w, unless w
+// is running a task of a moving session, in which case it is paused so that
+// it starts no other task and busy is true. wp.mu must be held for writing.
+func (w *worker) detach(moves func(sessionID string) bool) (queues map[string]*sessionQueue, busy bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.busy && moves(w.running) {
+		w.paused = true
+		return nil, true
+	}
+	queues = make(map[string]*sessionQueue)
+	for sessionID, q := range w.queues {
+		if moves(sessionID) {
+			w.remove(sessionID, q)
+			queues[sessionID] = q
+		}
+	}
+	if len(queues) > 0 {
+		// Submitters waiting for space look up their worker again
+		w.signalSpace()
+	}
+	return queues, false
+}
+
+// attach queues the tasks of sessions that have none on w yet. Moved tasks
+// may exceed the capacity for a while; they were accepted.
+func (w *worker) attach(queues map[string]*sessionQueue) {
+	if len(queues) == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for sessionID, q := range queues {
+		w.queues[sessionID] = q
+		w.lanes[q.lane] = append(w.lanes[q.lane], sessionID)
+		w.depth += len(q.tasks)
+	}
+	w.signal()
+}
+
+// push queues a task behind the earlier tasks of its session, raising the
+// session's lane to the task's priority; w.mu must be held
+func (w *worker) push(task Task) {
+	task.queuedAt = time.Now()
+	q, ok := w.queues[task.SessionID]
+	if !ok {
+		q = &sessionQueue{lane: task.Priority, since: task.queuedAt}
+		w.queues[task.SessionID] = q
+		w.lanes[q.lane] = append(w.lanes[q.lane], task.SessionID)
+	}
+	q.tasks = append(q.tasks, task)
+	q.counts[task.Priority]++
+	w.depth++
+	if task.Priority > q.lane {
+		w.move(task.SessionID, q, task.Priority)
+	}
+	w.signal()
+}
+
+// move puts a queued session at the back of another lane; w.mu must be held
+func (w *worker) move(sessionID string, q *sessionQueue, lane Priority) {
+	w.lanes[q.lane] = without(w.lanes[q.lane], sessionID)
+	q.lane = lane
+	w.lanes[lane] = append(w.lanes[lane], sessionID)
+}
+
+// remove takes a session and its tasks off the worker; w.mu must be held
+func (w *worker) remove(sessionID string, q *sessionQueue) {
+	w.lanes[q.lane] = without(w.lanes[q.lane], sessionID)
+	delete(w.queues, sessionID)
+	w.depth -= len(q.tasks)
+}
+
+func without(sessions []string, sessionID string) []string {
+	for i, id := range sessions {
+		if id == sessionID {
+			return append(sessions[:i], sessions[i+1:]...)
+		}
+	}
+	return sessions
+}
+
+// pop dequeues the next task: the head of the first session of the highest
+// lane, unless the first session of a lower lane waited MaxStarvation, in
+// which case the session that waited longest goes first. The session then
+// waits for its next turn at the back of its lane. There must be a queued
+// task and w.mu must be held.
+func (w *worker) pop() Task {
+	lane := numPriorities - 1
+	for len(w.lanes[lane]) == 0 {
+		lane--
+	}
+	if w.maxStarvation > 0 {
+		top := lane
+		var oldest time.Time
+		for l := 0; l < top; l++ {
+			if len(w.lanes[l]) == 0 {
+				continue
+			}
+			since := w.queues[w.lanes[l][0]].since
+			if time.Since(since) >= w.maxStarvation && (oldest.IsZero() || since.Before(oldest)) {
+				lane, oldest = l, since
+			}
+		}
+		if lane != top {
+			w.starved++
+		}
+	}
+
+	sessionID := w.lanes[lane][0]
+	w.lanes[lane] = w.lanes[lane][1:]
+	q := w.queues[sessionID]
+	task := q.tasks[0]
+	q.tasks[0] = Task{}
+	q.tasks = q.tasks[1:]
+	q.counts[task.Priority]--
+	w.depth--
+	if len(q.tasks) == 0 {
+		delete(w.queues, sessionID)
+		return task
+	}
+	q.lane, q.since = q.top(), time.Now()
+	w.lanes[q.lane] = append(w.lanes[q.lane], sessionID)
+	return task
+}
+
+// evict removes the newest queued task with the lowest priority, if that is
+// below priority; w.mu must be held
+func (w *worker) evict(priority Priority) (Task, bool) {
+	var victim *sessionQueue
+	var victimID string
+	at := -1
+	for sessionID, q := range w.queues {
+		for i, task := range q.tasks {
+			if task.Priority >= priority {
+				continue
+			}
+			if at < 0 || task.Priority < victim.tasks[at].Priority ||
+				task.Priority == victim.tasks[at].Priority && !task.queuedAt.Before(victim.tasks[at].queuedAt) {
+				victim, victimID, at = q, sessionID, i
+			}
+		}
+	}
+	if at < 0 {
+		return Task{}, false
+	}
+	task := victim.tasks[at]
+	victim.tasks = append(victim.tasks[:at], victim.tasks[at+1:]...)
+	victim.counts[task.Priority]--
+	w.depth--
+	w.shed++
+	switch {
+	case len(victim.tasks) == 0:
+		w.lanes[victim.lane] = without(w.lanes[victim.lane], victimID)
+		delete(w.queues, victimID)
+	case victim.top() != victim.lane:
+		w.move(victimID, victim, victim.top())
+	}
+	return task, true
+}
+
+func (w *worker) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// signalSpace wakes a submitter waiting for space, if any
+func (w *worker) signalSpace() {
+	select {
+	case w.space <- struct{}{}:
+	default:
+	}
+}
+
+func (w *worker) run() {
+	for {
+		task, ok := w.next()
+		if !ok {
+			return
+		}
+		log.Printf("[worker-%d] Processing session=%s", w.id, task.SessionID)
+
+		if err := w.execute(task); err != nil {
+			w.report(err)
+		}
+
+		w.mu.Lock()
+		w.busy = false
+		w.idle.Broadcast()
+		w.mu.Unlock()
+	}
+}
+
+// execute runs a task, turning a panic into a TaskError so that the worker
+// and the other sessions it serves survive
+func (w *worker) execute(task Task) (err *TaskError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &TaskError{
+				SessionID: task.SessionID,
+				Worker:    w.id,
+				Err:       fmt.Errorf("panic: %v", r),
+				Stack:     debug.Stack(),
+			}
+		}
+	}()
+	if task.Run == nil {
+		return nil
+	}
+	if runErr := task.Run(); runErr != nil {
+		return &TaskError{SessionID: task.SessionID, Worker: w.id, Err: runErr}
+	}
+	return nil
+}
+
+func (w *worker) report(err *TaskError) {
+	w.mu.Lock()
+	w.failed++
+	if err.Panicked() {
+		w.panics++
+	}
+	w.mu.Unlock()
+
+	if err.Panicked() {
+		log.Printf("[worker-%d] %v\n%s", w.id, err, err.Stack)
+	} else {
+		log.Printf("[worker-%d] %v", w.id, err)
+	}
+	if w.onError == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[worker-%d] error handler panicked: %v\n%s", w.id, r, debug.Stack())
+		}
+	}()
+	w.onError(err)
+}
+
+// next waits for the next queued task while the worker is not paused; it
+// returns false once the worker is stopped and its queue is empty
+func (w *worker) next() (Task, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.depth == 0 || w.paused {
+		if w.stopped && w.depth == 0 {
+			return Task{}, false
+		}
+		w.mu.Unlock()
+		<-w.wake
+		w.mu.Lock()
+	}
+
+	task := w.pop()
+	w.signalSpace()
+
+	w.busy, w.running = true, task.SessionID
+	wait := time.Since(task.queuedAt)
+	w.processed++
+	w.waitTotal += wait
+	if wait > w.waitMax {
+		w.waitMax = wait
+	}
+	return task, true
+}
+
+// pause stops the worker from starting tasks and waits for the running one
+func (w *worker) pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = true
+	for w.busy {
+		w.idle.Wait()
+	}
+}
+
+func (w *worker) resume() {
+	w.mu.Lock()
+	w.paused = false
+	w.mu.Unlock()
+	w.signal()
+}
+
+// discard empties the queue, rejecting the tasks, and returns how many there were
+func (w *worker) discard() int {
+	w.mu.Lock()
+	var tasks []Task
+	for _, q := range w.queues {
+		tasks = append(tasks, q.tasks...)
+	}
+	w.queues = make(map[string]*sessionQueue)
+	w.lanes = [numPriorities][]string{}
+	w.depth = 0
+	w.mu.Unlock()
+	w.signal()
+
+	for _, task := range tasks {
+		if task.Reject != nil {
+			task.Reject(ErrStopped)
+		}
+	}
+	return len(tasks)
+}
+
+// stop lets the worker exit once its queue is empty
+func (w *worker) stop() {
+	w.mu.Lock()
+	w.stopped = true
+	w.mu.Unlock()
+	w.signal()
+}
+
+func (w *worker) stats() WorkerStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := WorkerStats{
+		Worker:    w.id,
+		Depth:     w.depth,
+		Lanes:     make(map[string]int, numPriorities),
+		Capacity:  w.capacity,
+		Processed: w.processed,
+		Starved:   w.starved,
+		Rejected:  w.rejected,
+		Shed:      w.shed,
+		Failed:    w.failed,
+		Panics:    w.panics,
+		MaxWaitMs: float64(w.waitMax) / float64(time.Millisecond),
+	}
+	for _, q := range w.queues {
+		for p, n := range q.counts {
+			s.Lanes[Priority(p).String()] += n
+		}
+	}
+	if w.processed > 0 {
+		s.AvgWaitMs = float64(w.waitTotal) / float64(w.processed) / float64(time.Millisecond)
 	}
-	wp.wg.Wait()
+	return s
 }
 
 func HashString(s string) uint32 {
@@ -0,0 +1,349 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockWorker runs a task on the session's worker that waits until the
+// returned function is called, so that later tasks stay queued
+func blockWorker(t *testing.T, wp *WorkerPool, sessionID string) func() {
+	t.Helper()
+	started := make(chan struct{})
+	gate := make(chan struct{})
+	err := wp.Submit(sessionID, PriorityHigh, func() error {
+		close(started)
+		<-gate
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	var once sync.Once
+	return func() { once.Do(func() { close(gate) }) }
+}
+
+func TestSessionOrderAcrossResize(t *testing.T) {
+	wp := NewWorkerPool(Config{NumWorkers: 2, QueueCapacity: 1000, Overflow: OverflowBlock, BlockTimeout: 5 * time.Second})
+	defer wp.Stop()
+
+	const sessions, tasks = 20, 200
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+
+	var wg sync.WaitGroup
+	for s := 0; s < sessions; s++ {
+		sessionID := fmt.Sprintf("session-%d", s)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < tasks; i++ {
+				err := wp.Submit(sessionID, PriorityNormal, func() error {
+					mu.Lock()
+					seen[sessionID] = append(seen[sessionID], i)
+					mu.Unlock()
+					return nil
+				}, nil)
+				if err != nil {
+					t.Errorf("submit failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for _, n := range []int{6, 1, 4, 2, 8, 3} {
+		if err := wp.Resize(n); err != nil {
+			t.Fatal(err)
+		}
+		if got := wp.Size(); got != n {
+			t.Fatalf("Expected %d workers, got %d", n, got)
+		}
+	}
+	wg.Wait()
+	wp.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for s := 0; s < sessions; s++ {
+		sessionID := fmt.Sprintf("session-%d", s)
+		got := seen[sessionID]
+		if len(got) != tasks {
+			t.Errorf("Expected %d tasks of %s to run, got %d", tasks, sessionID, len(got))
+			continue
+		}
+		for i, v := range got {
+			if v != i {
+				t.Errorf("Expected the tasks of %s in order, got %d at %d", sessionID, v, i)
+				break
+			}
+		}
+	}
+}
+
+// sessionsByMove returns a session that keeps its worker when the pool grows
+// from 2 to 3 workers and one that moves
+func sessionsByMove() (stays, moves string) {
+	before, after := newRing([]int{0, 1}), newRing([]int{0, 1, 2})
+	for i := 0; stays == "" || moves == ""; i++ {
+		sessionID := fmt.Sprintf("session-%d", i)
+		if before.owner(sessionID) == after.owner(sessionID) {
+			stays = sessionID
+		} else {
+			moves = sessionID
+		}
+	}
+	return stays, moves
+}
+
+func TestResizeOnlyWaitsForMovingSessions(t *testing.T) {
+	wp := NewWorkerPool(Config{NumWorkers: 2, QueueCapacity: 10, Overflow: OverflowReject})
+	defer wp.Stop()
+	stays, moves := sessionsByMove()
+
+	release := blockWorker(t, wp, stays)
+	defer release()
+	resized := make(chan error, 1)
+	go func() { resized <- wp.Resize(3) }()
+	select {
+	case err := <-resized:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the resize not to wait for a session that stays")
+	}
+	release()
+
+	release = blockWorker(t, wp, moves)
+	defer release()
+	ran := make(chan struct{})
+	if err := wp.Submit(moves, PriorityNormal, func() error { close(ran); return nil }, nil); err != nil {
+		t.Fatal(err)
+	}
+	go func() { resized <- wp.Resize(2) }()
+	select {
+	case <-resized:
+		t.Fatal("Expected the resize to wait for the running task of a moving session")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// The other workers keep running meanwhile
+	done := make(chan struct{})
+	if err := wp.Submit(stays, PriorityNormal, func() error { close(done); return nil }, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a session that stays to keep running during the resize")
+	}
+
+	release()
+	if err := <-resized; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the queued task of the moved session to run on its new worker")
+	}
+}
+
+func TestOverflowReject(t *testing.T) {
+	wp := NewWorkerPool(Config{NumWorkers: 1, QueueCapacity: 1, Overflow: OverflowReject})
+	defer wp.Stop()
+	release := blockWorker(t, wp, "s")
+	defer release()
+
+	if err := wp.Submit("s", PriorityLow, func() error { return nil }, nil); err != nil {
+		t.Fatalf("Expected the first task to queue, got %v", err)
+	}
+	if err := wp.Submit("s", PriorityHigh, func() error { return nil }, nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+	if rejected := wp.Stats()[0].Rejected; rejected != 1 {
+		t.Errorf("Expected 1 rejected submit, got %d", rejected)
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	wp := NewWorkerPool(Config{NumWorkers: 1, QueueCapacity: 1, Overflow: OverflowBlock, BlockTimeout: 20 * time.Millisecond})
+	defer wp.Stop()
+	release := blockWorker(t, wp, "s")
+	defer release()
+
+	if err := wp.Submit("s", PriorityNormal, func() error { return nil }, nil); err != nil {
+		t.Fatalf("Expected the first task to queue, got %v", err)
+	}
+	start := time.Now()
+	if err := wp.Submit("s", PriorityNormal, func() error { return nil }, nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull after the block timeout, got %v", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("Expected to wait for the block timeout, waited %v", waited)
+	}
+
+	// Space freed while blocked lets the submit through
+	time.AfterFunc(5*time.Millisecond, release)
+	wp.cfg.BlockTimeout = 5 * time.Second
+	if err := wp.Submit("s", PriorityNormal, func() error { return nil }, nil); err != nil {
+		t.Errorf("Expected the submit to wait for space, got %v", err)
+	}
+}
+
+func TestOverflowShed(t *testing.T) {
+	wp := NewWorkerPool(Config{NumWorkers: 1, QueueCapacity: 1, Overflow: OverflowShed})
+	defer wp.Stop()
+	release := blockWorker(t, wp, "s")
+	defer release()
+
+	shed := make(chan error, 1)
+	ran := make(chan string, 2)
+	if err := wp.Submit("s", PriorityLow, func() error { ran <- "low"; return nil }, func(err error) { shed <- err }); err != nil {
+		t.Fatalf("Expected the low task to queue, got %v", err)
+	}
+	if err := wp.Submit("s", PriorityHigh, func() error { ran <- "high"; return nil }, nil); err != nil {
+		t.Fatalf("Expected the high task to shed the low one, got %v", err)
+	}
+	select {
+	case err := <-shed:
+		if !errors.Is(err, ErrShed) {
+			t.Errorf("Expected the reject callback to get ErrShed, got %v", err)
+		}
+	default:
+		t.Error("Expected the reject callback of the shed task to be called")
+	}
+
+	// Nothing lower than the queued high task is left to shed
+	if err := wp.Submit("s", PriorityNormal, func() error { return nil }, nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+	stats := wp.Stats()[0]
+	if stats.Shed != 1 || stats.Rejected != 1 {
+		t.Errorf("Expected 1 shed and 1 rejected, got %+v", stats)
+	}
+
+	release()
+	if got := <-ran; got != "high" {
+		t.Errorf("Expected only the high task to run, got %s", got)
+	}
+}
+
+func TestHigherLanesServedFirstInSessionOrder(t *testing.T) {
+	wp := NewWorkerPool(Config{NumWorkers: 1, QueueCapacity: 100, Overflow: OverflowReject})
+	defer wp.Stop()
+	release := blockWorker(t, wp, "blocker")
+
+	var mu sync.Mutex
+	var order []string
+	submit := func(sessionID string, priority Priority, name string) {
+		t.Helper()
+		err := wp.Submit(sessionID, priority, func() error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	submit("bulk", PriorityLow, "bulk")
+	submit("normal", PriorityNormal, "normal")
+	// A high task raises its session's lane, and its earlier low task goes first
+	submit("vip", PriorityLow, "vip-1")
+	submit("vip", PriorityHigh, "vip-2")
+	release()
+	wp.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"vip-1", "vip-2", "normal", "bulk"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, order)
+	}
+}
+
+func TestLowLaneServedAfterMaxStarvation(t *testing.T) {
+	wp := NewWorkerPool(Config{NumWorkers: 1, QueueCapacity: 1000, Overflow: OverflowReject, MaxStarvation: 20 * time.Millisecond})
+	defer wp.Stop()
+	release := blockWorker(t, wp, "blocker")
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func() error {
+		return func() error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			time.Sleep(2 * time.Millisecond)
+			return nil
+		}
+	}
+	if err := wp.Submit("low", PriorityLow, record("low"), nil); err != nil {
+		t.Fatal(err)
+	}
+	const high = 50
+	for i := 0; i < high; i++ {
+		if err := wp.Submit(fmt.Sprintf("high-%d", i), PriorityHigh, record("high"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release()
+	wp.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, name := range order {
+		if name != "low" {
+			continue
+		}
+		if i == len(order)-1 {
+			t.Errorf("Expected the starved low task to run before the high lane drained, ran last")
+		}
+		if starved := wp.Stats()[0].Starved; starved == 0 {
+			t.Error("Expected the starved task to be counted")
+		}
+		return
+	}
+	t.Error("Expected the low task to run")
+}
+
+func TestPanicReportedAndWorkerSurvives(t *testing.T) {
+	failures := make(chan *TaskError, 1)
+	wp := NewWorkerPool(Config{NumWorkers: 1, QueueCapacity: 10, Overflow: OverflowReject, OnError: func(err *TaskError) {
+		failures <- err
+	}})
+	defer wp.Stop()
+
+	if err := wp.Submit("s", PriorityNormal, func() error { panic("boom") }, nil); err != nil {
+		t.Fatal(err)
+	}
+	ran := make(chan struct{})
+	if err := wp.Submit("s", PriorityNormal, func() error { close(ran); return nil }, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-failures:
+		if !err.Panicked() || len(err.Stack) == 0 {
+			t.Errorf("Expected a panic with its stack, got %v", err)
+		}
+		if err.SessionID != "s" {
+			t.Errorf("Expected the failure of session s, got %s", err.SessionID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected OnError to be called")
+	}
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the worker to keep running tasks after a panic")
+	}
+	if stats := wp.Stats()[0]; stats.Panics != 1 || stats.Failed != 1 {
+		t.Errorf("Expected 1 panic counted, got %+v", stats)
+	}
+}
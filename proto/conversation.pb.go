@@ -21,6 +21,55 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// What happens to a subscriber that doesn't keep up with the events.
+type SlowSubscriberPolicy int32
+
+const (
+	// Events are dropped for the subscriber.
+	SlowSubscriberPolicy_SLOW_SUBSCRIBER_DROP SlowSubscriberPolicy = 0
+	// The stream ends with RESOURCE_EXHAUSTED; resume with after_event_id.
+	SlowSubscriberPolicy_SLOW_SUBSCRIBER_DISCONNECT SlowSubscriberPolicy = 1
+)
+
+// Enum value maps for SlowSubscriberPolicy.
+var (
+	SlowSubscriberPolicy_name = map[int32]string{
+		0: "SLOW_SUBSCRIBER_DROP",
+		1: "SLOW_SUBSCRIBER_DISCONNECT",
+	}
+	SlowSubscriberPolicy_value = map[string]int32{
+		"SLOW_SUBSCRIBER_DROP":       0,
+		"SLOW_SUBSCRIBER_DISCONNECT": 1,
+	}
+)
+
+func (x SlowSubscriberPolicy) Enum() *SlowSubscriberPolicy {
+	p := new(SlowSubscriberPolicy)
+	*p = x
+	return p
+}
+
+func (x SlowSubscriberPolicy) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SlowSubscriberPolicy) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_conversation_proto_enumTypes[0].Descriptor()
+}
+
+func (SlowSubscriberPolicy) Type() protoreflect.EnumType {
+	return &file_proto_conversation_proto_enumTypes[0]
+}
+
+func (x SlowSubscriberPolicy) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SlowSubscriberPolicy.Descriptor instead.
+func (SlowSubscriberPolicy) EnumDescriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{0}
+}
+
 // A single chunk of a live conversation (chat or voice transcript).
 type ConversationChunk struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
@@ -35,7 +84,20 @@ type ConversationChunk struct {
 	// Event timestamp in milliseconds since epoch.
 	TimestampMs int64 `protobuf:"varint,5,opt,name=timestamp_ms,json=timestampMs,proto3" json:"timestamp_ms,omitempty"`
 	// Extra metadata fields (channel, language, tags, etc.).
-	Metadata      map[string]string `protobuf:"bytes,6,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Metadata map[string]string `protobuf:"bytes,6,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	ClientId string            `protobuf:"bytes,7,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	// Monotonic per-session sequence number starting at 1; 0 if unsequenced.
+	// Chunks whose sequence was already processed are skipped, so a producer
+	// can safely resend its unacknowledged tail after ResumeFrom.
+	Sequence uint64 `protobuf:"varint,8,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// Speech transcripts send interim hypotheses of an utterance, with
+	// is_final = false and increasing revisions under the utterance's
+	// message_id, followed by its final result. Interim chunks are evaluated
+	// speculatively without being counted in the session; only rules that opt
+	// in fire on them. Unset means final, so text producers are unaffected.
+	IsFinal *bool `protobuf:"varint,9,opt,name=is_final,json=isFinal,proto3,oneof" json:"is_final,omitempty"`
+	// Revision of an interim hypothesis; older revisions are superseded.
+	Revision      uint32 `protobuf:"varint,10,opt,name=revision,proto3" json:"revision,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -112,6 +174,34 @@ func (x *ConversationChunk) GetMetadata() map[string]string {
 	return nil
 }
 
+func (x *ConversationChunk) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *ConversationChunk) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *ConversationChunk) GetIsFinal() bool {
+	if x != nil && x.IsFinal != nil {
+		return *x.IsFinal
+	}
+	return false
+}
+
+func (x *ConversationChunk) GetRevision() uint32 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
 // Acknowledgement from analytics/escalation engine after stream finishes.
 type AnalyticsAck struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
@@ -122,7 +212,21 @@ type AnalyticsAck struct {
 	// Whether processing succeeded end-to-end.
 	Success bool `protobuf:"varint,3,opt,name=success,proto3" json:"success,omitempty"`
 	// Optional human-readable message (error, debug info, etc.).
-	Message       string `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	Message  string `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	ClientId string `protobuf:"bytes,7,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	// Highest sequence of the session such that every chunk up to it was
+	// processed.
+	LastProcessedSequence uint64 `protobuf:"varint,8,opt,name=last_processed_sequence,json=lastProcessedSequence,proto3" json:"last_processed_sequence,omitempty"`
+	// Number of chunks skipped because their message_id was already processed.
+	Duplicates uint32 `protobuf:"varint,9,opt,name=duplicates,proto3" json:"duplicates,omitempty"`
+	// Number of chunks shed because the server was overloaded; they were not
+	// processed and can be resent after ResumeFrom.
+	Rejected uint32 `protobuf:"varint,10,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	// Number of chunks whose processing failed.
+	Failed uint32 `protobuf:"varint,11,opt,name=failed,proto3" json:"failed,omitempty"`
+	// Number of chunks that took longer than the server's latency budget from
+	// their timestamp_ms to their actions.
+	OverBudget    uint32 `protobuf:"varint,12,opt,name=over_budget,json=overBudget,proto3" json:"over_budget,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -185,78 +289,1651 @@ func (x *AnalyticsAck) GetMessage() string {
 	return ""
 }
 
-var File_proto_conversation_proto protoreflect.FileDescriptor
+func (x *AnalyticsAck) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
 
-const file_proto_conversation_proto_rawDesc = "" +
-	"\n" +
-	"\x18proto/conversation.proto\x12\x0fconversation.v1\"\xab\x02\n" +
-	"\x11ConversationChunk\x12\x1d\n" +
-	"\n" +
-	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x1d\n" +
-	"\n" +
-	"message_id\x18\x02 \x01(\tR\tmessageId\x12\x16\n" +
-	"\x06sender\x18\x03 \x01(\tR\x06sender\x12\x12\n" +
-	"\x04text\x18\x04 \x01(\tR\x04text\x12!\n" +
-	"\ftimestamp_ms\x18\x05 \x01(\x03R\vtimestampMs\x12L\n" +
-	"\bmetadata\x18\x06 \x03(\v20.conversation.v1.ConversationChunk.MetadataEntryR\bmetadata\x1a;\n" +
-	"\rMetadataEntry\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x89\x01\n" +
-	"\fAnalyticsAck\x12\x1d\n" +
-	"\n" +
-	"session_id\x18\x01 \x01(\tR\tsessionId\x12&\n" +
-	"\x0flast_message_id\x18\x02 \x01(\tR\rlastMessageId\x12\x18\n" +
-	"\asuccess\x18\x03 \x01(\bR\asuccess\x12\x18\n" +
-	"\amessage\x18\x04 \x01(\tR\amessage2o\n" +
-	"\x12ConversationStream\x12Y\n" +
-	"\x12StreamConversation\x12\".conversation.v1.ConversationChunk\x1a\x1d.conversation.v1.AnalyticsAck(\x01B_Z]github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto;conversationv1b\x06proto3"
+func (x *AnalyticsAck) GetLastProcessedSequence() uint64 {
+	if x != nil {
+		return x.LastProcessedSequence
+	}
+	return 0
+}
 
-var (
-	file_proto_conversation_proto_rawDescOnce sync.Once
-	file_proto_conversation_proto_rawDescData []byte
-)
+func (x *AnalyticsAck) GetDuplicates() uint32 {
+	if x != nil {
+		return x.Duplicates
+	}
+	return 0
+}
 
-func file_proto_conversation_proto_rawDescGZIP() []byte {
-	file_proto_conversation_proto_rawDescOnce.Do(func() {
-		file_proto_conversation_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_conversation_proto_rawDesc), len(file_proto_conversation_proto_rawDesc)))
-	})
-	return file_proto_conversation_proto_rawDescData
+func (x *AnalyticsAck) GetRejected() uint32 {
+	if x != nil {
+		return x.Rejected
+	}
+	return 0
 }
 
-var file_proto_conversation_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
-var file_proto_conversation_proto_goTypes = []any{
-	(*ConversationChunk)(nil), // 0: conversation.v1.ConversationChunk
-	(*AnalyticsAck)(nil),      // 1: conversation.v1.AnalyticsAck
-	nil,                       // 2: conversation.v1.ConversationChunk.MetadataEntry
+func (x *AnalyticsAck) GetFailed() uint32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
 }
-var file_proto_conversation_proto_depIdxs = []int32{
-	2, // 0: conversation.v1.ConversationChunk.metadata:type_name -> conversation.v1.ConversationChunk.MetadataEntry
-	0, // 1: conversation.v1.ConversationStream.StreamConversation:input_type -> conversation.v1.ConversationChunk
-	1, // 2: conversation.v1.ConversationStream.StreamConversation:output_type -> conversation.v1.AnalyticsAck
-	2, // [2:3] is the sub-list for method output_type
-	1, // [1:2] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+
+func (x *AnalyticsAck) GetOverBudget() uint32 {
+	if x != nil {
+		return x.OverBudget
+	}
+	return 0
 }
 
-func init() { file_proto_conversation_proto_init() }
-func file_proto_conversation_proto_init() {
-	if File_proto_conversation_proto != nil {
-		return
+// Acknowledgement of a single chunk, sent as soon as it has been processed.
+type ChunkAck struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	SessionId string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	MessageId string                 `protobuf:"bytes,2,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	// Whether the chunk was processed; false when it was rejected because the
+	// server is overloaded.
+	Success bool `protobuf:"varint,3,opt,name=success,proto3" json:"success,omitempty"`
+	// Optional human-readable message (error, debug info, etc.).
+	Message  string `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	ClientId string `protobuf:"bytes,7,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	// Sequence of the acknowledged chunk.
+	Sequence uint64 `protobuf:"varint,8,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// See AnalyticsAck.last_processed_sequence.
+	LastProcessedSequence uint64 `protobuf:"varint,9,opt,name=last_processed_sequence,json=lastProcessedSequence,proto3" json:"last_processed_sequence,omitempty"`
+	// The chunk was already processed and was skipped.
+	Duplicate bool `protobuf:"varint,10,opt,name=duplicate,proto3" json:"duplicate,omitempty"`
+	// Processing an earlier chunk of the session failed midway, so its
+	// cumulative analysis may be incomplete.
+	Degraded bool `protobuf:"varint,11,opt,name=degraded,proto3" json:"degraded,omitempty"`
+	// Milliseconds from the chunk's timestamp_ms, or its receipt without one,
+	// to its actions being dispatched; 0 until it was evaluated.
+	LatencyMs uint32 `protobuf:"varint,12,opt,name=latency_ms,json=latencyMs,proto3" json:"latency_ms,omitempty"`
+	// latency_ms exceeded the server's latency budget.
+	OverBudget bool `protobuf:"varint,13,opt,name=over_budget,json=overBudget,proto3" json:"over_budget,omitempty"`
+	// The chunk was shed because the server was overloaded and was not
+	// processed; it can be resent.
+	Rejected bool `protobuf:"varint,14,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	// The chunk is invalid and was not processed; message says why. Resending
+	// it unchanged fails again.
+	Invalid       bool `protobuf:"varint,15,opt,name=invalid,proto3" json:"invalid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChunkAck) Reset() {
+	*x = ChunkAck{}
+	mi := &file_proto_conversation_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChunkAck) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChunkAck) ProtoMessage() {}
+
+func (x *ChunkAck) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChunkAck.ProtoReflect.Descriptor instead.
+func (*ChunkAck) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ChunkAck) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *ChunkAck) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *ChunkAck) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *ChunkAck) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *ChunkAck) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *ChunkAck) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *ChunkAck) GetLastProcessedSequence() uint64 {
+	if x != nil {
+		return x.LastProcessedSequence
+	}
+	return 0
+}
+
+func (x *ChunkAck) GetDuplicate() bool {
+	if x != nil {
+		return x.Duplicate
+	}
+	return false
+}
+
+func (x *ChunkAck) GetDegraded() bool {
+	if x != nil {
+		return x.Degraded
+	}
+	return false
+}
+
+func (x *ChunkAck) GetLatencyMs() uint32 {
+	if x != nil {
+		return x.LatencyMs
+	}
+	return 0
+}
+
+func (x *ChunkAck) GetOverBudget() bool {
+	if x != nil {
+		return x.OverBudget
+	}
+	return false
+}
+
+func (x *ChunkAck) GetRejected() bool {
+	if x != nil {
+		return x.Rejected
+	}
+	return false
+}
+
+func (x *ChunkAck) GetInvalid() bool {
+	if x != nil {
+		return x.Invalid
+	}
+	return false
+}
+
+// An escalation decided while the conversation is ongoing.
+type EscalationEvent struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	SessionId string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	// The chunk whose evaluation fired the rule.
+	MessageId string `protobuf:"bytes,2,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	RuleId    string `protobuf:"bytes,3,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	RuleName  string `protobuf:"bytes,4,opt,name=rule_name,json=ruleName,proto3" json:"rule_name,omitempty"`
+	// Action of the first level of the rule's escalation ladder.
+	Action string `protobuf:"bytes,5,opt,name=action,proto3" json:"action,omitempty"`
+	// Confidence of the decision in [0, 1]: the weakest intent confidence or
+	// exemplar similarity among the rule's conditions, 1 for keyword-only rules.
+	Score float64 `protobuf:"fixed64,6,opt,name=score,proto3" json:"score,omitempty"`
+	// Decision trace, see GET /api/sessions/{id}/explain.
+	TraceId      string `protobuf:"bytes,7,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`
+	EscalationId string `protobuf:"bytes,8,opt,name=escalation_id,json=escalationId,proto3" json:"escalation_id,omitempty"`
+	TimestampMs  int64  `protobuf:"varint,9,opt,name=timestamp_ms,json=timestampMs,proto3" json:"timestamp_ms,omitempty"`
+	ClientId     string `protobuf:"bytes,10,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	// Increasing event id; pass the last one seen as after_event_id to resume.
+	EventId int64 `protobuf:"varint,11,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	// Level of the escalation ladder that was dispatched, 0 when a rule fires.
+	Level         int32 `protobuf:"varint,12,opt,name=level,proto3" json:"level,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EscalationEvent) Reset() {
+	*x = EscalationEvent{}
+	mi := &file_proto_conversation_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EscalationEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EscalationEvent) ProtoMessage() {}
+
+func (x *EscalationEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EscalationEvent.ProtoReflect.Descriptor instead.
+func (*EscalationEvent) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *EscalationEvent) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *EscalationEvent) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *EscalationEvent) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *EscalationEvent) GetRuleName() string {
+	if x != nil {
+		return x.RuleName
+	}
+	return ""
+}
+
+func (x *EscalationEvent) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *EscalationEvent) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *EscalationEvent) GetTraceId() string {
+	if x != nil {
+		return x.TraceId
+	}
+	return ""
+}
+
+func (x *EscalationEvent) GetEscalationId() string {
+	if x != nil {
+		return x.EscalationId
+	}
+	return ""
+}
+
+func (x *EscalationEvent) GetTimestampMs() int64 {
+	if x != nil {
+		return x.TimestampMs
+	}
+	return 0
+}
+
+func (x *EscalationEvent) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *EscalationEvent) GetEventId() int64 {
+	if x != nil {
+		return x.EventId
+	}
+	return 0
+}
+
+func (x *EscalationEvent) GetLevel() int32 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+// A single chunk for IngestChunk.
+type IngestRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Chunk *ConversationChunk     `protobuf:"bytes,1,opt,name=chunk,proto3" json:"chunk,omitempty"`
+	// Wait for the chunk to be evaluated and return the outcome; otherwise the
+	// call returns as soon as the chunk is queued.
+	Wait          bool `protobuf:"varint,2,opt,name=wait,proto3" json:"wait,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestRequest) Reset() {
+	*x = IngestRequest{}
+	mi := &file_proto_conversation_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestRequest) ProtoMessage() {}
+
+func (x *IngestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestRequest.ProtoReflect.Descriptor instead.
+func (*IngestRequest) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *IngestRequest) GetChunk() *ConversationChunk {
+	if x != nil {
+		return x.Chunk
+	}
+	return nil
+}
+
+func (x *IngestRequest) GetWait() bool {
+	if x != nil {
+		return x.Wait
+	}
+	return false
+}
+
+// Chunks for IngestBatch, processed in order within each session.
+type IngestBatchRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Chunks []*ConversationChunk   `protobuf:"bytes,1,rep,name=chunks,proto3" json:"chunks,omitempty"`
+	// Wait for every chunk to be evaluated, see IngestRequest.wait.
+	Wait          bool `protobuf:"varint,2,opt,name=wait,proto3" json:"wait,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestBatchRequest) Reset() {
+	*x = IngestBatchRequest{}
+	mi := &file_proto_conversation_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestBatchRequest) ProtoMessage() {}
+
+func (x *IngestBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestBatchRequest.ProtoReflect.Descriptor instead.
+func (*IngestBatchRequest) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *IngestBatchRequest) GetChunks() []*ConversationChunk {
+	if x != nil {
+		return x.Chunks
+	}
+	return nil
+}
+
+func (x *IngestBatchRequest) GetWait() bool {
+	if x != nil {
+		return x.Wait
+	}
+	return false
+}
+
+// Outcome of ingesting one chunk.
+type IngestResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// success is false when the chunk was rejected; message says why.
+	Ack *ChunkAck `protobuf:"bytes,1,opt,name=ack,proto3" json:"ack,omitempty"`
+	// Whether the chunk was evaluated before returning (wait was set).
+	Evaluated bool `protobuf:"varint,2,opt,name=evaluated,proto3" json:"evaluated,omitempty"`
+	// Ids of the active rules that fired on the chunk.
+	MatchedRuleIds []string `protobuf:"bytes,3,rep,name=matched_rule_ids,json=matchedRuleIds,proto3" json:"matched_rule_ids,omitempty"`
+	// Ids of the shadow rules that would have fired.
+	ShadowRuleIds []string `protobuf:"bytes,4,rep,name=shadow_rule_ids,json=shadowRuleIds,proto3" json:"shadow_rule_ids,omitempty"`
+	// Escalations started by the chunk.
+	Escalations   []*EscalationEvent `protobuf:"bytes,5,rep,name=escalations,proto3" json:"escalations,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestResult) Reset() {
+	*x = IngestResult{}
+	mi := &file_proto_conversation_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestResult) ProtoMessage() {}
+
+func (x *IngestResult) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestResult.ProtoReflect.Descriptor instead.
+func (*IngestResult) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *IngestResult) GetAck() *ChunkAck {
+	if x != nil {
+		return x.Ack
+	}
+	return nil
+}
+
+func (x *IngestResult) GetEvaluated() bool {
+	if x != nil {
+		return x.Evaluated
+	}
+	return false
+}
+
+func (x *IngestResult) GetMatchedRuleIds() []string {
+	if x != nil {
+		return x.MatchedRuleIds
+	}
+	return nil
+}
+
+func (x *IngestResult) GetShadowRuleIds() []string {
+	if x != nil {
+		return x.ShadowRuleIds
+	}
+	return nil
+}
+
+func (x *IngestResult) GetEscalations() []*EscalationEvent {
+	if x != nil {
+		return x.Escalations
+	}
+	return nil
+}
+
+// Outcome of IngestBatch, in the order of the request chunks.
+type IngestBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*IngestResult        `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestBatchResponse) Reset() {
+	*x = IngestBatchResponse{}
+	mi := &file_proto_conversation_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestBatchResponse) ProtoMessage() {}
+
+func (x *IngestBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestBatchResponse.ProtoReflect.Descriptor instead.
+func (*IngestBatchResponse) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *IngestBatchResponse) GetResults() []*IngestResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+// Asks where to resume a session after a broken stream.
+type ResumeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SessionId     string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	ClientId      string                 `protobuf:"bytes,2,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResumeRequest) Reset() {
+	*x = ResumeRequest{}
+	mi := &file_proto_conversation_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResumeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResumeRequest) ProtoMessage() {}
+
+func (x *ResumeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResumeRequest.ProtoReflect.Descriptor instead.
+func (*ResumeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ResumeRequest) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *ResumeRequest) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+type ResumeResponse struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	SessionId string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	// Resend every chunk with a higher sequence.
+	LastProcessedSequence uint64 `protobuf:"varint,2,opt,name=last_processed_sequence,json=lastProcessedSequence,proto3" json:"last_processed_sequence,omitempty"`
+	// Whether the server still holds the session; when false it ended (or was
+	// never seen) and last_processed_sequence is 0.
+	Live          bool `protobuf:"varint,3,opt,name=live,proto3" json:"live,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResumeResponse) Reset() {
+	*x = ResumeResponse{}
+	mi := &file_proto_conversation_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResumeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResumeResponse) ProtoMessage() {}
+
+func (x *ResumeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResumeResponse.ProtoReflect.Descriptor instead.
+func (*ResumeResponse) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ResumeResponse) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *ResumeResponse) GetLastProcessedSequence() uint64 {
+	if x != nil {
+		return x.LastProcessedSequence
+	}
+	return 0
+}
+
+func (x *ResumeResponse) GetLive() bool {
+	if x != nil {
+		return x.Live
+	}
+	return false
+}
+
+// Ends a live session, e.g. when the stream that opened it closed on another
+// replica.
+type EndSessionRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	SessionId string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	// Why the session ended, recorded in its summary.
+	Reason        string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EndSessionRequest) Reset() {
+	*x = EndSessionRequest{}
+	mi := &file_proto_conversation_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EndSessionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EndSessionRequest) ProtoMessage() {}
+
+func (x *EndSessionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EndSessionRequest.ProtoReflect.Descriptor instead.
+func (*EndSessionRequest) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *EndSessionRequest) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *EndSessionRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type EndSessionResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Whether the session was live.
+	Ended         bool `protobuf:"varint,1,opt,name=ended,proto3" json:"ended,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EndSessionResponse) Reset() {
+	*x = EndSessionResponse{}
+	mi := &file_proto_conversation_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EndSessionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EndSessionResponse) ProtoMessage() {}
+
+func (x *EndSessionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EndSessionResponse.ProtoReflect.Descriptor instead.
+func (*EndSessionResponse) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *EndSessionResponse) GetEnded() bool {
+	if x != nil {
+		return x.Ended
+	}
+	return false
+}
+
+// Word counts of a sender.
+type WordCounts struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Counts        map[string]int64       `protobuf:"bytes,1,rep,name=counts,proto3" json:"counts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WordCounts) Reset() {
+	*x = WordCounts{}
+	mi := &file_proto_conversation_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WordCounts) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WordCounts) ProtoMessage() {}
+
+func (x *WordCounts) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WordCounts.ProtoReflect.Descriptor instead.
+func (*WordCounts) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *WordCounts) GetCounts() map[string]int64 {
+	if x != nil {
+		return x.Counts
+	}
+	return nil
+}
+
+// Ids of the most recent messages a word occurred in.
+type MessageIds struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ids           []string               `protobuf:"bytes,1,rep,name=ids,proto3" json:"ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MessageIds) Reset() {
+	*x = MessageIds{}
+	mi := &file_proto_conversation_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MessageIds) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MessageIds) ProtoMessage() {}
+
+func (x *MessageIds) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MessageIds.ProtoReflect.Descriptor instead.
+func (*MessageIds) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *MessageIds) GetIds() []string {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+// The in-memory state of a live session, so that another replica can carry
+// on with it. Interim transcripts in flight are not part of it.
+type SessionState struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Version of the format; see engine.SessionStateVersion.
+	Version    uint32           `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	SessionId  string           `protobuf:"bytes,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	WordCounts map[string]int64 `protobuf:"bytes,3,rep,name=word_counts,json=wordCounts,proto3" json:"word_counts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	// Keyed by lowercased sender.
+	SenderWordCounts map[string]*WordCounts `protobuf:"bytes,4,rep,name=sender_word_counts,json=senderWordCounts,proto3" json:"sender_word_counts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Keyed by lowercased sender.
+	TurnCounts       map[string]int64       `protobuf:"bytes,5,rep,name=turn_counts,json=turnCounts,proto3" json:"turn_counts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	Turns            int64                  `protobuf:"varint,6,opt,name=turns,proto3" json:"turns,omitempty"`
+	FirstTimestampMs int64                  `protobuf:"varint,7,opt,name=first_timestamp_ms,json=firstTimestampMs,proto3" json:"first_timestamp_ms,omitempty"`
+	LastTimestampMs  int64                  `protobuf:"varint,8,opt,name=last_timestamp_ms,json=lastTimestampMs,proto3" json:"last_timestamp_ms,omitempty"`
+	WordTurns        map[string]*MessageIds `protobuf:"bytes,9,rep,name=word_turns,json=wordTurns,proto3" json:"word_turns,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Every chunk up to this sequence was processed.
+	LastProcessedSequence uint64 `protobuf:"varint,10,opt,name=last_processed_sequence,json=lastProcessedSequence,proto3" json:"last_processed_sequence,omitempty"`
+	// Why processing the session failed midway, if it did.
+	Degraded string `protobuf:"bytes,11,opt,name=degraded,proto3" json:"degraded,omitempty"`
+	// The session is at risk or escalated.
+	Elevated bool `protobuf:"varint,12,opt,name=elevated,proto3" json:"elevated,omitempty"`
+	// Wall clock of the last chunk of the session, in unix millis, for the
+	// idle TTL. Added in version 2.
+	LastSeenMs    int64 `protobuf:"varint,13,opt,name=last_seen_ms,json=lastSeenMs,proto3" json:"last_seen_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SessionState) Reset() {
+	*x = SessionState{}
+	mi := &file_proto_conversation_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionState) ProtoMessage() {}
+
+func (x *SessionState) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionState.ProtoReflect.Descriptor instead.
+func (*SessionState) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *SessionState) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *SessionState) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *SessionState) GetWordCounts() map[string]int64 {
+	if x != nil {
+		return x.WordCounts
+	}
+	return nil
+}
+
+func (x *SessionState) GetSenderWordCounts() map[string]*WordCounts {
+	if x != nil {
+		return x.SenderWordCounts
+	}
+	return nil
+}
+
+func (x *SessionState) GetTurnCounts() map[string]int64 {
+	if x != nil {
+		return x.TurnCounts
+	}
+	return nil
+}
+
+func (x *SessionState) GetTurns() int64 {
+	if x != nil {
+		return x.Turns
+	}
+	return 0
+}
+
+func (x *SessionState) GetFirstTimestampMs() int64 {
+	if x != nil {
+		return x.FirstTimestampMs
+	}
+	return 0
+}
+
+func (x *SessionState) GetLastTimestampMs() int64 {
+	if x != nil {
+		return x.LastTimestampMs
+	}
+	return 0
+}
+
+func (x *SessionState) GetWordTurns() map[string]*MessageIds {
+	if x != nil {
+		return x.WordTurns
+	}
+	return nil
+}
+
+func (x *SessionState) GetLastProcessedSequence() uint64 {
+	if x != nil {
+		return x.LastProcessedSequence
+	}
+	return 0
+}
+
+func (x *SessionState) GetDegraded() string {
+	if x != nil {
+		return x.Degraded
+	}
+	return ""
+}
+
+func (x *SessionState) GetElevated() bool {
+	if x != nil {
+		return x.Elevated
+	}
+	return false
+}
+
+func (x *SessionState) GetLastSeenMs() int64 {
+	if x != nil {
+		return x.LastSeenMs
+	}
+	return 0
+}
+
+// The live sessions of a server, saved periodically and on shutdown and
+// restored on startup.
+type SessionSnapshot struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Version of the format; see snapshot.Version.
+	Version uint32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// When the snapshot was taken, in unix millis.
+	TakenAtMs     int64           `protobuf:"varint,2,opt,name=taken_at_ms,json=takenAtMs,proto3" json:"taken_at_ms,omitempty"`
+	Sessions      []*SessionState `protobuf:"bytes,3,rep,name=sessions,proto3" json:"sessions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SessionSnapshot) Reset() {
+	*x = SessionSnapshot{}
+	mi := &file_proto_conversation_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionSnapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionSnapshot) ProtoMessage() {}
+
+func (x *SessionSnapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionSnapshot.ProtoReflect.Descriptor instead.
+func (*SessionSnapshot) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *SessionSnapshot) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *SessionSnapshot) GetTakenAtMs() int64 {
+	if x != nil {
+		return x.TakenAtMs
+	}
+	return 0
+}
+
+func (x *SessionSnapshot) GetSessions() []*SessionState {
+	if x != nil {
+		return x.Sessions
+	}
+	return nil
+}
+
+// Hands a session over to its new owner when the replicas change.
+type HandOffRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Session *SessionState          `protobuf:"bytes,1,opt,name=session,proto3" json:"session,omitempty"`
+	// Id of the replica handing the session over.
+	From          string `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HandOffRequest) Reset() {
+	*x = HandOffRequest{}
+	mi := &file_proto_conversation_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HandOffRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HandOffRequest) ProtoMessage() {}
+
+func (x *HandOffRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HandOffRequest.ProtoReflect.Descriptor instead.
+func (*HandOffRequest) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *HandOffRequest) GetSession() *SessionState {
+	if x != nil {
+		return x.Session
+	}
+	return nil
+}
+
+func (x *HandOffRequest) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+type HandOffResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HandOffResponse) Reset() {
+	*x = HandOffResponse{}
+	mi := &file_proto_conversation_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HandOffResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HandOffResponse) ProtoMessage() {}
+
+func (x *HandOffResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HandOffResponse.ProtoReflect.Descriptor instead.
+func (*HandOffResponse) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{17}
+}
+
+// Selects the escalation events of SubscribeEscalations; empty fields match
+// everything.
+type SubscribeRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	ClientId  string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	SessionId string                 `protobuf:"bytes,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	RuleId    string                 `protobuf:"bytes,3,opt,name=rule_id,json=ruleId,proto3" json:"rule_id,omitempty"`
+	Action    string                 `protobuf:"bytes,4,opt,name=action,proto3" json:"action,omitempty"`
+	// Replay persisted events after this id before streaming live ones; 0
+	// streams live events only.
+	AfterEventId  int64                `protobuf:"varint,5,opt,name=after_event_id,json=afterEventId,proto3" json:"after_event_id,omitempty"`
+	SlowPolicy    SlowSubscriberPolicy `protobuf:"varint,6,opt,name=slow_policy,json=slowPolicy,proto3,enum=conversation.v1.SlowSubscriberPolicy" json:"slow_policy,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_proto_conversation_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *SubscribeRequest) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetRuleId() string {
+	if x != nil {
+		return x.RuleId
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetAfterEventId() int64 {
+	if x != nil {
+		return x.AfterEventId
+	}
+	return 0
+}
+
+func (x *SubscribeRequest) GetSlowPolicy() SlowSubscriberPolicy {
+	if x != nil {
+		return x.SlowPolicy
+	}
+	return SlowSubscriberPolicy_SLOW_SUBSCRIBER_DROP
+}
+
+// A message streamed back to the client of Converse.
+type ConversationEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Event:
+	//
+	//	*ConversationEvent_Ack
+	//	*ConversationEvent_Escalation
+	Event         isConversationEvent_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConversationEvent) Reset() {
+	*x = ConversationEvent{}
+	mi := &file_proto_conversation_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConversationEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConversationEvent) ProtoMessage() {}
+
+func (x *ConversationEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_conversation_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConversationEvent.ProtoReflect.Descriptor instead.
+func (*ConversationEvent) Descriptor() ([]byte, []int) {
+	return file_proto_conversation_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *ConversationEvent) GetEvent() isConversationEvent_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *ConversationEvent) GetAck() *ChunkAck {
+	if x != nil {
+		if x, ok := x.Event.(*ConversationEvent_Ack); ok {
+			return x.Ack
+		}
+	}
+	return nil
+}
+
+func (x *ConversationEvent) GetEscalation() *EscalationEvent {
+	if x != nil {
+		if x, ok := x.Event.(*ConversationEvent_Escalation); ok {
+			return x.Escalation
+		}
+	}
+	return nil
+}
+
+type isConversationEvent_Event interface {
+	isConversationEvent_Event()
+}
+
+type ConversationEvent_Ack struct {
+	Ack *ChunkAck `protobuf:"bytes,1,opt,name=ack,proto3,oneof"`
+}
+
+type ConversationEvent_Escalation struct {
+	Escalation *EscalationEvent `protobuf:"bytes,2,opt,name=escalation,proto3,oneof"`
+}
+
+func (*ConversationEvent_Ack) isConversationEvent_Event() {}
+
+func (*ConversationEvent_Escalation) isConversationEvent_Event() {}
+
+var File_proto_conversation_proto protoreflect.FileDescriptor
+
+const file_proto_conversation_proto_rawDesc = "" +
+	"\n" +
+	"\x18proto/conversation.proto\x12\x0fconversation.v1\"\xad\x03\n" +
+	"\x11ConversationChunk\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x02 \x01(\tR\tmessageId\x12\x16\n" +
+	"\x06sender\x18\x03 \x01(\tR\x06sender\x12\x12\n" +
+	"\x04text\x18\x04 \x01(\tR\x04text\x12!\n" +
+	"\ftimestamp_ms\x18\x05 \x01(\x03R\vtimestampMs\x12L\n" +
+	"\bmetadata\x18\x06 \x03(\v20.conversation.v1.ConversationChunk.MetadataEntryR\bmetadata\x12\x1b\n" +
+	"\tclient_id\x18\a \x01(\tR\bclientId\x12\x1a\n" +
+	"\bsequence\x18\b \x01(\x04R\bsequence\x12\x1e\n" +
+	"\bis_final\x18\t \x01(\bH\x00R\aisFinal\x88\x01\x01\x12\x1a\n" +
+	"\brevision\x18\n" +
+	" \x01(\rR\brevision\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\v\n" +
+	"\t_is_final\"\xd3\x02\n" +
+	"\fAnalyticsAck\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12&\n" +
+	"\x0flast_message_id\x18\x02 \x01(\tR\rlastMessageId\x12\x18\n" +
+	"\asuccess\x18\x03 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x04 \x01(\tR\amessage\x12\x1b\n" +
+	"\tclient_id\x18\a \x01(\tR\bclientId\x126\n" +
+	"\x17last_processed_sequence\x18\b \x01(\x04R\x15lastProcessedSequence\x12\x1e\n" +
+	"\n" +
+	"duplicates\x18\t \x01(\rR\n" +
+	"duplicates\x12\x1a\n" +
+	"\brejected\x18\n" +
+	" \x01(\rR\brejected\x12\x16\n" +
+	"\x06failed\x18\v \x01(\rR\x06failed\x12\x1f\n" +
+	"\vover_budget\x18\f \x01(\rR\n" +
+	"overBudget\"\x9d\x03\n" +
+	"\bChunkAck\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x02 \x01(\tR\tmessageId\x12\x18\n" +
+	"\asuccess\x18\x03 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x04 \x01(\tR\amessage\x12\x1b\n" +
+	"\tclient_id\x18\a \x01(\tR\bclientId\x12\x1a\n" +
+	"\bsequence\x18\b \x01(\x04R\bsequence\x126\n" +
+	"\x17last_processed_sequence\x18\t \x01(\x04R\x15lastProcessedSequence\x12\x1c\n" +
+	"\tduplicate\x18\n" +
+	" \x01(\bR\tduplicate\x12\x1a\n" +
+	"\bdegraded\x18\v \x01(\bR\bdegraded\x12\x1d\n" +
+	"\n" +
+	"latency_ms\x18\f \x01(\rR\tlatencyMs\x12\x1f\n" +
+	"\vover_budget\x18\r \x01(\bR\n" +
+	"overBudget\x12\x1a\n" +
+	"\brejected\x18\x0e \x01(\bR\brejected\x12\x18\n" +
+	"\ainvalid\x18\x0f \x01(\bR\ainvalid\"\xe4\x02\n" +
+	"\x0fEscalationEvent\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x02 \x01(\tR\tmessageId\x12\x17\n" +
+	"\arule_id\x18\x03 \x01(\tR\x06ruleId\x12\x1b\n" +
+	"\trule_name\x18\x04 \x01(\tR\bruleName\x12\x16\n" +
+	"\x06action\x18\x05 \x01(\tR\x06action\x12\x14\n" +
+	"\x05score\x18\x06 \x01(\x01R\x05score\x12\x19\n" +
+	"\btrace_id\x18\a \x01(\tR\atraceId\x12#\n" +
+	"\rescalation_id\x18\b \x01(\tR\fescalationId\x12!\n" +
+	"\ftimestamp_ms\x18\t \x01(\x03R\vtimestampMs\x12\x1b\n" +
+	"\tclient_id\x18\n" +
+	" \x01(\tR\bclientId\x12\x19\n" +
+	"\bevent_id\x18\v \x01(\x03R\aeventId\x12\x14\n" +
+	"\x05level\x18\f \x01(\x05R\x05level\"]\n" +
+	"\rIngestRequest\x128\n" +
+	"\x05chunk\x18\x01 \x01(\v2\".conversation.v1.ConversationChunkR\x05chunk\x12\x12\n" +
+	"\x04wait\x18\x02 \x01(\bR\x04wait\"d\n" +
+	"\x12IngestBatchRequest\x12:\n" +
+	"\x06chunks\x18\x01 \x03(\v2\".conversation.v1.ConversationChunkR\x06chunks\x12\x12\n" +
+	"\x04wait\x18\x02 \x01(\bR\x04wait\"\xef\x01\n" +
+	"\fIngestResult\x12+\n" +
+	"\x03ack\x18\x01 \x01(\v2\x19.conversation.v1.ChunkAckR\x03ack\x12\x1c\n" +
+	"\tevaluated\x18\x02 \x01(\bR\tevaluated\x12(\n" +
+	"\x10matched_rule_ids\x18\x03 \x03(\tR\x0ematchedRuleIds\x12&\n" +
+	"\x0fshadow_rule_ids\x18\x04 \x03(\tR\rshadowRuleIds\x12B\n" +
+	"\vescalations\x18\x05 \x03(\v2 .conversation.v1.EscalationEventR\vescalations\"N\n" +
+	"\x13IngestBatchResponse\x127\n" +
+	"\aresults\x18\x01 \x03(\v2\x1d.conversation.v1.IngestResultR\aresults\"K\n" +
+	"\rResumeRequest\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x1b\n" +
+	"\tclient_id\x18\x02 \x01(\tR\bclientId\"{\n" +
+	"\x0eResumeResponse\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x126\n" +
+	"\x17last_processed_sequence\x18\x02 \x01(\x04R\x15lastProcessedSequence\x12\x12\n" +
+	"\x04live\x18\x03 \x01(\bR\x04live\"J\n" +
+	"\x11EndSessionRequest\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\"*\n" +
+	"\x12EndSessionResponse\x12\x14\n" +
+	"\x05ended\x18\x01 \x01(\bR\x05ended\"\x88\x01\n" +
+	"\n" +
+	"WordCounts\x12?\n" +
+	"\x06counts\x18\x01 \x03(\v2'.conversation.v1.WordCounts.CountsEntryR\x06counts\x1a9\n" +
+	"\vCountsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\"\x1e\n" +
+	"\n" +
+	"MessageIds\x12\x10\n" +
+	"\x03ids\x18\x01 \x03(\tR\x03ids\"\xd4\a\n" +
+	"\fSessionState\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\rR\aversion\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x02 \x01(\tR\tsessionId\x12N\n" +
+	"\vword_counts\x18\x03 \x03(\v2-.conversation.v1.SessionState.WordCountsEntryR\n" +
+	"wordCounts\x12a\n" +
+	"\x12sender_word_counts\x18\x04 \x03(\v23.conversation.v1.SessionState.SenderWordCountsEntryR\x10senderWordCounts\x12N\n" +
+	"\vturn_counts\x18\x05 \x03(\v2-.conversation.v1.SessionState.TurnCountsEntryR\n" +
+	"turnCounts\x12\x14\n" +
+	"\x05turns\x18\x06 \x01(\x03R\x05turns\x12,\n" +
+	"\x12first_timestamp_ms\x18\a \x01(\x03R\x10firstTimestampMs\x12*\n" +
+	"\x11last_timestamp_ms\x18\b \x01(\x03R\x0flastTimestampMs\x12K\n" +
+	"\n" +
+	"word_turns\x18\t \x03(\v2,.conversation.v1.SessionState.WordTurnsEntryR\twordTurns\x126\n" +
+	"\x17last_processed_sequence\x18\n" +
+	" \x01(\x04R\x15lastProcessedSequence\x12\x1a\n" +
+	"\bdegraded\x18\v \x01(\tR\bdegraded\x12\x1a\n" +
+	"\belevated\x18\f \x01(\bR\belevated\x12 \n" +
+	"\flast_seen_ms\x18\r \x01(\x03R\n" +
+	"lastSeenMs\x1a=\n" +
+	"\x0fWordCountsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\x1a`\n" +
+	"\x15SenderWordCountsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x121\n" +
+	"\x05value\x18\x02 \x01(\v2\x1b.conversation.v1.WordCountsR\x05value:\x028\x01\x1a=\n" +
+	"\x0fTurnCountsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\x1aY\n" +
+	"\x0eWordTurnsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x121\n" +
+	"\x05value\x18\x02 \x01(\v2\x1b.conversation.v1.MessageIdsR\x05value:\x028\x01\"\x86\x01\n" +
+	"\x0fSessionSnapshot\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\rR\aversion\x12\x1e\n" +
+	"\vtaken_at_ms\x18\x02 \x01(\x03R\ttakenAtMs\x129\n" +
+	"\bsessions\x18\x03 \x03(\v2\x1d.conversation.v1.SessionStateR\bsessions\"]\n" +
+	"\x0eHandOffRequest\x127\n" +
+	"\asession\x18\x01 \x01(\v2\x1d.conversation.v1.SessionStateR\asession\x12\x12\n" +
+	"\x04from\x18\x02 \x01(\tR\x04from\"\x11\n" +
+	"\x0fHandOffResponse\"\xed\x01\n" +
+	"\x10SubscribeRequest\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x02 \x01(\tR\tsessionId\x12\x17\n" +
+	"\arule_id\x18\x03 \x01(\tR\x06ruleId\x12\x16\n" +
+	"\x06action\x18\x04 \x01(\tR\x06action\x12$\n" +
+	"\x0eafter_event_id\x18\x05 \x01(\x03R\fafterEventId\x12F\n" +
+	"\vslow_policy\x18\x06 \x01(\x0e2%.conversation.v1.SlowSubscriberPolicyR\n" +
+	"slowPolicy\"\x8f\x01\n" +
+	"\x11ConversationEvent\x12-\n" +
+	"\x03ack\x18\x01 \x01(\v2\x19.conversation.v1.ChunkAckH\x00R\x03ack\x12B\n" +
+	"\n" +
+	"escalation\x18\x02 \x01(\v2 .conversation.v1.EscalationEventH\x00R\n" +
+	"escalationB\a\n" +
+	"\x05event*P\n" +
+	"\x14SlowSubscriberPolicy\x12\x18\n" +
+	"\x14SLOW_SUBSCRIBER_DROP\x10\x00\x12\x1e\n" +
+	"\x1aSLOW_SUBSCRIBER_DISCONNECT\x10\x012\xc2\x05\n" +
+	"\x12ConversationStream\x12Y\n" +
+	"\x12StreamConversation\x12\".conversation.v1.ConversationChunk\x1a\x1d.conversation.v1.AnalyticsAck(\x01\x12V\n" +
+	"\bConverse\x12\".conversation.v1.ConversationChunk\x1a\".conversation.v1.ConversationEvent(\x010\x01\x12]\n" +
+	"\x14SubscribeEscalations\x12!.conversation.v1.SubscribeRequest\x1a .conversation.v1.EscalationEvent0\x01\x12L\n" +
+	"\vIngestChunk\x12\x1e.conversation.v1.IngestRequest\x1a\x1d.conversation.v1.IngestResult\x12X\n" +
+	"\vIngestBatch\x12#.conversation.v1.IngestBatchRequest\x1a$.conversation.v1.IngestBatchResponse\x12M\n" +
+	"\n" +
+	"ResumeFrom\x12\x1e.conversation.v1.ResumeRequest\x1a\x1f.conversation.v1.ResumeResponse\x12U\n" +
+	"\n" +
+	"EndSession\x12\".conversation.v1.EndSessionRequest\x1a#.conversation.v1.EndSessionResponse\x12L\n" +
+	"\aHandOff\x12\x1f.conversation.v1.HandOffRequest\x1a .conversation.v1.HandOffResponseB_Z]github.com/kaphack/lowlatency-realtime-conversation-ai-escalation-system/proto;conversationv1b\x06proto3"
+
+var (
+	file_proto_conversation_proto_rawDescOnce sync.Once
+	file_proto_conversation_proto_rawDescData []byte
+)
+
+func file_proto_conversation_proto_rawDescGZIP() []byte {
+	file_proto_conversation_proto_rawDescOnce.Do(func() {
+		file_proto_conversation_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_conversation_proto_rawDesc), len(file_proto_conversation_proto_rawDesc)))
+	})
+	return file_proto_conversation_proto_rawDescData
+}
+
+var file_proto_conversation_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_proto_conversation_proto_msgTypes = make([]protoimpl.MessageInfo, 26)
+var file_proto_conversation_proto_goTypes = []any{
+	(SlowSubscriberPolicy)(0),   // 0: conversation.v1.SlowSubscriberPolicy
+	(*ConversationChunk)(nil),   // 1: conversation.v1.ConversationChunk
+	(*AnalyticsAck)(nil),        // 2: conversation.v1.AnalyticsAck
+	(*ChunkAck)(nil),            // 3: conversation.v1.ChunkAck
+	(*EscalationEvent)(nil),     // 4: conversation.v1.EscalationEvent
+	(*IngestRequest)(nil),       // 5: conversation.v1.IngestRequest
+	(*IngestBatchRequest)(nil),  // 6: conversation.v1.IngestBatchRequest
+	(*IngestResult)(nil),        // 7: conversation.v1.IngestResult
+	(*IngestBatchResponse)(nil), // 8: conversation.v1.IngestBatchResponse
+	(*ResumeRequest)(nil),       // 9: conversation.v1.ResumeRequest
+	(*ResumeResponse)(nil),      // 10: conversation.v1.ResumeResponse
+	(*EndSessionRequest)(nil),   // 11: conversation.v1.EndSessionRequest
+	(*EndSessionResponse)(nil),  // 12: conversation.v1.EndSessionResponse
+	(*WordCounts)(nil),          // 13: conversation.v1.WordCounts
+	(*MessageIds)(nil),          // 14: conversation.v1.MessageIds
+	(*SessionState)(nil),        // 15: conversation.v1.SessionState
+	(*SessionSnapshot)(nil),     // 16: conversation.v1.SessionSnapshot
+	(*HandOffRequest)(nil),      // 17: conversation.v1.HandOffRequest
+	(*HandOffResponse)(nil),     // 18: conversation.v1.HandOffResponse
+	(*SubscribeRequest)(nil),    // 19: conversation.v1.SubscribeRequest
+	(*ConversationEvent)(nil),   // 20: conversation.v1.ConversationEvent
+	nil,                         // 21: conversation.v1.ConversationChunk.MetadataEntry
+	nil,                         // 22: conversation.v1.WordCounts.CountsEntry
+	nil,                         // 23: conversation.v1.SessionState.WordCountsEntry
+	nil,                         // 24: conversation.v1.SessionState.SenderWordCountsEntry
+	nil,                         // 25: conversation.v1.SessionState.TurnCountsEntry
+	nil,                         // 26: conversation.v1.SessionState.WordTurnsEntry
+}
+var file_proto_conversation_proto_depIdxs = []int32{
+	21, // 0: conversation.v1.ConversationChunk.metadata:type_name -> conversation.v1.ConversationChunk.MetadataEntry
+	1,  // 1: conversation.v1.IngestRequest.chunk:type_name -> conversation.v1.ConversationChunk
+	1,  // 2: conversation.v1.IngestBatchRequest.chunks:type_name -> conversation.v1.ConversationChunk
+	3,  // 3: conversation.v1.IngestResult.ack:type_name -> conversation.v1.ChunkAck
+	4,  // 4: conversation.v1.IngestResult.escalations:type_name -> conversation.v1.EscalationEvent
+	7,  // 5: conversation.v1.IngestBatchResponse.results:type_name -> conversation.v1.IngestResult
+	22, // 6: conversation.v1.WordCounts.counts:type_name -> conversation.v1.WordCounts.CountsEntry
+	23, // 7: conversation.v1.SessionState.word_counts:type_name -> conversation.v1.SessionState.WordCountsEntry
+	24, // 8: conversation.v1.SessionState.sender_word_counts:type_name -> conversation.v1.SessionState.SenderWordCountsEntry
+	25, // 9: conversation.v1.SessionState.turn_counts:type_name -> conversation.v1.SessionState.TurnCountsEntry
+	26, // 10: conversation.v1.SessionState.word_turns:type_name -> conversation.v1.SessionState.WordTurnsEntry
+	15, // 11: conversation.v1.SessionSnapshot.sessions:type_name -> conversation.v1.SessionState
+	15, // 12: conversation.v1.HandOffRequest.session:type_name -> conversation.v1.SessionState
+	0,  // 13: conversation.v1.SubscribeRequest.slow_policy:type_name -> conversation.v1.SlowSubscriberPolicy
+	3,  // 14: conversation.v1.ConversationEvent.ack:type_name -> conversation.v1.ChunkAck
+	4,  // 15: conversation.v1.ConversationEvent.escalation:type_name -> conversation.v1.EscalationEvent
+	13, // 16: conversation.v1.SessionState.SenderWordCountsEntry.value:type_name -> conversation.v1.WordCounts
+	14, // 17: conversation.v1.SessionState.WordTurnsEntry.value:type_name -> conversation.v1.MessageIds
+	1,  // 18: conversation.v1.ConversationStream.StreamConversation:input_type -> conversation.v1.ConversationChunk
+	1,  // 19: conversation.v1.ConversationStream.Converse:input_type -> conversation.v1.ConversationChunk
+	19, // 20: conversation.v1.ConversationStream.SubscribeEscalations:input_type -> conversation.v1.SubscribeRequest
+	5,  // 21: conversation.v1.ConversationStream.IngestChunk:input_type -> conversation.v1.IngestRequest
+	6,  // 22: conversation.v1.ConversationStream.IngestBatch:input_type -> conversation.v1.IngestBatchRequest
+	9,  // 23: conversation.v1.ConversationStream.ResumeFrom:input_type -> conversation.v1.ResumeRequest
+	11, // 24: conversation.v1.ConversationStream.EndSession:input_type -> conversation.v1.EndSessionRequest
+	17, // 25: conversation.v1.ConversationStream.HandOff:input_type -> conversation.v1.HandOffRequest
+	2,  // 26: conversation.v1.ConversationStream.StreamConversation:output_type -> conversation.v1.AnalyticsAck
+	20, // 27: conversation.v1.ConversationStream.Converse:output_type -> conversation.v1.ConversationEvent
+	4,  // 28: conversation.v1.ConversationStream.SubscribeEscalations:output_type -> conversation.v1.EscalationEvent
+	7,  // 29: conversation.v1.ConversationStream.IngestChunk:output_type -> conversation.v1.IngestResult
+	8,  // 30: conversation.v1.ConversationStream.IngestBatch:output_type -> conversation.v1.IngestBatchResponse
+	10, // 31: conversation.v1.ConversationStream.ResumeFrom:output_type -> conversation.v1.ResumeResponse
+	12, // 32: conversation.v1.ConversationStream.EndSession:output_type -> conversation.v1.EndSessionResponse
+	18, // 33: conversation.v1.ConversationStream.HandOff:output_type -> conversation.v1.HandOffResponse
+	26, // [26:34] is the sub-list for method output_type
+	18, // [18:26] is the sub-list for method input_type
+	18, // [18:18] is the sub-list for extension type_name
+	18, // [18:18] is the sub-list for extension extendee
+	0,  // [0:18] is the sub-list for field type_name
+}
+
+func init() { file_proto_conversation_proto_init() }
+func file_proto_conversation_proto_init() {
+	if File_proto_conversation_proto != nil {
+		return
+	}
+	file_proto_conversation_proto_msgTypes[0].OneofWrappers = []any{}
+	file_proto_conversation_proto_msgTypes[19].OneofWrappers = []any{
+		(*ConversationEvent_Ack)(nil),
+		(*ConversationEvent_Escalation)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_conversation_proto_rawDesc), len(file_proto_conversation_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   3,
+			NumEnums:      1,
+			NumMessages:   26,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_proto_conversation_proto_goTypes,
 		DependencyIndexes: file_proto_conversation_proto_depIdxs,
+		EnumInfos:         file_proto_conversation_proto_enumTypes,
 		MessageInfos:      file_proto_conversation_proto_msgTypes,
 	}.Build()
 	File_proto_conversation_proto = out.File
@@ -19,7 +19,14 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	ConversationStream_StreamConversation_FullMethodName = "/conversation.v1.ConversationStream/StreamConversation"
+	ConversationStream_StreamConversation_FullMethodName   = "/conversation.v1.ConversationStream/StreamConversation"
+	ConversationStream_Converse_FullMethodName             = "/conversation.v1.ConversationStream/Converse"
+	ConversationStream_SubscribeEscalations_FullMethodName = "/conversation.v1.ConversationStream/SubscribeEscalations"
+	ConversationStream_IngestChunk_FullMethodName          = "/conversation.v1.ConversationStream/IngestChunk"
+	ConversationStream_IngestBatch_FullMethodName          = "/conversation.v1.ConversationStream/IngestBatch"
+	ConversationStream_ResumeFrom_FullMethodName           = "/conversation.v1.ConversationStream/ResumeFrom"
+	ConversationStream_EndSession_FullMethodName           = "/conversation.v1.ConversationStream/EndSession"
+	ConversationStream_HandOff_FullMethodName              = "/conversation.v1.ConversationStream/HandOff"
 )
 
 // ConversationStreamClient is the client API for ConversationStream service.
@@ -32,6 +39,28 @@ type ConversationStreamClient interface {
 	// server processes them in real-time and responds with a single ACK
 	// when the client closes the stream.
 	StreamConversation(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[ConversationChunk, AnalyticsAck], error)
+	// Like StreamConversation, but the server streams back an ack for every
+	// chunk and an EscalationEvent as soon as a rule fires, while the
+	// conversation is ongoing. The server closes its side once every chunk sent
+	// before the client closed has been processed.
+	Converse(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ConversationChunk, ConversationEvent], error)
+	// Streams escalation events as they are published, e.g. for supervisor
+	// dashboards and routing systems that don't produce the conversation.
+	SubscribeEscalations(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[EscalationEvent], error)
+	// Ingests a single chunk without opening a stream, for stateless callers
+	// such as the REST gateway.
+	IngestChunk(ctx context.Context, in *IngestRequest, opts ...grpc.CallOption) (*IngestResult, error)
+	// Ingests several chunks in one call.
+	IngestBatch(ctx context.Context, in *IngestBatchRequest, opts ...grpc.CallOption) (*IngestBatchResponse, error)
+	// Handshake before reopening a broken stream: returns the highest
+	// contiguously processed sequence of the session, after every chunk already
+	// received for it has been processed.
+	ResumeFrom(ctx context.Context, in *ResumeRequest, opts ...grpc.CallOption) (*ResumeResponse, error)
+	// Ends a live session once the chunks already received for it have been
+	// processed.
+	EndSession(ctx context.Context, in *EndSessionRequest, opts ...grpc.CallOption) (*EndSessionResponse, error)
+	// Between replicas: takes over a session whose owner changed.
+	HandOff(ctx context.Context, in *HandOffRequest, opts ...grpc.CallOption) (*HandOffResponse, error)
 }
 
 type conversationStreamClient struct {
@@ -55,6 +84,88 @@ func (c *conversationStreamClient) StreamConversation(ctx context.Context, opts
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type ConversationStream_StreamConversationClient = grpc.ClientStreamingClient[ConversationChunk, AnalyticsAck]
 
+func (c *conversationStreamClient) Converse(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ConversationChunk, ConversationEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ConversationStream_ServiceDesc.Streams[1], ConversationStream_Converse_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ConversationChunk, ConversationEvent]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ConversationStream_ConverseClient = grpc.BidiStreamingClient[ConversationChunk, ConversationEvent]
+
+func (c *conversationStreamClient) SubscribeEscalations(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[EscalationEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ConversationStream_ServiceDesc.Streams[2], ConversationStream_SubscribeEscalations_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, EscalationEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ConversationStream_SubscribeEscalationsClient = grpc.ServerStreamingClient[EscalationEvent]
+
+func (c *conversationStreamClient) IngestChunk(ctx context.Context, in *IngestRequest, opts ...grpc.CallOption) (*IngestResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IngestResult)
+	err := c.cc.Invoke(ctx, ConversationStream_IngestChunk_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *conversationStreamClient) IngestBatch(ctx context.Context, in *IngestBatchRequest, opts ...grpc.CallOption) (*IngestBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IngestBatchResponse)
+	err := c.cc.Invoke(ctx, ConversationStream_IngestBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *conversationStreamClient) ResumeFrom(ctx context.Context, in *ResumeRequest, opts ...grpc.CallOption) (*ResumeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResumeResponse)
+	err := c.cc.Invoke(ctx, ConversationStream_ResumeFrom_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *conversationStreamClient) EndSession(ctx context.Context, in *EndSessionRequest, opts ...grpc.CallOption) (*EndSessionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EndSessionResponse)
+	err := c.cc.Invoke(ctx, ConversationStream_EndSession_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *conversationStreamClient) HandOff(ctx context.Context, in *HandOffRequest, opts ...grpc.CallOption) (*HandOffResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HandOffResponse)
+	err := c.cc.Invoke(ctx, ConversationStream_HandOff_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ConversationStreamServer is the server API for ConversationStream service.
 // All implementations must embed UnimplementedConversationStreamServer
 // for forward compatibility.
@@ -65,6 +176,28 @@ type ConversationStreamServer interface {
 	// server processes them in real-time and responds with a single ACK
 	// when the client closes the stream.
 	StreamConversation(grpc.ClientStreamingServer[ConversationChunk, AnalyticsAck]) error
+	// Like StreamConversation, but the server streams back an ack for every
+	// chunk and an EscalationEvent as soon as a rule fires, while the
+	// conversation is ongoing. The server closes its side once every chunk sent
+	// before the client closed has been processed.
+	Converse(grpc.BidiStreamingServer[ConversationChunk, ConversationEvent]) error
+	// Streams escalation events as they are published, e.g. for supervisor
+	// dashboards and routing systems that don't produce the conversation.
+	SubscribeEscalations(*SubscribeRequest, grpc.ServerStreamingServer[EscalationEvent]) error
+	// Ingests a single chunk without opening a stream, for stateless callers
+	// such as the REST gateway.
+	IngestChunk(context.Context, *IngestRequest) (*IngestResult, error)
+	// Ingests several chunks in one call.
+	IngestBatch(context.Context, *IngestBatchRequest) (*IngestBatchResponse, error)
+	// Handshake before reopening a broken stream: returns the highest
+	// contiguously processed sequence of the session, after every chunk already
+	// received for it has been processed.
+	ResumeFrom(context.Context, *ResumeRequest) (*ResumeResponse, error)
+	// Ends a live session once the chunks already received for it have been
+	// processed.
+	EndSession(context.Context, *EndSessionRequest) (*EndSessionResponse, error)
+	// Between replicas: takes over a session whose owner changed.
+	HandOff(context.Context, *HandOffRequest) (*HandOffResponse, error)
 	mustEmbedUnimplementedConversationStreamServer()
 }
 
@@ -78,6 +211,27 @@ type UnimplementedConversationStreamServer struct{}
 func (UnimplementedConversationStreamServer) StreamConversation(grpc.ClientStreamingServer[ConversationChunk, AnalyticsAck]) error {
 	return status.Error(codes.Unimplemented, "method StreamConversation not implemented")
 }
+func (UnimplementedConversationStreamServer) Converse(grpc.BidiStreamingServer[ConversationChunk, ConversationEvent]) error {
+	return status.Error(codes.Unimplemented, "method Converse not implemented")
+}
+func (UnimplementedConversationStreamServer) SubscribeEscalations(*SubscribeRequest, grpc.ServerStreamingServer[EscalationEvent]) error {
+	return status.Error(codes.Unimplemented, "method SubscribeEscalations not implemented")
+}
+func (UnimplementedConversationStreamServer) IngestChunk(context.Context, *IngestRequest) (*IngestResult, error) {
+	return nil, status.Error(codes.Unimplemented, "method IngestChunk not implemented")
+}
+func (UnimplementedConversationStreamServer) IngestBatch(context.Context, *IngestBatchRequest) (*IngestBatchResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method IngestBatch not implemented")
+}
+func (UnimplementedConversationStreamServer) ResumeFrom(context.Context, *ResumeRequest) (*ResumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ResumeFrom not implemented")
+}
+func (UnimplementedConversationStreamServer) EndSession(context.Context, *EndSessionRequest) (*EndSessionResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method EndSession not implemented")
+}
+func (UnimplementedConversationStreamServer) HandOff(context.Context, *HandOffRequest) (*HandOffResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method HandOff not implemented")
+}
 func (UnimplementedConversationStreamServer) mustEmbedUnimplementedConversationStreamServer() {}
 func (UnimplementedConversationStreamServer) testEmbeddedByValue()                            {}
 
@@ -106,19 +260,159 @@ func _ConversationStream_StreamConversation_Handler(srv interface{}, stream grpc
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type ConversationStream_StreamConversationServer = grpc.ClientStreamingServer[ConversationChunk, AnalyticsAck]
 
+func _ConversationStream_Converse_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ConversationStreamServer).Converse(&grpc.GenericServerStream[ConversationChunk, ConversationEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ConversationStream_ConverseServer = grpc.BidiStreamingServer[ConversationChunk, ConversationEvent]
+
+func _ConversationStream_SubscribeEscalations_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConversationStreamServer).SubscribeEscalations(m, &grpc.GenericServerStream[SubscribeRequest, EscalationEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ConversationStream_SubscribeEscalationsServer = grpc.ServerStreamingServer[EscalationEvent]
+
+func _ConversationStream_IngestChunk_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IngestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConversationStreamServer).IngestChunk(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConversationStream_IngestChunk_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConversationStreamServer).IngestChunk(ctx, req.(*IngestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ConversationStream_IngestBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IngestBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConversationStreamServer).IngestBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConversationStream_IngestBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConversationStreamServer).IngestBatch(ctx, req.(*IngestBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ConversationStream_ResumeFrom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConversationStreamServer).ResumeFrom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConversationStream_ResumeFrom_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConversationStreamServer).ResumeFrom(ctx, req.(*ResumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ConversationStream_EndSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EndSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConversationStreamServer).EndSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConversationStream_EndSession_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConversationStreamServer).EndSession(ctx, req.(*EndSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ConversationStream_HandOff_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HandOffRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConversationStreamServer).HandOff(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConversationStream_HandOff_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConversationStreamServer).HandOff(ctx, req.(*HandOffRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ConversationStream_ServiceDesc is the grpc.ServiceDesc for ConversationStream service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var ConversationStream_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "conversation.v1.ConversationStream",
 	HandlerType: (*ConversationStreamServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "IngestChunk",
+			Handler:    _ConversationStream_IngestChunk_Handler,
+		},
+		{
+			MethodName: "IngestBatch",
+			Handler:    _ConversationStream_IngestBatch_Handler,
+		},
+		{
+			MethodName: "ResumeFrom",
+			Handler:    _ConversationStream_ResumeFrom_Handler,
+		},
+		{
+			MethodName: "EndSession",
+			Handler:    _ConversationStream_EndSession_Handler,
+		},
+		{
+			MethodName: "HandOff",
+			Handler:    _ConversationStream_HandOff_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "StreamConversation",
 			Handler:       _ConversationStream_StreamConversation_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "Converse",
+			Handler:       _ConversationStream_Converse_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "SubscribeEscalations",
+			Handler:       _ConversationStream_SubscribeEscalations_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "proto/conversation.proto",
 }